@@ -7,8 +7,9 @@ import (
 	"github.com/indigo-web/utils/constraint"
 )
 
+// DefaultHeaders advertise the content codings, which are supported in requests
 var DefaultHeaders = map[string]string{
-	"Accept-Encodings": "identity",
+	"Accept-Encoding": "gzip, deflate, zstd, br",
 }
 
 type (
@@ -94,27 +95,115 @@ type (
 		ResponseBuffSize int
 		// FileBuffSize defines the size of the read buffer when reading a file
 		FileBuffSize int
+		// ETag defines how entity tags of files are generated. Responses, which already
+		// have the ETag header set, are left as is
+		ETag ETag
+	}
+
+	HTTP2 struct {
+		// MaxConcurrentStreams limits how many streams may a single client keep open
+		// simultaneously. Streams exceeding the limit are refused.
+		MaxConcurrentStreams uint32
+		// StreamWindowSize is the flow control window of a single stream, i.e. how many
+		// bytes of the request body may the client send ahead, until the handler reads them.
+		StreamWindowSize uint32
+		// ConnWindowSize is the flow control window of the whole connection, shared between
+		// all the streams.
+		ConnWindowSize uint32
+	}
+
+	Compression struct {
+		// Codings are the content codings, which responses may be compressed with. If the
+		// client prefers several of them equally, the one that is listed earlier is chosen.
+		// Empty non-nil slice disables the compression.
+		Codings []string
+		// MinSize is the minimal size of a response body to be compressed. Smaller bodies
+		// don't benefit from the compression much. Attachments of unknown size are always
+		// compressed.
+		MinSize int
+		// MIMEs is the allowlist of compressible content types. A trailing wildcard, like
+		// "text/*", matches all the subtypes.
+		MIMEs []string
 	}
 
 	NET struct {
 		// ReadBufferSize is a size of buffer in bytes which will be used to read from
 		// socket
 		ReadBufferSize int
-		// ReadTimeout controls the maximal lifetime of IDLE connections. If no data was
-		// received in this period of time, it'll be closed.
+		// IdleTimeout controls the maximal lifetime of IDLE connections. If no request was
+		// received in this period of time, the connection is silently closed.
+		IdleTimeout time.Duration
+		// ReadTimeout controls the maximal lifetime of IDLE connections.
+		//
+		// Deprecated: use IdleTimeout instead. The value is used as IdleTimeout, unless
+		// the latter is set to the non-default one.
 		ReadTimeout time.Duration
+		// HeaderReadTimeout limits how long may the request line and headers be read, starting
+		// from the moment the first byte of the request was received. This protects from
+		// slowloris-like attacks, as unlike idle timeout, it isn't extended by each read.
+		HeaderReadTimeout time.Duration
+		// BodyReadTimeout limits how long to wait for the next piece of the request's body.
+		BodyReadTimeout time.Duration
+		// WriteTimeout limits how long may a single write into the connection last. This
+		// prevents slow readers from holding the connection forever.
+		WriteTimeout time.Duration
 		// AcceptLoopInterruptPeriod controls how often will the Accept() call be interrupted
 		// in order to check whether it's time to stop. Defaults to 5 seconds.
+		//
+		// Deprecated: stopping closes the listener directly, so the Accept() call isn't
+		// interrupted periodically anymore. The value is ignored.
 		AcceptLoopInterruptPeriod time.Duration
+		// ProxyHeaderTimeout limits how long is the PROXY protocol header waited for on
+		// connections from the trusted networks. Defaults to 5 seconds.
+		ProxyHeaderTimeout time.Duration
+		// ShutdownTimeout limits how long the graceful shutdown may last, when it was triggered
+		// by a context or a signal. Connections, that are still alive after it, are closed
+		// forcibly. Defaults to 30 seconds.
+		ShutdownTimeout time.Duration
+		// MaxConnections limits the number of concurrent connections process-wide, i.e.
+		// across all the listeners of the application. Zero means no limit.
+		MaxConnections int
+		// MaxConnectionsPerIP limits the number of concurrent connections from a single
+		// remote IP address process-wide. Zero means no limit.
+		MaxConnectionsPerIP int
+		// LimitPolicy defines what happens to connections, exceeding the limits above.
+		LimitPolicy LimitPolicy
 	}
 )
 
+// LimitPolicy defines how connections, exceeding the connection limits, are rejected
+type LimitPolicy uint8
+
+const (
+	// LimitRespond writes a pre-rendered 503 Service Unavailable response and closes
+	// the connection. This is the default policy
+	LimitRespond LimitPolicy = iota
+	// LimitDrop closes the connection immediately
+	LimitDrop
+)
+
+// ETag defines how entity tags of files are generated
+type ETag uint8
+
+const (
+	// ETagWeak is derived from the modification time and the size of the file. It's
+	// cheap, however tells nothing about the actual contents. This is the default
+	ETagWeak ETag = iota
+	// ETagStrong is a hash of the file contents. Files are hashed once per modification
+	// and the results are cached, yet the first request to a big file may take a while
+	ETagStrong
+	// ETagDisabled disables entity tags of files
+	ETagDisabled
+)
+
 type Config struct {
-	URL     URL
-	Headers Headers
-	Body    Body
-	HTTP    HTTP
-	NET     NET
+	URL         URL
+	Headers     Headers
+	Body        Body
+	HTTP        HTTP
+	HTTP2       HTTP2
+	Compression Compression
+	NET         NET
 }
 
 // Default returns default config. Those are initially well-balanced, however maximal defaults
@@ -171,10 +260,29 @@ func Default() *Config {
 			ResponseBuffSize: 1024,
 			FileBuffSize:     64 * 1024, // 64kb read buffer for files is pretty much sufficient
 		},
+		HTTP2: HTTP2{
+			MaxConcurrentStreams: 100,
+			StreamWindowSize:     1024 * 1024,     // 1mb
+			ConnWindowSize:       4 * 1024 * 1024, // 4mb
+		},
+		Compression: Compression{
+			Codings: []string{"zstd", "br", "gzip"},
+			// bodies below the usual MTU fit a single packet anyway
+			MinSize: 1024,
+			MIMEs: []string{
+				"text/*", "application/json", "application/javascript", "application/xml",
+				"application/yaml", "application/wasm", "image/svg+xml",
+			},
+		},
 		NET: NET{
 			ReadBufferSize:            4 * 1024, // 4kb is more than enough for ordinary requests.
-			ReadTimeout:               90 * time.Second,
+			IdleTimeout:               90 * time.Second,
+			HeaderReadTimeout:         30 * time.Second,
+			BodyReadTimeout:           60 * time.Second,
+			WriteTimeout:              60 * time.Second,
 			AcceptLoopInterruptPeriod: 5 * time.Second,
+			ProxyHeaderTimeout:        5 * time.Second,
+			ShutdownTimeout:           30 * time.Second,
 		},
 	}
 }
@@ -219,15 +327,44 @@ func Fill(src *Config) (new *Config) {
 		HTTP: HTTP{
 			ResponseBuffSize: either(src.HTTP.ResponseBuffSize, defaults.HTTP.ResponseBuffSize),
 			FileBuffSize:     either(src.HTTP.FileBuffSize, defaults.HTTP.FileBuffSize),
+			ETag:             src.HTTP.ETag,
+		},
+		HTTP2: HTTP2{
+			MaxConcurrentStreams: either(src.HTTP2.MaxConcurrentStreams, defaults.HTTP2.MaxConcurrentStreams),
+			StreamWindowSize:     either(src.HTTP2.StreamWindowSize, defaults.HTTP2.StreamWindowSize),
+			ConnWindowSize:       either(src.HTTP2.ConnWindowSize, defaults.HTTP2.ConnWindowSize),
+		},
+		Compression: Compression{
+			Codings: sliceOr(src.Compression.Codings, defaults.Compression.Codings),
+			MinSize: either(src.Compression.MinSize, defaults.Compression.MinSize),
+			MIMEs:   sliceOr(src.Compression.MIMEs, defaults.Compression.MIMEs),
 		},
 		NET: NET{
 			ReadBufferSize:            either(src.NET.ReadBufferSize, defaults.NET.ReadBufferSize),
-			ReadTimeout:               either(src.NET.ReadTimeout, defaults.NET.ReadTimeout),
+			IdleTimeout:               idleTimeout(src.NET, defaults.NET),
+			HeaderReadTimeout:         either(src.NET.HeaderReadTimeout, defaults.NET.HeaderReadTimeout),
+			BodyReadTimeout:           either(src.NET.BodyReadTimeout, defaults.NET.BodyReadTimeout),
+			WriteTimeout:              either(src.NET.WriteTimeout, defaults.NET.WriteTimeout),
 			AcceptLoopInterruptPeriod: either(src.NET.AcceptLoopInterruptPeriod, defaults.NET.AcceptLoopInterruptPeriod),
+			ProxyHeaderTimeout:        either(src.NET.ProxyHeaderTimeout, defaults.NET.ProxyHeaderTimeout),
+			ShutdownTimeout:           either(src.NET.ShutdownTimeout, defaults.NET.ShutdownTimeout),
+			MaxConnections:            src.NET.MaxConnections,
+			MaxConnectionsPerIP:       src.NET.MaxConnectionsPerIP,
+			LimitPolicy:               src.NET.LimitPolicy,
 		},
 	}
 }
 
+// idleTimeout respects the deprecated ReadTimeout, if IdleTimeout is left intact.
+func idleTimeout(src, defaults NET) time.Duration {
+	idle := either(src.IdleTimeout, defaults.IdleTimeout)
+	if src.ReadTimeout > 0 && idle == defaults.IdleTimeout {
+		return src.ReadTimeout
+	}
+
+	return idle
+}
+
 func either[T constraint.Number](custom, defaultVal T) T {
 	if custom == 0 {
 		return defaultVal
@@ -243,3 +380,11 @@ func mapOr[K comparable, V any](custom, defaultVal map[K]V) map[K]V {
 
 	return custom
 }
+
+func sliceOr[T any](custom, defaultVal []T) []T {
+	if custom == nil {
+		return defaultVal
+	}
+
+	return custom
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"syscall"
 
 	"github.com/indigo-web/indigo"
 	"github.com/indigo-web/indigo/http"
@@ -24,7 +25,11 @@ func main() {
 		TLS(":8443", indigo.LocalCert()).
 		OnBind(func(addr string) {
 			log.Printf("running on %s\n", addr)
-		})
+		}).
+		OnStop(func(reason error) {
+			log.Printf("stopped: %v\n", reason)
+		}).
+		NotifyOnSignals(syscall.SIGINT, syscall.SIGTERM)
 
 	log.Fatal(app.Serve(r))
 }
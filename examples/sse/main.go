@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/sse"
+
+	"github.com/indigo-web/indigo"
+	"github.com/indigo-web/indigo/router/inbuilt"
+)
+
+const addr = ":8080"
+
+func Clock(request *http.Request) *http.Response {
+	return sse.Respond(request, func(stream *sse.Stream) error {
+		// the client sends the id of the last received event when it reconnects
+		counter, _ := strconv.Atoi(stream.LastEventID())
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stream.Done():
+				// the client is gone or the server is shutting down
+				return nil
+			case now := <-ticker.C:
+				counter++
+				err := stream.Send(sse.Event{
+					ID:    strconv.Itoa(counter),
+					Event: "tick",
+					Data:  now.Format(time.RFC3339),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	})
+}
+
+func main() {
+	r := inbuilt.New()
+	r.Get("/clock", Clock)
+
+	app := indigo.New(addr).
+		OnBind(func(addr string) {
+			log.Printf("running on %s\n", addr)
+		})
+
+	log.Fatal(app.Serve(r))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/websocket"
+
+	"github.com/indigo-web/indigo"
+	"github.com/indigo-web/indigo/router/inbuilt"
+)
+
+const addr = ":8080"
+
+var upgrader = websocket.Upgrader{
+	Compression: true,
+}
+
+func Echo(request *http.Request) *http.Response {
+	conn, err := upgrader.Upgrade(request)
+	if err != nil {
+		// the handshake is invalid, so we can respond with the error as usual
+		return http.Error(request, err)
+	}
+
+	for {
+		typ, data, err := conn.ReadMessage()
+		if err != nil {
+			// after switching protocols it makes no difference, what will be returned
+			return nil
+		}
+
+		if err = conn.WriteMessage(typ, data); err != nil {
+			return nil
+		}
+	}
+}
+
+func main() {
+	r := inbuilt.New()
+	r.Get("/ws", Echo)
+
+	app := indigo.New(addr).
+		OnBind(func(addr string) {
+			log.Printf("running on %s\n", addr)
+		})
+
+	log.Fatal(app.Serve(r))
+}
@@ -3,6 +3,7 @@ module github.com/indigo-web/indigo
 go 1.23
 
 require (
+	github.com/andybalholm/brotli v1.1.1
 	github.com/indigo-web/chunkedbody v0.1.0
 	github.com/indigo-web/iter v0.1.0
 	github.com/indigo-web/utils v0.6.3
@@ -10,6 +11,7 @@ require (
 	github.com/klauspost/compress v1.17.11
 	github.com/stretchr/testify v1.9.0
 	golang.org/x/crypto v0.28.0
+	golang.org/x/net v0.30.0
 )
 
 require (
@@ -18,7 +20,6 @@ require (
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/net v0.30.0 // indirect
 	golang.org/x/text v0.19.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -2,6 +2,7 @@ package http
 
 import (
 	"github.com/indigo-web/indigo/config"
+	"github.com/indigo-web/indigo/http/coding"
 	"github.com/indigo-web/indigo/http/form"
 	"github.com/indigo-web/indigo/http/mime"
 	"github.com/indigo-web/indigo/http/status"
@@ -10,41 +11,153 @@ import (
 	"github.com/indigo-web/utils/uf"
 	json "github.com/json-iterator/go"
 	"io"
+	"slices"
 )
 
 type BodyCallback func([]byte) error
 
-type Retriever interface {
-	// Retrieve reads and returns a piece of body available for processing
-	Retrieve() ([]byte, error)
-}
+type (
+	Retriever    = coding.Retriever
+	Decompressor = coding.Decompressor
+)
 
-type Decompressor interface {
-	Retriever
-	Reset(Retriever) error
+type Body struct {
+	// raw is the body as it's transferred
+	raw retriever
+	// source is where the body is actually read from. It's either the raw body or a chain
+	// of decompressors on top of it. Nil means it isn't initialized for the current
+	// request yet
+	source        retriever
+	decompressing bool
+	decompressed  uint
+	// decompressors are cached per connection, so they are allocated only once
+	decompressors map[string]Decompressor
+	rawError      error
+	request       *Request
+	form          form.Form
+	cfg           *config.Config
+	error         error
+	buff          []byte
+	decbuff       []byte
+	pending       []byte
 }
 
 type retriever = Retriever
 
-type Body struct {
-	retriever
-	request *Request
-	form    form.Form
-	cfg     *config.Config
-	error   error
-	buff    []byte
-	decbuff []byte
-	pending []byte
-}
-
 func NewBody(r *Request, impl retriever, cfg *config.Config) *Body {
 	return &Body{
 		// TODO: prealloc form field
-		retriever: impl,
-		request:   r,
-		cfg:       cfg,
-		decbuff:   make([]byte, cfg.Body.FormDecodeBufferPrealloc),
+		raw:     impl,
+		request: r,
+		cfg:     cfg,
+		decbuff: make([]byte, cfg.Body.FormDecodeBufferPrealloc),
+	}
+}
+
+// Retrieve returns the next piece of the body, decompressed according to the request's
+// Content-Encoding. If any of the codings isn't supported, status.ErrUnsupportedEncoding
+// is returned. Decompressed body is limited by config.Body.MaxSize as well.
+func (b *Body) Retrieve() ([]byte, error) {
+	if b.source == nil {
+		if err := b.chain(); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := b.source.Retrieve()
+	if b.decompressing {
+		b.decompressed += uint(len(data))
+		if b.decompressed > b.cfg.Body.MaxSize {
+			return nil, status.ErrBodyTooLarge
+		}
+	}
+
+	return data, err
+}
+
+// Raw disables the decompression, so the body is read exactly as it was transferred.
+// Must be called before the body is read, otherwise it has no effect.
+func (b *Body) Raw() *Body {
+	if b.source == nil {
+		b.source = rawRetriever{b}
+	}
+
+	return b
+}
+
+// chain builds the chain of decompressors. Codings are listed in the order they were
+// applied, so they're undone in the reverse one.
+func (b *Body) chain() error {
+	b.source = rawRetriever{b}
+
+	codings := b.request.Encoding.Content
+	chain := make([]Decompressor, 0, len(codings))
+	for i := len(codings) - 1; i >= 0; i-- {
+		if strutil.CmpFold(codings[i], "identity") {
+			continue
+		}
+
+		decompressor, err := b.decompressor(codings[i], chain)
+		if err == nil {
+			err = decompressor.Reset(b.source)
+		}
+
+		if err != nil {
+			b.source = nil
+			return err
+		}
+
+		chain = append(chain, decompressor)
+		b.source = decompressor
+	}
+
+	b.decompressing = len(chain) > 0
+	return nil
+}
+
+// decompressor returns a decompressor for the coding, which isn't used in the chain yet
+func (b *Body) decompressor(name string, chain []Decompressor) (Decompressor, error) {
+	cached, found := b.decompressors[name]
+	if found && !slices.Contains(chain, cached) {
+		return cached, nil
+	}
+
+	constructor := coding.Lookup(name)
+	if constructor == nil {
+		return nil, status.ErrUnsupportedEncoding
+	}
+
+	decompressor := constructor(int(b.cfg.Body.DecodingBufferSize))
+	if !found {
+		if b.decompressors == nil {
+			b.decompressors = make(map[string]Decompressor, 1)
+		}
+
+		b.decompressors[name] = decompressor
 	}
+
+	return decompressor, nil
+}
+
+// retrieveRaw reads the transferred body, memorizing the final error, so the rest of the
+// body can be discarded after the decompression.
+func (b *Body) retrieveRaw() ([]byte, error) {
+	if b.rawError != nil {
+		return nil, b.rawError
+	}
+
+	data, err := b.raw.Retrieve()
+	b.rawError = err
+
+	return data, err
+}
+
+type rawRetriever struct {
+	body *Body
+}
+
+func (r rawRetriever) Retrieve() ([]byte, error) {
+	return r.body.retrieveRaw()
 }
 
 // Callback invokes the callback every time as there's a piece of body available
@@ -176,17 +289,21 @@ func (b *Body) Form() (form.Form, error) {
 }
 
 // Discard discards the rest of the body (if any). If no networking error was encountered,
-// nil is returned.
+// nil is returned. The rest of the body isn't decompressed.
 func (b *Body) Discard() error {
-	for b.error == nil {
-		_, b.error = b.Retrieve()
+	for b.rawError == nil {
+		_, _ = b.retrieveRaw()
 	}
 
-	if b.error == io.EOF {
+	if b.error == nil {
+		b.error = b.rawError
+	}
+
+	if b.rawError == io.EOF {
 		return nil
 	}
 
-	return b.error
+	return b.rawError
 }
 
 // Error returns a previously encountered error, otherwise nil.
@@ -200,7 +317,12 @@ func (b *Body) Reset() error {
 	}
 
 	b.error = nil
+	b.rawError = nil
+	b.source = nil
+	b.decompressing = false
+	b.decompressed = 0
 	b.buff = b.buff[:0]
+	b.pending = nil
 	return nil
 }
 
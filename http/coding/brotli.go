@@ -0,0 +1,28 @@
+package coding
+
+import (
+	"github.com/andybalholm/brotli"
+)
+
+type Brotli struct {
+	stream
+	brotli *brotli.Reader
+}
+
+func NewBrotli(buffsize int) *Brotli {
+	return &Brotli{
+		stream: newStream(buffsize),
+	}
+}
+
+func (b *Brotli) Reset(source Retriever) error {
+	b.src.Reset(source)
+
+	if b.brotli == nil {
+		b.brotli = brotli.NewReader(&b.src)
+		b.r = b.brotli
+		return nil
+	}
+
+	return b.error(b.brotli.Reset(&b.src))
+}
@@ -1,12 +1,72 @@
+// Package coding implements the decompressors of the request body content codings and
+// keeps the registry of them.
 package coding
 
-import "io"
+import (
+	"strings"
+	"sync"
+)
 
-type decompressor struct {
-	Name string
-	R    io.Reader
+// Retriever returns the data piece by piece. The last piece may be returned together with
+// io.EOF.
+type Retriever interface {
+	// Retrieve reads and returns a piece of body available for processing
+	Retrieve() ([]byte, error)
 }
 
-type Decompressor struct {
-	available []decompressor
+// Decompressor decompresses the data, retrieved from the source. Returned data is valid
+// until the next call to Retrieve.
+type Decompressor interface {
+	Retriever
+	// Reset prepares the decompressor to decompress a new source, so it can be reused
+	// across requests
+	Reset(source Retriever) error
+}
+
+// Constructor returns a new decompressor, which decompresses the data into the buffer
+// of the passed size
+type Constructor func(buffsize int) Decompressor
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Constructor{
+		"gzip": func(buffsize int) Decompressor {
+			return NewGZIP(buffsize)
+		},
+		// x-gzip is equivalent to gzip, as stated by RFC 9110
+		"x-gzip": func(buffsize int) Decompressor {
+			return NewGZIP(buffsize)
+		},
+		"deflate": func(buffsize int) Decompressor {
+			return NewDeflate(buffsize)
+		},
+		"zstd": func(buffsize int) Decompressor {
+			return NewZSTD(buffsize)
+		},
+		"br": func(buffsize int) Decompressor {
+			return NewBrotli(buffsize)
+		},
+	}
+)
+
+// Register makes the decompressor available for the content coding. Codings are
+// case-insensitive. If the coding is already registered, it's replaced. gzip, x-gzip,
+// deflate, zstd and br are registered by default.
+func Register(coding string, constructor Constructor) {
+	mu.Lock()
+	registry[strings.ToLower(coding)] = constructor
+	mu.Unlock()
+}
+
+// Lookup returns the constructor of the decompressor for the content coding. If the
+// coding isn't supported, nil is returned.
+func Lookup(coding string) Constructor {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if constructor, found := registry[coding]; found {
+		return constructor
+	}
+
+	return registry[strings.ToLower(coding)]
 }
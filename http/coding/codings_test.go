@@ -0,0 +1,78 @@
+package coding
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func compress(t *testing.T, coding string, data []byte) []byte {
+	var (
+		buff bytes.Buffer
+		w    io.WriteCloser
+		err  error
+	)
+
+	switch coding {
+	case "gzip", "x-gzip":
+		w = gzip.NewWriter(&buff)
+	case "deflate":
+		w = zlib.NewWriter(&buff)
+	case "zstd":
+		w, err = zstd.NewWriter(&buff)
+		require.NoError(t, err)
+	case "br":
+		w = brotli.NewWriter(&buff)
+	default:
+		t.Fatalf("unknown coding: %s", coding)
+	}
+
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return buff.Bytes()
+}
+
+func TestDecompressors(t *testing.T) {
+	payload := []byte(strings.Repeat("Lorem ipsum dolor sit amet. ", 1000))
+
+	for _, coding := range []string{"gzip", "x-gzip", "deflate", "zstd", "br"} {
+		t.Run(coding, func(t *testing.T) {
+			constructor := Lookup(strings.ToUpper(coding))
+			require.NotNil(t, constructor)
+			decompressor := constructor(64)
+
+			for range 2 {
+				require.NoError(t, decompressor.Reset(newChunks(compress(t, coding, payload), 100)))
+				data, err := retrieveAll(decompressor)
+				require.NoError(t, err)
+				require.Equal(t, payload, data)
+			}
+
+			err := decompressor.Reset(newChunks(bytes.Repeat([]byte{0xde, 0xad}, 100), 10))
+			if err == nil {
+				_, err = retrieveAll(decompressor)
+			}
+			require.Equal(t, ErrMalformed, err)
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		require.Nil(t, Lookup("compress"))
+	})
+
+	t.Run("register", func(t *testing.T) {
+		Register("X-Custom", func(buffsize int) Decompressor {
+			return NewGZIP(buffsize)
+		})
+		require.NotNil(t, Lookup("x-custom"))
+	})
+}
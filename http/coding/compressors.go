@@ -0,0 +1,56 @@
+package coding
+
+import (
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressor compresses the data written into it. Close flushes the rest of the data, and
+// Reset prepares it to write into a new destination, so it can be reused.
+type Compressor interface {
+	io.WriteCloser
+	Reset(dst io.Writer)
+}
+
+// CompressorConstructor returns a new compressor. Destination is set via Reset.
+type CompressorConstructor func() Compressor
+
+var compressors = map[string]CompressorConstructor{
+	"gzip": func() Compressor {
+		return gzip.NewWriter(nil)
+	},
+	"zstd": func() Compressor {
+		// error is returned only in case of invalid options
+		encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return encoder
+	},
+	"br": func() Compressor {
+		return brotli.NewWriter(nil)
+	},
+}
+
+// RegisterCompressor makes the compressor available for the content coding. Codings are
+// case-insensitive. If the coding is already registered, it's replaced. gzip, zstd and br
+// are registered by default.
+func RegisterCompressor(coding string, constructor CompressorConstructor) {
+	mu.Lock()
+	compressors[strings.ToLower(coding)] = constructor
+	mu.Unlock()
+}
+
+// LookupCompressor returns the constructor of the compressor for the content coding. If the
+// coding isn't supported, nil is returned.
+func LookupCompressor(coding string) CompressorConstructor {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if constructor, found := compressors[coding]; found {
+		return constructor
+	}
+
+	return compressors[strings.ToLower(coding)]
+}
@@ -1 +1,33 @@
 package coding
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zlib"
+)
+
+// Deflate decompresses the deflate content coding, which is the zlib format as defined
+// by RFC 9110
+type Deflate struct {
+	stream
+	zlib io.ReadCloser
+}
+
+func NewDeflate(buffsize int) *Deflate {
+	return &Deflate{
+		stream: newStream(buffsize),
+	}
+}
+
+func (d *Deflate) Reset(source Retriever) (err error) {
+	d.src.Reset(source)
+
+	if d.zlib == nil {
+		d.zlib, err = zlib.NewReader(&d.src)
+	} else {
+		err = d.zlib.(zlib.Resetter).Reset(&d.src, nil)
+	}
+
+	d.r = d.zlib
+	return d.error(err)
+}
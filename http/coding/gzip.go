@@ -1,27 +1,23 @@
 package coding
 
 import (
-	"github.com/indigo-web/indigo/http"
 	"github.com/klauspost/compress/gzip"
 )
 
 type GZIP struct {
-	r    gzip.Reader
-	buff []byte
+	stream
+	gzip gzip.Reader
 }
 
 func NewGZIP(buffsize int) *GZIP {
 	return &GZIP{
-		r:    gzip.Reader{},
-		buff: make([]byte, buffsize),
+		stream: newStream(buffsize),
 	}
 }
 
-func (g *GZIP) Retrieve() ([]byte, error) {
-	n, err := g.r.Read(g.buff)
-	return g.buff[:n], err
-}
+func (g *GZIP) Reset(source Retriever) error {
+	g.src.Reset(source)
+	g.r = &g.gzip
 
-func (g *GZIP) Reset(source http.Retriever) error {
-	return g.r.Reset(nil)
+	return g.error(g.gzip.Reset(&g.src))
 }
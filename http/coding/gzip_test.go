@@ -1,7 +1,99 @@
 package coding
 
-import "testing"
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/require"
+)
+
+// chunks retrieves the data in pieces of the given size
+type chunks struct {
+	data []byte
+	size int
+	err  error
+}
+
+func newChunks(data []byte, size int) *chunks {
+	return &chunks{data: data, size: size, err: io.EOF}
+}
+
+func (c *chunks) Retrieve() ([]byte, error) {
+	if len(c.data) == 0 {
+		return nil, c.err
+	}
+
+	n := min(c.size, len(c.data))
+	piece := c.data[:n]
+	c.data = c.data[n:]
+
+	return piece, nil
+}
+
+func retrieveAll(r Retriever) ([]byte, error) {
+	var result []byte
+	for {
+		data, err := r.Retrieve()
+		result = append(result, data...)
+		switch err {
+		case nil:
+		case io.EOF:
+			return result, nil
+		default:
+			return result, err
+		}
+	}
+}
+
+func gzipped(t *testing.T, data string) []byte {
+	var buff bytes.Buffer
+	w := gzip.NewWriter(&buff)
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return buff.Bytes()
+}
 
 func TestGZIP(t *testing.T) {
+	g := NewGZIP(16)
+	payload := strings.Repeat("Hello, world! ", 100)
+
+	t.Run("reuse", func(t *testing.T) {
+		for range 3 {
+			require.NoError(t, g.Reset(newChunks(gzipped(t, payload), 7)))
+			data, err := retrieveAll(g)
+			require.NoError(t, err)
+			require.Equal(t, payload, string(data))
+		}
+	})
+
+	t.Run("multiple members", func(t *testing.T) {
+		compressed := append(gzipped(t, "Hello, "), gzipped(t, "world!")...)
+		require.NoError(t, g.Reset(newChunks(compressed, 5)))
+		data, err := retrieveAll(g)
+		require.NoError(t, err)
+		require.Equal(t, "Hello, world!", string(data))
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		require.Equal(t, ErrMalformed, g.Reset(newChunks([]byte("definitely not gzip"), 4)))
+
+		compressed := gzipped(t, payload)
+		require.NoError(t, g.Reset(newChunks(compressed[:len(compressed)/2], 4)))
+		_, err := retrieveAll(g)
+		require.Equal(t, ErrMalformed, err)
+	})
 
+	t.Run("source error", func(t *testing.T) {
+		compressed := gzipped(t, payload)
+		source := newChunks(compressed[:len(compressed)/2], 4)
+		source.err = io.ErrClosedPipe
+		require.NoError(t, g.Reset(source))
+		_, err := retrieveAll(g)
+		require.Equal(t, io.ErrClosedPipe, err)
+	})
 }
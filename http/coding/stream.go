@@ -0,0 +1,92 @@
+package coding
+
+import (
+	"io"
+
+	"github.com/indigo-web/indigo/http/status"
+)
+
+// ErrMalformed is returned if the body can't be decompressed
+var ErrMalformed = status.NewError(status.BadRequest, "malformed compressed body")
+
+// source adapts a Retriever to the io.Reader, so it can be consumed by the stream
+// decompressors
+type source struct {
+	retriever Retriever
+	pending   []byte
+	err       error
+}
+
+func (s *source) Reset(retriever Retriever) {
+	s.retriever = retriever
+	s.pending = nil
+	s.err = nil
+}
+
+func (s *source) Read(b []byte) (n int, err error) {
+	if !s.fill() {
+		return 0, s.err
+	}
+
+	n = copy(b, s.pending)
+	s.pending = s.pending[n:]
+
+	return n, nil
+}
+
+// ReadByte is implemented in order to prevent decompressors from wrapping the source
+// into bufio.Reader
+func (s *source) ReadByte() (byte, error) {
+	if !s.fill() {
+		return 0, s.err
+	}
+
+	c := s.pending[0]
+	s.pending = s.pending[1:]
+
+	return c, nil
+}
+
+func (s *source) fill() bool {
+	for len(s.pending) == 0 {
+		if s.err != nil {
+			return false
+		}
+
+		s.pending, s.err = s.retriever.Retrieve()
+	}
+
+	return true
+}
+
+// stream implements the Retriever for decompressors, which are based on io.Reader
+type stream struct {
+	src  source
+	r    io.Reader
+	buff []byte
+}
+
+func newStream(buffsize int) stream {
+	return stream{
+		buff: make([]byte, buffsize),
+	}
+}
+
+func (s *stream) Retrieve() ([]byte, error) {
+	for {
+		n, err := s.r.Read(s.buff)
+		if n > 0 || err != nil {
+			return s.buff[:n], s.error(err)
+		}
+	}
+}
+
+// error passes through the errors of the source, as they're usually networking ones,
+// and reports the rest as malformed data
+func (s *stream) error(err error) error {
+	if err == nil || err == io.EOF || err == s.src.err {
+		return err
+	}
+
+	return ErrMalformed
+}
@@ -0,0 +1,36 @@
+package coding
+
+import (
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxZSTDWindow is the maximal window size, which decoders must support as required
+// by RFC 8878. Bigger windows are rejected, so a single request can't make the server
+// allocate a lot of memory
+const maxZSTDWindow = 8 * 1024 * 1024
+
+type ZSTD struct {
+	stream
+	zstd *zstd.Decoder
+}
+
+func NewZSTD(buffsize int) *ZSTD {
+	return &ZSTD{
+		stream: newStream(buffsize),
+	}
+}
+
+func (z *ZSTD) Reset(source Retriever) (err error) {
+	z.src.Reset(source)
+
+	if z.zstd == nil {
+		z.zstd, err = zstd.NewReader(
+			&z.src, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxZSTDWindow),
+		)
+	} else {
+		err = z.zstd.Reset(&z.src)
+	}
+
+	z.r = z.zstd
+	return z.error(err)
+}
@@ -9,6 +9,7 @@ type MIME = string
 const (
 	OctetStream    MIME = "application/octet-stream"
 	Plain          MIME = "text/plain"
+	EventStream    MIME = "text/event-stream"
 	HTML           MIME = "text/html"
 	XML            MIME = "text/xml"
 	JSON           MIME = "application/json"
@@ -16,6 +17,7 @@ const (
 	PDF            MIME = "application/pdf"
 	FormUrlencoded MIME = "application/x-www-form-urlencoded"
 	Multipart      MIME = "multipart/form-data"
+	ByteRanges     MIME = "multipart/byteranges"
 	ZIP            MIME = "application/zip"
 	GZIP           MIME = "application/gzip"
 	ZLIB           MIME = "application/zlib"
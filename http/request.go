@@ -2,6 +2,8 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"github.com/indigo-web/indigo/config"
 	"github.com/indigo-web/indigo/http/cookie"
 	"github.com/indigo-web/indigo/http/crypt"
@@ -9,13 +11,23 @@ import (
 	"github.com/indigo-web/indigo/http/method"
 	"github.com/indigo-web/indigo/http/proto"
 	"github.com/indigo-web/indigo/http/query"
+	"github.com/indigo-web/indigo/http/status"
 	"github.com/indigo-web/indigo/internal/keyvalue"
+	"github.com/indigo-web/indigo/internal/validator"
 	"github.com/indigo-web/indigo/transport"
+	"github.com/indigo-web/indigo/transport/proxyproto"
 	"net"
+	"strings"
+	"time"
 )
 
 var zeroContext = context.Background()
 
+var (
+	ErrNotHijackable    = errors.New("the connection can't be hijacked")
+	ErrNotInformational = errors.New("the status code isn't informational")
+)
+
 type Params = *keyvalue.Storage
 
 // Request represents HTTP request
@@ -34,7 +46,8 @@ type Request struct {
 	// case-insensitive
 	Headers headers.Headers
 	commonHeaders
-	// Remote represents remote net.Addr.
+	// Remote represents remote net.Addr. It's never nil, however it isn't necessarily
+	// an IP address: for Unix domain sockets, it's a *net.UnixAddr, usually unnamed.
 	// WARNING: in order to use the value to represent a user, MAKE SURE there are no proxies
 	// in the middle
 	Remote net.Addr
@@ -46,12 +59,16 @@ type Request struct {
 	// to gain performance, as accessing the struct is much faster than looking up in context.Context
 	Env Environment
 	// Body accesses the request's body
-	Body     *Body
-	client   transport.Client
-	hijacked bool
-	response *Response
-	jar      cookie.Jar
-	cfg      *config.Config
+	Body       *Body
+	client     transport.Client
+	serializer Serializer
+	hijacked   bool
+	response   *Response
+	jar        cookie.Jar
+	cfg        *config.Config
+	// interim is the response, which is used for informational responses. It's allocated
+	// only when needed
+	interim *Response
 }
 
 // NewRequest returns a new instance of request object and body gateway
@@ -76,6 +93,20 @@ func NewRequest(
 	}
 }
 
+// Serializer writes responses into the connection. It's implemented by protocols, so
+// responses can be written before the handler returns
+type Serializer interface {
+	Write(protocol proto.Proto, response *Response) error
+	// Inform immediately sends the informational (1xx) response
+	Inform(response *Response) error
+}
+
+// SetSerializer binds the protocol's serializer to the request. Must not be used
+// externally, this method is for internal purposes only
+func (r *Request) SetSerializer(s Serializer) {
+	r.serializer = s
+}
+
 // Cookies returns a cookie jar with parsed cookies key-value pairs, and an error
 // if the syntax is malformed. The returned jar should be re-used, as this method
 // doesn't cache the parsed result across calls and may be pretty expensive
@@ -108,17 +139,106 @@ func (r *Request) Respond() *Response {
 
 // Hijack the connection. Request body will be implicitly read (so if you need it you
 // should read it before) to the end. After handler exits, the connection will
-// be closed, so the connection can be hijacked at most once
+// be closed, so the connection can be hijacked at most once. Read deadline is reset,
+// so managing timeouts of the hijacked connection is up to the caller.
+//
+// HTTP/2 connections are shared by multiple requests, so they can't be hijacked and
+// ErrNotHijackable is returned
 func (r *Request) Hijack() (transport.Client, error) {
+	if r.Proto == proto.HTTP2 {
+		return nil, ErrNotHijackable
+	}
+
 	if err := r.Body.Discard(); err != nil {
 		return nil, err
 	}
 
+	if err := r.client.Conn().SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
 	r.hijacked = true
 
 	return r.client, nil
 }
 
+// SwitchProtocols writes the 101 Switching Protocols response and hijacks the connection,
+// so the handler may speak the new protocol over it. The passed response is written as
+// is, so it must contain the Upgrade header. Returns ErrNotHijackable, if the protocol
+// doesn't support switching
+func (r *Request) SwitchProtocols(resp *Response) (transport.Client, error) {
+	if r.serializer == nil {
+		return nil, ErrNotHijackable
+	}
+
+	client, err := r.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.serializer.Write(r.Proto, resp.Code(status.SwitchingProtocols)); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// Inform sends the informational (1xx) response before the final one. It may be called
+// multiple times, e.g. in order to send 103 Early Hints with preload links, so the client
+// could start fetching them while the final response is still being prepared:
+//
+//	request.Inform(status.EarlyHints, headers.New().Add("Link", "</style.css>; rel=preload; as=style"))
+//
+// HTTP/1.0 clients don't understand informational responses, so nothing is sent to them.
+// 101 Switching Protocols must be sent via SwitchProtocols instead, otherwise
+// ErrNotInformational is returned, as for any non-1xx code
+func (r *Request) Inform(code status.Code, hdrs headers.Headers) error {
+	if code < 100 || code >= 200 || code == status.SwitchingProtocols {
+		return ErrNotInformational
+	}
+
+	if r.Proto == proto.HTTP10 || r.serializer == nil {
+		return nil
+	}
+
+	if r.interim == nil {
+		r.interim = NewResponse()
+	}
+
+	resp := r.interim.Clear().Code(code)
+	if hdrs != nil {
+		for key, value := range hdrs.Iter() {
+			resp.Header(key, value)
+		}
+	}
+
+	return r.serializer.Inform(resp)
+}
+
+// Precondition evaluates If-Match and If-Unmodified-Since headers against the current state
+// of the resource, returning status.ErrPreconditionFailed if they aren't met. It's meant for
+// unsafe methods, e.g. PUT, PATCH or DELETE, and must be called before any changes are made:
+//
+//	if err := request.Precondition(doc.ETag, doc.Modified); err != nil {
+//		return http.Error(request, err)
+//	}
+//
+// The tag is quoted the same way Response.ETag does. Empty tag means the resource has none,
+// and zero time means the modification date is unknown, so If-Unmodified-Since is ignored
+func (r *Request) Precondition(etag string, modified time.Time) error {
+	if len(etag) > 0 && !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, `W/"`) {
+		etag = `"` + etag + `"`
+	}
+
+	// HTTP-date has a resolution of one second, so the fractional part would otherwise
+	// fail the dates sent back by clients as they were received
+	if validator.Failed(r.Headers, etag, modified.Truncate(time.Second)) {
+		return status.ErrPreconditionFailed
+	}
+
+	return nil
+}
+
 // Hijacked tells whether the connection was hijacked or not
 func (r *Request) Hijacked() bool {
 	return r.hijacked
@@ -132,7 +252,12 @@ func (r *Request) Reset() (err error) {
 	r.Headers.Clear()
 	r.commonHeaders = commonHeaders{}
 	r.Ctx = zeroContext
-	r.Env = Environment{}
+	r.Env = Environment{
+		// these values are bound to the connection, not to the request
+		Encryption: r.Env.Encryption,
+		Proxy:      r.Env.Proxy,
+		TLS:        r.Env.TLS,
+	}
 
 	return r.Body.Reset()
 }
@@ -149,6 +274,14 @@ type Environment struct {
 	// AliasFrom contains the original request path, in case it was replaced via alias
 	// aka implicit redirect
 	AliasFrom string
+	// Proxy is the PROXY protocol header, sent by the proxy in front of the server. Is
+	// nil, unless the protocol is enabled on the transport and the proxy is trusted
+	Proxy *proxyproto.Header
+	// TLS is the state of the TLS connection, or nil if the connection is plain. It contains
+	// the SNI server name, negotiated ALPN protocol and cipher suite, as well as the client
+	// certificates. If the client certificates verification is enabled, the verified
+	// chains are in TLS.VerifiedChains
+	TLS *tls.ConnectionState
 }
 
 type commonHeaders struct {
@@ -165,6 +298,12 @@ type commonHeaders struct {
 	// Connection holds the Connection header value. It isn't normalized, so can be anything
 	// and in any case. So in order to compare it, highly recommended to do it case-insensibly
 	Connection string
+	// Expect holds the Expect header value. HTTP/1.1 clients, which send 100-continue, wait
+	// for the permission before transmitting the body. It's granted automatically as soon
+	// as the body is read first time, so the upload can be rejected by simply responding
+	// without touching the body, e.g. with 413 Request Entity Too Large or 417 Expectation
+	// Failed
+	Expect string
 	// Upgrade is the protocol token, which is set by default to proto.Unknown. In
 	// case it is anything else, then Upgrade header was received
 	Upgrade proto.Proto
@@ -5,9 +5,11 @@ import (
 	"github.com/indigo-web/indigo/http/cookie"
 	"github.com/indigo-web/indigo/http/headers"
 	"github.com/indigo-web/indigo/http/query"
+	"github.com/indigo-web/indigo/http/status"
 	"github.com/indigo-web/indigo/transport/dummy"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func newRequest() *Request {
@@ -55,3 +57,38 @@ func TestCookies(t *testing.T) {
 		require.EqualError(t, err, cookie.ErrBadCookie.Error())
 	})
 }
+
+func TestPrecondition(t *testing.T) {
+	modified := time.Date(2015, 10, 21, 7, 28, 0, 500, time.UTC)
+
+	for _, tc := range []struct {
+		headers []string
+		etag    string
+		want    error
+	}{
+		{nil, "v1", nil},
+		{[]string{"If-Match", `"v1"`}, "v1", nil},
+		{[]string{"If-Match", `"v1"`}, `"v1"`, nil},
+		{[]string{"If-Match", `"v0", "v1"`}, "v1", nil},
+		{[]string{"If-Match", `*`}, "v1", nil},
+		{[]string{"If-Match", `"v0"`}, "v1", status.ErrPreconditionFailed},
+		{[]string{"If-Match", `W/"v1"`}, `W/"v1"`, status.ErrPreconditionFailed},
+		{[]string{"If-Match", `"v1"`}, "", status.ErrPreconditionFailed},
+		{[]string{"If-Unmodified-Since", "Wed, 21 Oct 2015 07:28:00 GMT"}, "", nil},
+		{[]string{"If-Unmodified-Since", "Wed, 21 Oct 2015 07:27:59 GMT"}, "", status.ErrPreconditionFailed},
+		{[]string{"If-Match", `"v1"`, "If-Unmodified-Since", "Wed, 21 Oct 2015 07:27:59 GMT"}, "v1", nil},
+	} {
+		request := newRequest()
+		for i := 0; i < len(tc.headers); i += 2 {
+			request.Headers.Add(tc.headers[i], tc.headers[i+1])
+		}
+
+		require.Equal(t, tc.want, request.Precondition(tc.etag, modified), "%q", tc.headers)
+	}
+
+	t.Run("unknown modification date", func(t *testing.T) {
+		request := newRequest()
+		request.Headers.Add("If-Unmodified-Since", "Wed, 21 Oct 2015 07:27:59 GMT")
+		require.NoError(t, request.Precondition("", time.Time{}))
+	})
+}
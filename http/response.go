@@ -13,10 +13,16 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 type ResponseWriter func(b []byte) error
 
+// StreamWriter is passed into the function set by Response.Stream. Written data is
+// buffered until Flush is called or the buffer is full.
+type StreamWriter = types.StreamWriter
+
 const (
 	// why 7? I don't know. There's no theory behind this number nor researches.
 	// It can be adjusted to 10 as well, but why you would ever need to do this?
@@ -24,6 +30,8 @@ const (
 	defaultFileMIME     = mime.OctetStream
 )
 
+var gmt = time.FixedZone("GMT", 0)
+
 type Response struct {
 	fields *response.Fields
 }
@@ -144,7 +152,7 @@ func (r *Response) TryFile(path string) (*Response, error) {
 		r.fields.ContentType = defaultFileMIME
 	}
 
-	return r.Attachment(fd, int(stat.Size())), nil
+	return r.LastModified(stat.ModTime()).Attachment(fd, int(stat.Size())), nil
 }
 
 // File opens a file for reading and returns a new Response with attachment, set to the file
@@ -158,13 +166,47 @@ func (r *Response) File(path string) *Response {
 	return resp
 }
 
+// ETag sets the entity tag of the response. The tag is quoted, unless it already is. Weak
+// tags must be passed with the W/ prefix, e.g. W/"v1". Conditional GET and HEAD requests
+// are evaluated against it automatically, resulting in 304 Not Modified or 412 Precondition
+// Failed. Other methods aren't, as the handler has already been executed by then, so
+// Request.Precondition must be used for them instead
+func (r *Response) ETag(tag string) *Response {
+	if !strings.HasPrefix(tag, `"`) && !strings.HasPrefix(tag, `W/"`) {
+		tag = `"` + tag + `"`
+	}
+
+	return r.Header("ETag", tag)
+}
+
+// LastModified sets the Last-Modified header. Same as ETag, it's used to evaluate the
+// conditional GET and HEAD requests
+func (r *Response) LastModified(t time.Time) *Response {
+	return r.Header("Last-Modified", t.In(gmt).Format(time.RFC1123))
+}
+
 // Attachment sets a Response's attachment. In this case Response body will be ignored.
-// If size <= 0, then Transfer-Encoding: chunked will be used
+// If size <= 0, then Transfer-Encoding: chunked will be used. If the reader implements
+// io.ReaderAt or io.Seeker and the size is known, Range requests are served automatically
 func (r *Response) Attachment(reader io.Reader, size int) *Response {
 	r.fields.Attachment = types.NewAttachment(reader, size)
 	return r
 }
 
+// Stream sets a function, which produces the response body incrementally. Body and
+// attachment are ignored in this case. The data is sent to the client as soon as
+// StreamWriter.Flush is called, using chunked transfer encoding for HTTP/1.1 and a body
+// delimited by closing the connection for HTTP/1.0.
+//
+// Errors returned by the StreamWriter mean the client is gone, so the function should
+// return them as soon as possible. If the function returns an error, the response is
+// aborted and the client is able to tell it's incomplete. The function isn't called in
+// response to HEAD requests.
+func (r *Response) Stream(fn func(w StreamWriter) error) *Response {
+	r.fields.Stream = fn
+	return r
+}
+
 // Cookie adds cookies. They'll be later rendered as a set of Set-Cookie headers
 func (r *Response) Cookie(cookies ...cookie.Cookie) *Response {
 	r.fields.Cookies = append(r.fields.Cookies, cookies...)
@@ -1,21 +1,42 @@
 package serve
 
 import (
+	"crypto/tls"
 	"github.com/indigo-web/indigo/config"
+	"github.com/indigo-web/indigo/http"
 	"github.com/indigo-web/indigo/http/crypt"
 	"github.com/indigo-web/indigo/internal/construct"
 	"github.com/indigo-web/indigo/internal/protocol/http1"
 	"github.com/indigo-web/indigo/router"
+	"github.com/indigo-web/indigo/transport"
+	"github.com/indigo-web/indigo/transport/proxyproto"
 	"net"
 )
 
 // HTTP1 setups and serves an HTTP/1.1 server until it stops. Note, that the connection isn't
-// automatically closed on server stop
-func HTTP1(cfg *config.Config, conn net.Conn, enc crypt.Encryption, r router.Router) {
+// automatically closed on server stop. As soon as the draining begins, the connection
+// will be closed after the next response
+func HTTP1(cfg *config.Config, conn net.Conn, enc crypt.Encryption, r router.Router, drain *transport.Drain) {
 	client := construct.Client(cfg.NET, conn)
-	body := http1.NewBody(client, construct.Chunked(cfg.Body), cfg.Body)
+	body := http1.NewBody(client, construct.Chunked(cfg.Body), cfg)
 	request := construct.Request(cfg, client, body)
-	request.Env.Encryption = enc
-	suit := http1.Initialize(cfg, r, client, request, body)
+	request.Env = env(conn, enc)
+
+	suit := http1.Initialize(cfg, r, client, request, body, drain)
 	suit.Serve()
 }
+
+// env returns the environment values, which are bound to the connection
+func env(conn net.Conn, enc crypt.Encryption) http.Environment {
+	e := http.Environment{
+		Encryption: enc,
+		Proxy:      proxyproto.HeaderOf(conn),
+	}
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		e.TLS = &state
+	}
+
+	return e
+}
@@ -0,0 +1,18 @@
+package serve
+
+import (
+	"github.com/indigo-web/indigo/config"
+	"github.com/indigo-web/indigo/http/crypt"
+	"github.com/indigo-web/indigo/internal/protocol/http2"
+	"github.com/indigo-web/indigo/router"
+	"github.com/indigo-web/indigo/transport"
+	"net"
+)
+
+// HTTP2 serves an HTTP/2 connection until it's closed. Streams are served concurrently,
+// so the router must be safe for concurrent use. As soon as the draining begins,
+// the client is told via GOAWAY to not open new streams, and the connection is closed
+// after the pending ones are completed
+func HTTP2(cfg *config.Config, conn net.Conn, enc crypt.Encryption, r router.Router, drain *transport.Drain) {
+	http2.New(cfg, conn, r, env(conn, enc), drain).Serve()
+}
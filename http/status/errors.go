@@ -20,6 +20,10 @@ var (
 	ErrConnectionTimeout = NewError(RequestTimeout, "connection timed out")
 	ErrCloseConnection   = NewError(CloseConnection, "internal error as a signal")
 	ErrShutdown          = NewError(CloseConnection, "shutdown")
+	ErrIdleTimeout       = NewError(CloseConnection, "idle connection timed out")
+	ErrHeaderReadTimeout = NewError(RequestTimeout, "reading request headers timed out")
+	ErrBodyReadTimeout   = NewError(RequestTimeout, "reading request body timed out")
+	ErrWriteTimeout      = NewError(CloseConnection, "writing response timed out")
 
 	ErrBadRequest                    = NewError(BadRequest, "bad request")
 	ErrTooLongRequestLine            = NewError(BadRequest, "request line is too long")
@@ -1,22 +1,27 @@
 package indigo
 
 import (
+	"context"
 	"crypto/tls"
 	"github.com/indigo-web/indigo/config"
 	"github.com/indigo-web/indigo/internal/strutil"
 	"github.com/indigo-web/indigo/router"
 	"github.com/indigo-web/indigo/router/inbuilt"
 	"github.com/indigo-web/indigo/transport"
+	"net"
+	"os"
+	"os/signal"
 )
 
 const Version = "0.17.0"
 
-// App is just a struct with addr and shutdown channel that is currently
-// not used. Planning to replace it with context.WithCancel()
+// App is the web-application, consisting of one or more transports served by a
+// single router.
 type App struct {
 	cfg        *config.Config
 	hooks      hooks
 	transports []Transport
+	signals    []os.Signal
 	supervisor transport.Supervisor
 }
 
@@ -34,8 +39,8 @@ func (a *App) Tune(cfg *config.Config) *App {
 	return a
 }
 
-// OnStart calls the callback at the moment, when all the servers are started. However,
-// it isn't strongly guaranteed that they'll be able to accept new connections immediately.
+// OnStart calls the callback at the moment, when all the transports are bound. Connections
+// may be established from this moment on, as they're queued until being accepted.
 func (a *App) OnStart(cb func()) *App {
 	a.hooks.OnStart = cb
 	return a
@@ -50,11 +55,23 @@ func (a *App) OnBind(cb func(addr string)) *App {
 // OnStop calls the callback at the moment, when all the servers are down. It's guaranteed,
 // that at the moment as the callback is called, the server isn't able to accept any new connections
 // and all the clients are already disconnected.
-func (a *App) OnStop(cb func()) *App {
+//
+// The reason is nil if the application was stopped via App.Stop or App.Shutdown. If the
+// context passed to App.ServeContext is done, its cause is passed. Signals, registered via
+// App.NotifyOnSignals, are passed as a SignalError. Otherwise, it's a transport error.
+func (a *App) OnStop(cb func(reason error)) *App {
 	a.hooks.OnStop = cb
 	return a
 }
 
+// OnHandshakeError calls the callback every time a TLS handshake fails, e.g. because the
+// client certificate was rejected or the client didn't trust ours. The connection is closed
+// right after. The callback may be called concurrently.
+func (a *App) OnHandshakeError(cb func(remote net.Addr, err error)) *App {
+	a.hooks.OnHandshakeError = cb
+	return a
+}
+
 func (a *App) Listen(addr string, ts ...Transport) *App {
 	if len(addr) == 0 {
 		// empty addr is considered a no-op Bind operation. Main use-case is omitting
@@ -85,21 +102,57 @@ func (a *App) TLS(addr string, certs ...tls.Certificate) *App {
 	return a.Listen(addr, TLS(certs...))
 }
 
+// Unix is a shortcut for App.Listen(path, indigo.Unix()).
+//
+// Starts a listener on the Unix domain socket at the provided path.
+func (a *App) Unix(path string) *App {
+	return a.Listen(path, Unix())
+}
+
+// NotifyOnSignals makes the application gracefully shut down as soon as any of the
+// passed signals is received. Usually those are syscall.SIGINT and syscall.SIGTERM.
+func (a *App) NotifyOnSignals(signals ...os.Signal) *App {
+	a.signals = append(a.signals, signals...)
+	return a
+}
+
 // Serve starts the web-application. If nil is passed instead of a router, empty inbuilt will
 // be used.
 func (a *App) Serve(r router.Fabric) error {
+	return a.ServeContext(context.Background(), r)
+}
+
+// ServeContext starts the web-application, which runs until the context is done. After that,
+// the graceful shutdown is started, limited by config.NET.ShutdownTimeout. If nil is passed
+// instead of a router, empty inbuilt will be used.
+func (a *App) ServeContext(ctx context.Context, r router.Fabric) error {
 	if r == nil {
 		r = inbuilt.New()
 	}
 
-	return a.run(r.Initialize())
+	return a.run(ctx, r.Initialize())
 }
 
-func (a *App) run(r router.Router) error {
-	callIfNotNil(a.hooks.OnStart)
+func (a *App) run(ctx context.Context, r router.Router) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	if len(a.signals) > 0 {
+		sigch := make(chan os.Signal, 1)
+		signal.Notify(sigch, a.signals...)
+		defer signal.Stop(sigch)
+
+		go func() {
+			select {
+			case sig := <-sigch:
+				cancel(SignalError{Signal: sig})
+			case <-ctx.Done():
+			}
+		}()
+	}
 
 	for _, t := range a.transports {
-		if err := a.supervisor.Add(t.addr, t.inner, t.spawnCallback(a.cfg, r)); err != nil {
+		if err := a.supervisor.Add(t.addr, t.inner, t.spawnCallback(a.cfg, r, a.supervisor.Drain(), a.hooks.OnHandshakeError)); err != nil {
 			return err
 		}
 
@@ -108,21 +161,78 @@ func (a *App) run(r router.Router) error {
 		}
 	}
 
-	err := a.supervisor.Run(a.cfg.NET)
-	callIfNotNil(a.hooks.OnStop)
+	transport.NotifyReady()
+	callIfNotNil(a.hooks.OnStart)
+
+	err := a.supervisor.Run(ctx, a.cfg.NET)
+	if a.hooks.OnStop != nil {
+		reason := err
+		if reason == nil {
+			reason = context.Cause(ctx)
+		}
+
+		a.hooks.OnStop(reason)
+	}
 
 	return err
 }
 
-// Stop stops the whole application immediately and waits until it _really_ stops.
+// Stop gracefully stops the application and waits until it _really_ stops. It lasts no longer
+// than config.NET.ShutdownTimeout, after which the remaining connections are closed forcibly.
 func (a *App) Stop() {
-	a.supervisor.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.NET.ShutdownTimeout)
+	defer cancel()
+	_ = a.Shutdown(ctx)
+}
+
+// Shutdown gracefully stops the application. New connections aren't accepted anymore,
+// while in-flight requests are processed as usual, and their responses are marked with the
+// Connection: close header. Idle keep-alive connections are closed immediately.
+// If the context is done before all the connections are closed, the remaining ones are
+// closed forcibly and the context's error is returned.
+func (a *App) Shutdown(ctx context.Context) error {
+	return a.supervisor.Shutdown(ctx)
+}
+
+// Restart performs a zero-downtime restart. A new instance of the current executable is
+// started with the same arguments, inheriting all the listeners via the LISTEN_FDS
+// environment variable. As soon as it reports all its transports are bound, the current
+// application is gracefully shut down, just like via App.Shutdown. Meanwhile, both instances
+// accept connections from the same sockets, so no connection is refused. If the child exits
+// or doesn't get ready in 30 seconds or until the context is done, it's killed, while the
+// current application keeps serving, and the error is returned.
+//
+// The child picks up the inherited listeners automatically, as long as it binds the same
+// addresses. This is also compatible with the systemd socket activation.
+func (a *App) Restart(ctx context.Context) error {
+	if err := a.supervisor.Handoff(ctx); err != nil {
+		return err
+	}
+
+	return a.Shutdown(ctx)
+}
+
+// RejectedConnections returns the number of connections, rejected by all the listeners
+// due to exceeded config.NET.MaxConnections or config.NET.MaxConnectionsPerIP limits.
+func (a *App) RejectedConnections() uint64 {
+	return a.supervisor.Rejected()
 }
 
 type hooks struct {
-	OnStart func()
-	OnBind  func(addr string)
-	OnStop  func()
+	OnStart          func()
+	OnBind           func(addr string)
+	OnStop           func(reason error)
+	OnHandshakeError func(remote net.Addr, err error)
+}
+
+// SignalError is passed to the OnStop callback as a reason, if the application was stopped
+// by one of the signals, registered via App.NotifyOnSignals.
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (s SignalError) Error() string {
+	return "received signal: " + s.Signal.String()
 }
 
 func callIfNotNil(f func()) {
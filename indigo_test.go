@@ -1,25 +1,48 @@
 package indigo
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"github.com/indigo-web/indigo/http/cookie"
+	"github.com/indigo-web/indigo/http/crypt"
 	"github.com/indigo-web/indigo/http/headers"
 	"github.com/indigo-web/indigo/http/method"
 	"github.com/indigo-web/indigo/http/mime"
 	"github.com/indigo-web/indigo/http/status"
 	"github.com/indigo-web/indigo/internal/httptest"
 	"github.com/indigo-web/indigo/router/inbuilt/middleware"
+	"github.com/indigo-web/indigo/sse"
+	"github.com/indigo-web/indigo/websocket"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
 	"io"
+	"math/big"
+	stdmime "mime"
+	"mime/multipart"
 	"net"
 	stdhttp "net/http"
+	"net/http/httptrace"
+	"net/textproto"
 	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -27,6 +50,7 @@ import (
 
 	"github.com/indigo-web/indigo/config"
 	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/router"
 	"github.com/indigo-web/indigo/router/inbuilt"
 )
 
@@ -181,7 +205,7 @@ func TestFirstPhase(t *testing.T) {
 			OnStart(func() {
 				ch <- struct{}{}
 			}).
-			OnStop(func() {
+			OnStop(func(error) {
 				ch <- struct{}{}
 			}).
 			Listen(altAddr, TCP()).
@@ -667,12 +691,13 @@ func TestSecondPhase(t *testing.T) {
 		r := getInbuiltRouter()
 		s := config.Default()
 		s.NET.ReadTimeout = 500 * time.Millisecond
+		s.NET.HeaderReadTimeout = 500 * time.Millisecond
 		_ = app.
 			Tune(s).
 			OnStart(func() {
 				ch <- struct{}{}
 			}).
-			OnStop(func() {
+			OnStop(func(error) {
 				ch <- struct{}{}
 			}).
 			Serve(r)
@@ -699,49 +724,1717 @@ func TestSecondPhase(t *testing.T) {
 		}
 	})
 
-	doRequest := func(conn net.Conn) error {
-		_, _ = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	t.Run("header read timeout", func(t *testing.T) {
+		conn, err := net.Dial("tcp4", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// dripping the request byte by byte must not extend the deadline. Stop before it
+		// is exceeded, so the connection won't get reset due to unread data
+		for _, b := range []byte("GET /") {
+			_, err = conn.Write([]byte{b})
+			require.NoError(t, err)
+			time.Sleep(80 * time.Millisecond)
+		}
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		response, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		require.Contains(t, string(response), "408 Request Timeout")
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// the request is in-flight, as it isn't completed yet
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\n"))
+		require.NoError(t, err)
+		// make sure the connection is already accepted
+		time.Sleep(100 * time.Millisecond)
+
+		stopped := make(chan struct{})
+		go func() {
+			app.Stop()
+			stopped <- struct{}{}
+		}()
+
+		// give the supervisor some time to start draining
+		time.Sleep(100 * time.Millisecond)
+
+		// the request is served as usual, however the response must tell us to close
+		// the connection
+		_, err = conn.Write([]byte("\r\n"))
+		require.NoError(t, err)
 		buff := make([]byte, 4096)
-		_, err := conn.Read(buff)
-		return err
+		n, err := conn.Read(buff)
+		require.NoError(t, err)
+		require.Contains(t, string(buff[:n]), "Connection: close")
+
+		_, err = conn.Read(buff)
+		require.Error(t, err)
+
+		_, ok := chanRead(stopped, 10*time.Second)
+		require.True(t, ok, "server did not shut down")
+
+		_, err = net.Dial("tcp", addr)
+		require.Error(t, err)
+	})
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	app := New(addr)
+	stopped := runApp(t, app, getInbuiltRouter())
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// the request is never completed, so the connection stays alive until the
+	// header read timeout is exceeded, which is pretty long by default
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n"))
+	require.NoError(t, err)
+	// make sure the connection is already accepted
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	require.ErrorIs(t, app.Shutdown(ctx), context.DeadlineExceeded)
+	_, ok := chanRead(stopped, 5*time.Second)
+	require.True(t, ok, "server did not shut down")
+
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+
+	// the repeated shutdown returns the same result instead of blocking
+	require.ErrorIs(t, app.Shutdown(context.Background()), context.DeadlineExceeded)
+}
+
+func TestShutdownIdle(t *testing.T) {
+	// the idle timeout is pretty long by default, so the shutdown would last for a while,
+	// if the idle connections weren't interrupted
+	app := New(addr)
+	stopped := runApp(t, app, getInbuiltRouter())
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 4096))
+	require.NoError(t, err)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- app.Shutdown(context.Background())
+	}()
+
+	err, ok := chanRead(shutdown, 5*time.Second)
+	require.True(t, ok, "idle connection delays the shutdown")
+	require.NoError(t, err)
+	_, ok = chanRead(stopped, 5*time.Second)
+	require.True(t, ok, "server did not shut down")
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+}
+
+func TestShutdownBeforeServe(t *testing.T) {
+	app := New(addr)
+	require.NoError(t, app.Shutdown(context.Background()))
+
+	served := make(chan error, 1)
+	go func() {
+		served <- app.Serve(getInbuiltRouter())
+	}()
+
+	err, ok := chanRead(served, 5*time.Second)
+	require.True(t, ok, "stopped application must not be served")
+	require.NoError(t, err)
+}
+
+func TestServeContext(t *testing.T) {
+	errStop := errors.New("stop it")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	app := New(addr)
+	stopped := runAppContext(t, ctx, app, getInbuiltRouter())
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	cancel(errStop)
+	reason, ok := chanRead(stopped, 10*time.Second)
+	require.True(t, ok, "server did not shut down")
+	require.ErrorIs(t, reason, errStop)
+
+	// stopping the already stopped application must not block
+	returned := make(chan struct{})
+	go func() {
+		app.Stop()
+		require.NoError(t, app.Shutdown(context.Background()))
+		close(returned)
+	}()
+
+	_, ok = chanRead(returned, 5*time.Second)
+	require.True(t, ok, "stopping after the context is done blocks")
+}
+
+func TestUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "indigo.sock")
+
+	// leave a stale socket file, like a crashed process would do
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	app := New("").Listen(path, UnixWithPerm(0600, -1, -1))
+	r := inbuilt.New().
+		Get("/", func(request *http.Request) *http.Response {
+			return http.String(request, request.Remote.Network())
+		})
+
+	stopped := runApp(t, app, r)
+
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+
+	client := &stdhttp.Client{
+		Transport: &stdhttp.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return new(net.Dialer).DialContext(ctx, "unix", path)
+			},
+		},
 	}
+	resp, err := client.Get("http://unix/")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, "unix", string(body))
 
-	t.Run("shutdown", func(t *testing.T) {
-		client := func(ch chan<- error) {
+	client.CloseIdleConnections()
+	stopApp(t, app, stopped)
+
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err), "socket file must be removed")
+}
+
+func TestProxyProtocol(t *testing.T) {
+	app := New("").
+		Listen(addr, TCP().WithProxyProtocol("127.0.0.0/8", "::1")).
+		Listen(altAddr, TCP().WithProxyProtocol("10.0.0.1"))
+	r := inbuilt.New().
+		Get("/", func(request *http.Request) *http.Response {
+			if request.Env.Proxy == nil {
+				return http.String(request, "no header")
+			}
+
+			return http.String(request, request.Remote.String()+" "+request.Env.Proxy.Destination.String())
+		})
+
+	stopped := runApp(t, app, r)
+
+	const request = "GET / HTTP/1.1\r\nConnection: close\r\n\r\n"
+
+	t.Run("trusted", func(t *testing.T) {
+		resp, err := send(addr, []byte("PROXY TCP4 203.0.113.7 198.51.100.1 51234 443\r\n"+request))
+		require.NoError(t, err)
+		repr, err := httptest.Parse(string(resp))
+		require.NoError(t, err)
+		require.Equal(t, "203.0.113.7:51234 198.51.100.1:443", repr.Body)
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		resp, err := send(altAddr, []byte(request))
+		require.NoError(t, err)
+		repr, err := httptest.Parse(string(resp))
+		require.NoError(t, err)
+		require.Equal(t, "no header", repr.Body)
+	})
+
+	stopApp(t, app, stopped)
+}
+
+func TestReusePort(t *testing.T) {
+	app := New("").Listen(addr, TCP().WithReusePort(4))
+	stopped := runApp(t, app, getInbuiltRouter())
+
+	for range 16 {
+		resp, err := stdhttp.Get(appURL + "/")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+	}
+
+	stdhttp.DefaultClient.CloseIdleConnections()
+	stopApp(t, app, stopped)
+
+	_, err := net.Dial("tcp", addr)
+	require.Error(t, err)
+}
+
+func TestInheritedListener(t *testing.T) {
+	if os.Getenv("INDIGO_INHERIT_CHILD") == "1" {
+		// we're the child process. Serve a single request from the inherited listener
+		// and exit
+		app := New(addr)
+		r := inbuilt.New().
+			Get("/", func(request *http.Request) *http.Response {
+				go app.Stop()
+				return http.String(request, "inherited")
+			})
+		require.NoError(t, app.Serve(r))
+		return
+	}
+
+	l, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	f, err := l.(*net.TCPListener).File()
+	require.NoError(t, err)
+
+	child := exec.Command(os.Args[0], "-test.run=^TestInheritedListener$")
+	child.Env = append(os.Environ(), "INDIGO_INHERIT_CHILD=1", "LISTEN_FDS=1")
+	child.ExtraFiles = []*os.File{f}
+	require.NoError(t, child.Start())
+	require.NoError(t, f.Close())
+	// the socket stays alive, as the child holds it as well
+	require.NoError(t, l.Close())
+
+	resp, err := send(addr, []byte("GET / HTTP/1.1\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	repr, err := httptest.Parse(string(resp))
+	require.NoError(t, err)
+	require.Equal(t, "inherited", repr.Body)
+	require.NoError(t, child.Wait())
+}
+
+func TestRestart(t *testing.T) {
+	switch os.Getenv("INDIGO_RESTART_CHILD") {
+	case "ready":
+		app := New(addr)
+		r := inbuilt.New().
+			Get("/", func(request *http.Request) *http.Response {
+				go app.Stop()
+				return http.String(request, "child")
+			})
+		require.NoError(t, app.Serve(r))
+		return
+	case "failing":
+		// exit before binding anything, so readiness is never reported
+		os.Exit(1)
+	}
+
+	// the child is the same test binary, running this test only
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestRestart$"}
+	defer func() {
+		os.Args = args
+	}()
+
+	r := inbuilt.New().
+		Get("/", func(request *http.Request) *http.Response {
+			return http.String(request, "parent")
+		})
+
+	respond := func(t *testing.T) string {
+		resp, err := send(addr, []byte("GET / HTTP/1.1\r\nConnection: close\r\n\r\n"))
+		require.NoError(t, err)
+		repr, err := httptest.Parse(string(resp))
+		require.NoError(t, err)
+
+		return repr.Body
+	}
+
+	t.Run("not ready", func(t *testing.T) {
+		t.Setenv("INDIGO_RESTART_CHILD", "failing")
+		app := New(addr)
+		stopped := runApp(t, app, r)
+		require.Error(t, app.Restart(context.Background()))
+		// the application keeps serving
+		require.Equal(t, "parent", respond(t))
+		stopApp(t, app, stopped)
+	})
+
+	t.Run("ready", func(t *testing.T) {
+		t.Setenv("INDIGO_RESTART_CHILD", "ready")
+		app := New(addr)
+		stopped := runApp(t, app, r)
+		require.NoError(t, app.Restart(context.Background()))
+		_, ok := chanRead(stopped, 10*time.Second)
+		require.True(t, ok, "parent did not shut down")
+		require.Equal(t, "child", respond(t))
+		// the child stops after the first request, releasing the address
+		require.Eventually(t, func() bool {
 			conn, err := net.Dial("tcp", addr)
-			ch <- nil
+			if err == nil {
+				_ = conn.Close()
+			}
+
+			return err != nil
+		}, 10*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestFromListener(t *testing.T) {
+	cert, key, err := generateSelfSignedCert(t.TempDir())
+	require.NoError(t, err)
+
+	plain, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	secure, err := net.Listen("tcp", altAddr)
+	require.NoError(t, err)
+	secure = tls.NewListener(secure, &tls.Config{Certificates: []tls.Certificate{Cert(cert, key)}})
+
+	app := New("").
+		Listen(plain.Addr().String(), FromListener(plain)).
+		Listen(secure.Addr().String(), FromListener(secure))
+	r := inbuilt.New().
+		Get("/", func(request *http.Request) *http.Response {
+			return http.String(request, strconv.Itoa(int(request.Env.Encryption)))
+		})
+
+	stopped := runApp(t, app, r)
+
+	client := &stdhttp.Client{
+		Transport: &stdhttp.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+
+	for url, want := range map[string]string{
+		"http://" + addr:     strconv.Itoa(int(crypt.Plain)),
+		"https://" + altAddr: strconv.Itoa(int(crypt.TLSv13)),
+	} {
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, want, string(body))
+	}
+
+	client.CloseIdleConnections()
+	stopApp(t, app, stopped)
+
+	_, err = net.Dial("tcp", addr)
+	require.Error(t, err, "the listener must be closed")
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey, err := generateSelfSignedCert(dir)
+	require.NoError(t, err)
+	clientCert, clientKey := issueClientCert(t, dir, "service-a")
+
+	handshakeErrs := make(chan error, 1)
+	app := New("").
+		Listen(
+			addr,
+			TLS(Cert(serverCert, serverKey)).WithClientAuth(tls.RequireAndVerifyClientCert, CertPool(clientCert)),
+		).
+		OnHandshakeError(func(_ net.Addr, err error) {
+			handshakeErrs <- err
+		})
+	r := inbuilt.New().
+		Get("/", func(request *http.Request) *http.Response {
+			state := request.Env.TLS
+			return http.String(request, fmt.Sprintf(
+				"%s %s %t", state.VerifiedChains[0][0].Subject.CommonName, state.ServerName, state.CipherSuite != 0,
+			))
+		})
+
+	stopped := runApp(t, app, r)
+
+	dial := func(certs ...tls.Certificate) (*tls.Conn, error) {
+		return tls.Dial("tcp", addr, &tls.Config{
+			ServerName:         "localhost",
+			InsecureSkipVerify: true,
+			Certificates:       certs,
+		})
+	}
+
+	t.Run("verified", func(t *testing.T) {
+		conn, err := dial(Cert(clientCert, clientKey))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nConnection: close\r\n\r\n"))
+		require.NoError(t, err)
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		repr, err := httptest.Parse(string(resp))
+		require.NoError(t, err)
+		require.Equal(t, "service-a localhost true", repr.Body)
+	})
+
+	t.Run("no certificate", func(t *testing.T) {
+		conn, err := dial()
+		if err == nil {
+			// in TLS 1.3 the client learns about the rejection only on the first read
+			defer conn.Close()
+			_, err = conn.Read(make([]byte, 1))
+		}
+
+		require.Error(t, err)
+		handshakeErr, ok := chanRead(handshakeErrs, 5*time.Second)
+		require.True(t, ok, "the handshake error wasn't reported")
+		require.Error(t, handshakeErr)
+	})
+
+	stopApp(t, app, stopped)
+}
+
+// issueClientCert writes a self-signed certificate, suitable for the client authentication
+func issueClientCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certFile, keyFile
+}
+
+func TestHTTP2(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey, err := generateSelfSignedCert(dir)
+	require.NoError(t, err)
+
+	app := New("").Listen(addr, TLS(Cert(serverCert, serverKey)))
+	r := inbuilt.New().
+		Get("/", func(request *http.Request) *http.Response {
+			return http.String(request, request.Proto.String()+request.Headers.Value("host")).
+				Header("X-Custom", "value").
+				Cookie(cookie.New("hello", "world"))
+		}).
+		Post("/echo", func(request *http.Request) *http.Response {
+			body, err := request.Body.Bytes()
 			if err != nil {
-				ch <- err
-				return
+				return http.Error(request, err)
 			}
 
-			for i := 0; i < 20; i++ {
-				if err := doRequest(conn); err != nil {
-					ch <- err
+			return http.Bytes(request, body)
+		}).
+		Get("/hijack", func(request *http.Request) *http.Response {
+			_, err := request.Hijack()
+			return http.String(request, fmt.Sprint(errors.Is(err, http.ErrNotHijackable)))
+		})
+
+	stopped := runApp(t, app, r)
+
+	client := &stdhttp.Client{
+		Transport: &stdhttp.Transport{
+			ForceAttemptHTTP2: true,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	defer client.CloseIdleConnections()
+	url := "https://" + addr
+
+	t.Run("simple GET", func(t *testing.T) {
+		resp, err := client.Get(url + "/")
+		require.NoError(t, err)
+		require.Equal(t, 2, resp.ProtoMajor)
+		require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+		require.Equal(t, "value", resp.Header.Get("X-Custom"))
+		require.Equal(t, "hello=world", resp.Header.Get("Set-Cookie"))
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, "HTTP/2 "+addr, string(body))
+	})
+
+	t.Run("HEAD", func(t *testing.T) {
+		resp, err := client.Head(url + "/")
+		require.NoError(t, err)
+		require.Equal(t, int64(len("HTTP/2 "+addr)), resp.ContentLength)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Empty(t, body)
+	})
+
+	t.Run("large body", func(t *testing.T) {
+		// exceeds the default flow control windows in both directions
+		payload := bytes.Repeat([]byte("abcdefgh"), 1024*1024)
+		resp, err := client.Post(url+"/echo", "text/plain", bytes.NewReader(payload))
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, len(payload), len(body))
+		require.True(t, bytes.Equal(payload, body))
+	})
+
+	t.Run("concurrent streams", func(t *testing.T) {
+		const n = 32
+		errs := make(chan error, n)
+
+		for i := 0; i < n; i++ {
+			go func(i int) {
+				payload := strings.Repeat(strconv.Itoa(i), 10000)
+				resp, err := client.Post(url+"/echo", "text/plain", strings.NewReader(payload))
+				if err != nil {
+					errs <- err
 					return
 				}
 
-				time.Sleep(100 * time.Millisecond)
+				body, err := io.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+				if err == nil && string(body) != payload {
+					err = fmt.Errorf("stream %d: body mismatch", i)
+				}
+
+				errs <- err
+			}(i)
+		}
+
+		for i := 0; i < n; i++ {
+			require.NoError(t, <-errs)
+		}
+	})
+
+	t.Run("hijack", func(t *testing.T) {
+		resp, err := client.Get(url + "/hijack")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, "true", string(body))
+	})
+
+	t.Run("HTTP/1.1 fallback", func(t *testing.T) {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"http/1.1"},
+		})
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n"))
+		require.NoError(t, err)
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		repr, err := httptest.Parse(string(resp))
+		require.NoError(t, err)
+		require.Equal(t, "HTTP/1.1 example.com", repr.Body)
+	})
+
+	client.CloseIdleConnections()
+	stopApp(t, app, stopped)
+
+	t.Run("disabled", func(t *testing.T) {
+		app := New("").Listen(addr, TLS(Cert(serverCert, serverKey)).WithoutHTTP2())
+		stopped := runApp(t, app, r)
+		defer stopApp(t, app, stopped)
+
+		conn, err := tls.Dial("tcp", addr, &tls.Config{
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2", "http/1.1"},
+		})
+		require.NoError(t, err)
+		defer conn.Close()
+		require.Equal(t, "http/1.1", conn.ConnectionState().NegotiatedProtocol)
+	})
+}
+
+func TestH2C(t *testing.T) {
+	app := New(addr)
+	r := inbuilt.New().
+		Post("/", func(request *http.Request) *http.Response {
+			body, err := request.Body.String()
+			if err != nil {
+				return http.Error(request, err)
 			}
 
-			ch <- nil
+			return http.String(request, request.Proto.String()+body)
+		})
+
+	stopped := runApp(t, app, r)
+
+	t.Run("prior knowledge", func(t *testing.T) {
+		client := &stdhttp.Client{
+			Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return new(net.Dialer).DialContext(ctx, network, addr)
+				},
+			},
 		}
 
-		first := make(chan error)
-		go client(first)
-		<-first
+		resp, err := client.Post("http://"+addr+"/", "text/plain", strings.NewReader("Hello"))
+		require.NoError(t, err)
+		require.Equal(t, 2, resp.ProtoMajor)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, "HTTP/2 Hello", string(body))
+		client.CloseIdleConnections()
+	})
 
-		app.Stop()
+	t.Run("upgrade", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+		request := "POST / HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\n" +
+			"Upgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAAP__\r\nContent-Length: 5\r\n\r\nHello"
+		_, err = conn.Write([]byte(request))
+		require.NoError(t, err)
+
+		reader := bufio.NewReader(conn)
+		resp, err := stdhttp.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		require.Equal(t, stdhttp.StatusSwitchingProtocols, resp.StatusCode)
+		require.Equal(t, "h2c", resp.Header.Get("Upgrade"))
+
+		framer := http2.NewFramer(conn, reader)
+		framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+		_, err = conn.Write([]byte(http2.ClientPreface))
+		require.NoError(t, err)
+		require.NoError(t, framer.WriteSettings())
+
+		var body []byte
+		for {
+			frame, err := framer.ReadFrame()
+			require.NoError(t, err)
+
+			switch f := frame.(type) {
+			case *http2.MetaHeadersFrame:
+				require.Equal(t, uint32(1), f.StreamID)
+				require.Equal(t, "200", f.PseudoValue("status"))
+			case *http2.DataFrame:
+				require.Equal(t, uint32(1), f.StreamID)
+				body = append(body, f.Data()...)
+			}
+
+			if frame.Header().StreamID == 1 && frame.Header().Flags.Has(http2.FlagDataEndStream) {
+				break
+			}
+		}
+
+		require.Equal(t, "HTTP/2 Hello", string(body))
+	})
+
+	stopApp(t, app, stopped)
+
+	t.Run("not over unix", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "h2c.sock")
+		app := New("").Listen(path, Unix())
+		stopped := runApp(t, app, r)
+		defer stopApp(t, app, stopped)
 
-		second := make(chan error)
-		go client(second)
-		<-second
+		conn, err := net.Dial("unix", path)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+		request := "POST / HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings, close\r\n" +
+			"Upgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAAP__\r\nContent-Length: 5\r\n\r\nHello"
+		_, err = conn.Write([]byte(request))
+		require.NoError(t, err)
 
-		require.Error(t, <-second)
-		require.NoError(t, <-first)
+		resp, err := stdhttp.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "HTTP/1.1 Hello", string(body))
 	})
 }
 
+func TestStream(t *testing.T) {
+	// next is signalled by the client after it received a part, so the handler proceeds
+	// only if the previous part was actually flushed
+	next := make(chan struct{})
+	app := New(addr)
+	r := inbuilt.New().
+		Get("/", func(request *http.Request) *http.Response {
+			return request.Respond().
+				ContentType(mime.Plain).
+				Stream(func(w http.StreamWriter) error {
+					for i := range 3 {
+						if _, err := fmt.Fprintf(w, "part %d;", i); err != nil {
+							return err
+						}
+
+						if err := w.Flush(); err != nil {
+							return err
+						}
+
+						select {
+						case <-next:
+						case <-time.After(5 * time.Second):
+							return errors.New("the part was not received")
+						}
+					}
+
+					return nil
+				})
+		})
+
+	stopped := runApp(t, app, r)
+
+	readParts := func(t *testing.T, resp *stdhttp.Response) {
+		buff := make([]byte, len("part 0;"))
+		for i := range 3 {
+			_, err := io.ReadFull(resp.Body, buff)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("part %d;", i), string(buff))
+			next <- struct{}{}
+		}
+
+		n, err := resp.Body.Read(buff)
+		require.Zero(t, n)
+		require.Equal(t, io.EOF, err)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	t.Run("HTTP/1.1", func(t *testing.T) {
+		client := &stdhttp.Client{Transport: &stdhttp.Transport{}}
+		resp, err := client.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		require.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+		readParts(t, resp)
+		client.CloseIdleConnections()
+	})
+
+	t.Run("HTTP/1.0", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+		_, err = conn.Write([]byte("GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+		require.NoError(t, err)
+		resp, err := stdhttp.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		require.Nil(t, resp.TransferEncoding)
+		require.Equal(t, int64(-1), resp.ContentLength)
+		readParts(t, resp)
+	})
+
+	t.Run("HTTP/2", func(t *testing.T) {
+		client := &stdhttp.Client{
+			Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return new(net.Dialer).DialContext(ctx, network, addr)
+				},
+			},
+		}
+
+		resp, err := client.Get("http://" + addr + "/")
+		require.NoError(t, err)
+		require.Equal(t, 2, resp.ProtoMajor)
+		readParts(t, resp)
+		client.CloseIdleConnections()
+	})
+
+	stopApp(t, app, stopped)
+}
+
+func TestSSE(t *testing.T) {
+	cfg := config.Default()
+	cfg.NET.ShutdownTimeout = time.Minute
+	// gone receives whether the handler was notified that the stream must finish
+	gone := make(chan struct{}, 1)
+	app := New(addr).Tune(cfg)
+	r := inbuilt.New().
+		Get("/", func(request *http.Request) *http.Response {
+			return sse.Respond(request, func(stream *sse.Stream) error {
+				if err := stream.Comment("ready"); err != nil {
+					return err
+				}
+
+				<-stream.Done()
+				gone <- struct{}{}
+				return nil
+			})
+		})
+
+	stopped := runApp(t, app, r)
+
+	open := func(t *testing.T) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+
+		buff := make([]byte, 4096)
+		var received string
+		for !strings.Contains(received, ":ready") {
+			n, err := conn.Read(buff)
+			require.NoError(t, err)
+			received += string(buff[:n])
+		}
+
+		return conn
+	}
+
+	t.Run("client gone", func(t *testing.T) {
+		require.NoError(t, open(t).Close())
+		_, ok := chanRead(gone, 5*time.Second)
+		require.True(t, ok, "the stream wasn't closed")
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		conn := open(t)
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, app.Shutdown(ctx))
+		_, ok := chanRead(gone, time.Second)
+		require.True(t, ok, "the stream wasn't closed")
+		_, ok = chanRead(stopped, time.Second)
+		require.True(t, ok, "server did not shut down")
+	})
+}
+
+func TestContentEncoding(t *testing.T) {
+	cfg := config.Default()
+	cfg.Body.MaxSize = 64 * 1024
+	app := New(addr).Tune(cfg)
+	r := inbuilt.New().
+		Post("/", func(request *http.Request) *http.Response {
+			body, err := request.Body.String()
+			if err != nil {
+				return http.Error(request, err)
+			}
+
+			return http.String(request, body)
+		}).
+		Post("/raw", func(request *http.Request) *http.Response {
+			body, err := request.Body.Raw().Bytes()
+			if err != nil {
+				return http.Error(request, err)
+			}
+
+			return http.Bytes(request, body)
+		}).
+		Post("/partial", func(request *http.Request) *http.Response {
+			buff := make([]byte, 5)
+			if _, err := io.ReadFull(request.Body, buff); err != nil {
+				return http.Error(request, err)
+			}
+
+			return http.Bytes(request, buff)
+		})
+
+	stopped := runApp(t, app, r)
+
+	gzipped := func(data []byte) []byte {
+		var buff bytes.Buffer
+		w := gzip.NewWriter(&buff)
+		_, err := w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buff.Bytes()
+	}
+
+	zlibbed := func(data []byte) []byte {
+		var buff bytes.Buffer
+		w := zlib.NewWriter(&buff)
+		_, err := w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buff.Bytes()
+	}
+
+	// the client is shared, so it's checked the connection survives partially read bodies
+	client := &stdhttp.Client{Transport: &stdhttp.Transport{DisableCompression: true}}
+
+	post := func(t *testing.T, path, encoding string, body []byte) (int, string) {
+		request, err := stdhttp.NewRequest(stdhttp.MethodPost, "http://"+addr+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		request.Header.Set("Content-Encoding", encoding)
+
+		resp, err := client.Do(request)
+		require.NoError(t, err)
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		return resp.StatusCode, string(data)
+	}
+
+	payload := []byte(strings.Repeat("Hello, world! ", 100))
+
+	t.Run("gzip", func(t *testing.T) {
+		code, body := post(t, "/", "gzip", gzipped(payload))
+		require.Equal(t, stdhttp.StatusOK, code)
+		require.Equal(t, string(payload), body)
+	})
+
+	t.Run("chain", func(t *testing.T) {
+		code, body := post(t, "/", "deflate, identity, GZIP", gzipped(zlibbed(payload)))
+		require.Equal(t, stdhttp.StatusOK, code)
+		require.Equal(t, string(payload), body)
+	})
+
+	t.Run("partially read", func(t *testing.T) {
+		for range 3 {
+			code, body := post(t, "/partial", "gzip", gzipped(payload))
+			require.Equal(t, stdhttp.StatusOK, code)
+			require.Equal(t, "Hello", body)
+		}
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		compressed := gzipped(payload)
+		code, body := post(t, "/raw", "gzip", compressed)
+		require.Equal(t, stdhttp.StatusOK, code)
+		require.Equal(t, string(compressed), body)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		code, _ := post(t, "/", "compress", payload)
+		require.Equal(t, stdhttp.StatusUnsupportedMediaType, code)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		code, _ := post(t, "/", "gzip", payload)
+		require.Equal(t, stdhttp.StatusBadRequest, code)
+	})
+
+	t.Run("bomb", func(t *testing.T) {
+		bomb := gzipped(make([]byte, 10*cfg.Body.MaxSize))
+		require.Less(t, len(bomb), int(cfg.Body.MaxSize))
+		code, _ := post(t, "/", "gzip", bomb)
+		require.Equal(t, stdhttp.StatusRequestEntityTooLarge, code)
+	})
+
+	client.CloseIdleConnections()
+	stopApp(t, app, stopped)
+}
+
+func TestCompression(t *testing.T) {
+	app := New(addr)
+	payload := strings.Repeat("Hello, world! ", 1000)
+	r := inbuilt.New().
+		Get("/", func(request *http.Request) *http.Response {
+			return http.String(request, payload)
+		}).
+		Get("/small", func(request *http.Request) *http.Response {
+			return http.String(request, "Hello, world!")
+		}).
+		Get("/encoded", func(request *http.Request) *http.Response {
+			return http.String(request, payload).Header("Content-Encoding", "identity")
+		}).
+		Get("/attachment", func(request *http.Request) *http.Response {
+			return request.Respond().
+				ContentType(mime.Plain).
+				Attachment(io.NopCloser(strings.NewReader(payload)), len(payload))
+		})
+
+	stopped := runApp(t, app, r)
+
+	decompress := func(t *testing.T, encoding string, body io.Reader) string {
+		var (
+			r   io.Reader
+			err error
+		)
+
+		switch encoding {
+		case "gzip":
+			r, err = gzip.NewReader(body)
+		case "zstd":
+			var decoder *zstd.Decoder
+			decoder, err = zstd.NewReader(body)
+			r = decoder
+		default:
+			r = body
+		}
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+
+		return string(data)
+	}
+
+	get := func(t *testing.T, client *stdhttp.Client, path, accept string) (*stdhttp.Response, string) {
+		request, err := stdhttp.NewRequest(stdhttp.MethodGet, "http://"+addr+path, nil)
+		require.NoError(t, err)
+		request.Header.Set("Accept-Encoding", accept)
+
+		resp, err := client.Do(request)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, resp.Body.Close())
+		}()
+
+		return resp, decompress(t, resp.Header.Get("Content-Encoding"), resp.Body)
+	}
+
+	client := &stdhttp.Client{Transport: &stdhttp.Transport{DisableCompression: true}}
+
+	t.Run("negotiated", func(t *testing.T) {
+		for accept, want := range map[string]string{
+			"gzip":                    "gzip",
+			"gzip, deflate, br, zstd": "zstd",
+			"zstd;q=0.5, gzip":        "gzip",
+			"identity":                "",
+		} {
+			resp, body := get(t, client, "/", accept)
+			require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+			require.Equal(t, want, resp.Header.Get("Content-Encoding"), accept)
+			require.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+			require.Equal(t, payload, body)
+		}
+	})
+
+	t.Run("too small", func(t *testing.T) {
+		resp, body := get(t, client, "/small", "gzip")
+		require.Empty(t, resp.Header.Get("Content-Encoding"))
+		require.Equal(t, "Hello, world!", body)
+	})
+
+	t.Run("already encoded", func(t *testing.T) {
+		resp, body := get(t, client, "/encoded", "gzip")
+		require.Equal(t, []string{"identity"}, resp.Header.Values("Content-Encoding"))
+		require.Equal(t, payload, body)
+	})
+
+	t.Run("attachment", func(t *testing.T) {
+		resp, body := get(t, client, "/attachment", "gzip")
+		require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		require.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+		require.Equal(t, payload, body)
+	})
+
+	t.Run("HTTP/2", func(t *testing.T) {
+		client := &stdhttp.Client{
+			Transport: &http2.Transport{
+				AllowHTTP:          true,
+				DisableCompression: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return new(net.Dialer).DialContext(ctx, network, addr)
+				},
+			},
+		}
+
+		for _, path := range []string{"/", "/attachment"} {
+			resp, body := get(t, client, path, "gzip")
+			require.Equal(t, 2, resp.ProtoMajor)
+			require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+			require.Equal(t, payload, body)
+		}
+
+		client.CloseIdleConnections()
+	})
+
+	client.CloseIdleConnections()
+	stopApp(t, app, stopped)
+}
+
+func TestExpectContinue(t *testing.T) {
+	app := New(addr)
+	r := inbuilt.New().
+		Post("/", func(request *http.Request) *http.Response {
+			if request.ContentLength > 13 {
+				return http.Error(request, status.ErrBodyTooLarge)
+			}
+
+			body, err := request.Body.String()
+			if err != nil {
+				return http.Error(request, err)
+			}
+
+			return http.String(request, body)
+		})
+
+	stopped := runApp(t, app, r)
+
+	dial := func(t *testing.T) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+
+		return conn, bufio.NewReader(conn)
+	}
+
+	const request = "POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: %d\r\n\r\n"
+
+	t.Run("continue", func(t *testing.T) {
+		conn, reader := dial(t)
+
+		for range 2 {
+			_, err := fmt.Fprintf(conn, request, 13)
+			require.NoError(t, err)
+			resp, err := stdhttp.ReadResponse(reader, nil)
+			require.NoError(t, err)
+			require.Equal(t, stdhttp.StatusContinue, resp.StatusCode)
+
+			_, err = conn.Write([]byte("Hello, world!"))
+			require.NoError(t, err)
+			resp, err = stdhttp.ReadResponse(reader, nil)
+			require.NoError(t, err)
+			require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, "Hello, world!", string(body))
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		conn, reader := dial(t)
+		_, err := fmt.Fprintf(conn, request, 1<<20)
+		require.NoError(t, err)
+
+		resp, err := stdhttp.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		require.Equal(t, stdhttp.StatusRequestEntityTooLarge, resp.StatusCode)
+		require.True(t, resp.Close)
+		_, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		_, err = reader.ReadByte()
+		require.ErrorIs(t, err, io.EOF, "connection must be closed")
+	})
+
+	t.Run("HTTP/1.0", func(t *testing.T) {
+		conn, reader := dial(t)
+		_, err := conn.Write([]byte(
+			"POST / HTTP/1.0\r\nExpect: 100-continue\r\nContent-Length: 13\r\n\r\nHello, world!",
+		))
+		require.NoError(t, err)
+
+		resp, err := stdhttp.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("net/http client", func(t *testing.T) {
+		// the client would've sent the body after the timeout anyway, so make sure it
+		// doesn't have to wait
+		client := &stdhttp.Client{Transport: &stdhttp.Transport{ExpectContinueTimeout: time.Minute}}
+		req, err := stdhttp.NewRequest(stdhttp.MethodPost, appURL, strings.NewReader("Hello, world!"))
+		require.NoError(t, err)
+		req.Header.Set("Expect", "100-continue")
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, "Hello, world!", string(body))
+		require.Less(t, time.Since(start), 10*time.Second)
+		client.CloseIdleConnections()
+	})
+
+	stopApp(t, app, stopped)
+}
+
+func TestInform(t *testing.T) {
+	app := New(addr)
+	r := inbuilt.New().
+		Get("/", func(request *http.Request) *http.Response {
+			for _, code := range []status.Code{status.OK, status.SwitchingProtocols} {
+				if err := request.Inform(code, nil); !errors.Is(err, http.ErrNotInformational) {
+					return http.String(request, fmt.Sprintf("%d: unexpected error: %v", code, err))
+				}
+			}
+
+			hints := headers.New().Add("Link", "</style.css>; rel=preload; as=style")
+			if err := request.Inform(status.EarlyHints, hints); err != nil {
+				return http.Error(request, err)
+			}
+
+			return http.String(request, "Hello, world!")
+		})
+
+	stopped := runApp(t, app, r)
+
+	get := func(t *testing.T, client *stdhttp.Client) {
+		var informed []string
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				informed = append(informed, fmt.Sprintf("%d %s", code, header.Get("Link")))
+				return nil
+			},
+		}
+
+		request, err := stdhttp.NewRequestWithContext(
+			httptrace.WithClientTrace(context.Background(), trace), stdhttp.MethodGet, appURL, nil,
+		)
+		require.NoError(t, err)
+		resp, err := client.Do(request)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+		require.Equal(t, "Hello, world!", string(body))
+		require.Equal(t, []string{"103 </style.css>; rel=preload; as=style"}, informed)
+		client.CloseIdleConnections()
+	}
+
+	t.Run("HTTP/1.1", func(t *testing.T) {
+		get(t, &stdhttp.Client{Transport: &stdhttp.Transport{}})
+	})
+
+	t.Run("HTTP/2", func(t *testing.T) {
+		get(t, &stdhttp.Client{
+			Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return new(net.Dialer).DialContext(ctx, network, addr)
+				},
+			},
+		})
+	})
+
+	t.Run("HTTP/1.0", func(t *testing.T) {
+		resp, err := send(addr, []byte("GET / HTTP/1.0\r\n\r\n"))
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(resp), "HTTP/1.0 200 OK\r\n"), string(resp))
+		require.True(t, strings.HasSuffix(string(resp), "\r\n\r\nHello, world!"), string(resp))
+	})
+
+	stopApp(t, app, stopped)
+}
+
+func TestRange(t *testing.T) {
+	const content = "Hello, world!"
+	path := filepath.Join(t.TempDir(), "hello.txt")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	app := New(addr)
+	r := inbuilt.New().
+		Get("/", func(request *http.Request) *http.Response {
+			return http.File(request, path)
+		})
+
+	stopped := runApp(t, app, r)
+
+	get := func(t *testing.T, client *stdhttp.Client, ranges string) (*stdhttp.Response, string) {
+		request, err := stdhttp.NewRequest(stdhttp.MethodGet, appURL, nil)
+		require.NoError(t, err)
+		if len(ranges) > 0 {
+			request.Header.Set("Range", ranges)
+		}
+
+		resp, err := client.Do(request)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		return resp, string(body)
+	}
+
+	client := &stdhttp.Client{Transport: &stdhttp.Transport{}}
+
+	t.Run("whole", func(t *testing.T) {
+		resp, body := get(t, client, "")
+		require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+		require.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+		require.Equal(t, content, body)
+	})
+
+	t.Run("single range", func(t *testing.T) {
+		resp, body := get(t, client, "bytes=-6")
+		require.Equal(t, stdhttp.StatusPartialContent, resp.StatusCode)
+		require.Equal(t, "bytes 7-12/13", resp.Header.Get("Content-Range"))
+		require.Equal(t, int64(6), resp.ContentLength)
+		require.Equal(t, "world!", body)
+	})
+
+	t.Run("multiple ranges", func(t *testing.T) {
+		resp, body := get(t, client, "bytes=0-4,7-11")
+		require.Equal(t, stdhttp.StatusPartialContent, resp.StatusCode)
+		mediatype, params, err := stdmime.ParseMediaType(resp.Header.Get("Content-Type"))
+		require.NoError(t, err)
+		require.Equal(t, "multipart/byteranges", mediatype)
+
+		var parts []string
+		reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			data, err := io.ReadAll(part)
+			require.NoError(t, err)
+			parts = append(parts, part.Header.Get("Content-Range")+" "+string(data))
+		}
+
+		require.Equal(t, []string{"bytes 0-4/13 Hello", "bytes 7-11/13 world"}, parts)
+	})
+
+	t.Run("not satisfiable", func(t *testing.T) {
+		resp, body := get(t, client, "bytes=100-")
+		require.Equal(t, stdhttp.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+		require.Equal(t, "bytes */13", resp.Header.Get("Content-Range"))
+		require.Empty(t, body)
+	})
+
+	t.Run("HTTP/2", func(t *testing.T) {
+		client := &stdhttp.Client{
+			Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return new(net.Dialer).DialContext(ctx, network, addr)
+				},
+			},
+		}
+
+		resp, body := get(t, client, "bytes=0-4")
+		require.Equal(t, 2, resp.ProtoMajor)
+		require.Equal(t, stdhttp.StatusPartialContent, resp.StatusCode)
+		require.Equal(t, "bytes 0-4/13", resp.Header.Get("Content-Range"))
+		require.Equal(t, "Hello", body)
+		client.CloseIdleConnections()
+	})
+
+	client.CloseIdleConnections()
+	stopApp(t, app, stopped)
+}
+
+func TestConditional(t *testing.T) {
+	const content = "Hello, world!"
+	path := filepath.Join(t.TempDir(), "hello.txt")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	modTime := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+
+	app := New(addr)
+	r := inbuilt.New().
+		Get("/file", func(request *http.Request) *http.Response {
+			return http.File(request, path)
+		}).
+		Get("/dynamic", func(request *http.Request) *http.Response {
+			return http.String(request, content).ETag("v1")
+		}).
+		Put("/dynamic", func(request *http.Request) *http.Response {
+			if err := request.Precondition("v1", modTime); err != nil {
+				return http.Error(request, err)
+			}
+
+			return http.String(request, "updated")
+		}).
+		Delete("/dynamic", func(request *http.Request) *http.Response {
+			if err := request.Precondition("v1", modTime); err != nil {
+				return http.Error(request, err)
+			}
+
+			return http.Respond(request).Code(status.NoContent)
+		})
+
+	stopped := runApp(t, app, r)
+
+	do := func(t *testing.T, client *stdhttp.Client, method, path string, hdrs ...string) (*stdhttp.Response, string) {
+		request, err := stdhttp.NewRequest(method, appURL+path, nil)
+		require.NoError(t, err)
+		for i := 0; i < len(hdrs); i += 2 {
+			request.Header.Set(hdrs[i], hdrs[i+1])
+		}
+
+		resp, err := client.Do(request)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		return resp, string(body)
+	}
+
+	get := func(t *testing.T, client *stdhttp.Client, path string, hdrs ...string) (*stdhttp.Response, string) {
+		return do(t, client, stdhttp.MethodGet, path, hdrs...)
+	}
+
+	client := &stdhttp.Client{Transport: &stdhttp.Transport{}}
+
+	t.Run("file", func(t *testing.T) {
+		resp, body := get(t, client, "/file")
+		require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+		require.Equal(t, "Wed, 21 Oct 2015 07:28:00 GMT", resp.Header.Get("Last-Modified"))
+		etag := resp.Header.Get("ETag")
+		require.True(t, strings.HasPrefix(etag, `W/"`), etag)
+		require.Equal(t, content, body)
+
+		resp, body = get(t, client, "/file", "If-None-Match", etag)
+		require.Equal(t, stdhttp.StatusNotModified, resp.StatusCode)
+		require.Equal(t, etag, resp.Header.Get("ETag"))
+		require.Empty(t, body)
+
+		resp, _ = get(t, client, "/file", "If-Modified-Since", resp.Header.Get("Last-Modified"))
+		require.Equal(t, stdhttp.StatusNotModified, resp.StatusCode)
+
+		resp, _ = get(t, client, "/file", "If-Unmodified-Since", "Wed, 21 Oct 2015 07:27:00 GMT")
+		require.Equal(t, stdhttp.StatusPreconditionFailed, resp.StatusCode)
+	})
+
+	t.Run("dynamic", func(t *testing.T) {
+		resp, body := get(t, client, "/dynamic", "If-None-Match", `"v0"`)
+		require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+		require.Equal(t, `"v1"`, resp.Header.Get("ETag"))
+		require.Equal(t, content, body)
+
+		resp, _ = get(t, client, "/dynamic", "If-Match", `"v0"`)
+		require.Equal(t, stdhttp.StatusPreconditionFailed, resp.StatusCode)
+	})
+
+	t.Run("unsafe methods", func(t *testing.T) {
+		resp, body := do(t, client, stdhttp.MethodPut, "/dynamic", "If-Match", `"v0"`)
+		require.Equal(t, stdhttp.StatusPreconditionFailed, resp.StatusCode)
+		require.NotEqual(t, "updated", body)
+
+		resp, body = do(t, client, stdhttp.MethodPut, "/dynamic", "If-Match", `"v1"`)
+		require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+		require.Equal(t, "updated", body)
+
+		resp, _ = do(t, client, stdhttp.MethodPut, "/dynamic", "If-Match", `W/"v1"`)
+		require.Equal(t, stdhttp.StatusPreconditionFailed, resp.StatusCode)
+
+		resp, _ = do(t, client, stdhttp.MethodDelete, "/dynamic", "If-Unmodified-Since", "Wed, 21 Oct 2015 07:27:00 GMT")
+		require.Equal(t, stdhttp.StatusPreconditionFailed, resp.StatusCode)
+
+		resp, _ = do(t, client, stdhttp.MethodDelete, "/dynamic", "If-Unmodified-Since", "Wed, 21 Oct 2015 07:28:00 GMT")
+		require.Equal(t, stdhttp.StatusNoContent, resp.StatusCode)
+
+		resp, _ = do(t, client, stdhttp.MethodDelete, "/dynamic")
+		require.Equal(t, stdhttp.StatusNoContent, resp.StatusCode)
+	})
+
+	t.Run("HTTP/2", func(t *testing.T) {
+		client := &stdhttp.Client{
+			Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return new(net.Dialer).DialContext(ctx, network, addr)
+				},
+			},
+		}
+
+		resp, body := get(t, client, "/dynamic", "If-None-Match", `"v1"`)
+		require.Equal(t, 2, resp.ProtoMajor)
+		require.Equal(t, stdhttp.StatusNotModified, resp.StatusCode)
+		require.Empty(t, resp.Header.Get("Content-Length"))
+		require.Empty(t, body)
+		client.CloseIdleConnections()
+	})
+
+	client.CloseIdleConnections()
+	stopApp(t, app, stopped)
+}
+
+func TestWebSocket(t *testing.T) {
+	app := New(addr)
+	r := inbuilt.New().
+		Get("/ws", func(request *http.Request) *http.Response {
+			conn, err := websocket.Upgrader{Subprotocols: []string{"echo"}}.Upgrade(request)
+			if err != nil {
+				return http.Error(request, err)
+			}
+
+			for {
+				typ, data, err := conn.ReadMessage()
+				if err != nil {
+					return nil
+				}
+
+				if err = conn.WriteMessage(typ, data); err != nil {
+					return nil
+				}
+			}
+		})
+
+	stopped := runApp(t, app, r)
+
+	handshake := func(key string) string {
+		return "GET /ws HTTP/1.1\r\nHost: localhost\r\nConnection: keep-alive, Upgrade\r\n" +
+			"Upgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: chat, echo\r\n" +
+			"Sec-WebSocket-Key: " + key + "\r\n\r\n"
+	}
+
+	t.Run("echo", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+		_, err = conn.Write([]byte(handshake("dGhlIHNhbXBsZSBub25jZQ==")))
+		require.NoError(t, err)
+
+		reader := bufio.NewReader(conn)
+		resp, err := stdhttp.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		require.Equal(t, stdhttp.StatusSwitchingProtocols, resp.StatusCode)
+		require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+		require.Equal(t, "echo", resp.Header.Get("Sec-WebSocket-Protocol"))
+		require.Empty(t, resp.Header.Get("Content-Length"))
+
+		// a masked text frame with the zero mask key
+		_, err = conn.Write(append([]byte{0x81, 0x80 | 5, 0, 0, 0, 0}, "Hello"...))
+		require.NoError(t, err)
+
+		reply := make([]byte, 7)
+		_, err = io.ReadFull(reader, reply)
+		require.NoError(t, err)
+		require.Equal(t, append([]byte{0x81, 5}, "Hello"...), reply)
+	})
+
+	t.Run("bad handshake", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+		_, err = conn.Write([]byte(handshake("short")))
+		require.NoError(t, err)
+
+		resp, err := stdhttp.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		require.Equal(t, stdhttp.StatusBadRequest, resp.StatusCode)
+	})
+
+	stopApp(t, app, stopped)
+}
+
+func TestConnectionLimits(t *testing.T) {
+	run := func(t *testing.T, cfg *config.Config, test func(t *testing.T)) {
+		app := New(addr)
+		stopped := runApp(t, app.Tune(cfg), getInbuiltRouter())
+		test(t)
+		require.Equal(t, uint64(1), app.RejectedConnections())
+
+		stopApp(t, app, stopped)
+	}
+
+	// hold opens a connection and makes sure it's already being served
+	hold := func(t *testing.T) net.Conn {
+		conn, err := net.Dial("tcp4", addr)
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		_, err = conn.Read(make([]byte, 4096))
+		require.NoError(t, err)
+
+		return conn
+	}
+
+	t.Run("total", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.NET.MaxConnections = 1
+		run(t, cfg, func(t *testing.T) {
+			conn := hold(t)
+			defer conn.Close()
+
+			rejected, err := net.Dial("tcp4", addr)
+			require.NoError(t, err)
+			defer rejected.Close()
+			response, err := io.ReadAll(rejected)
+			require.NoError(t, err)
+			require.Contains(t, string(response), "503 Service Unavailable")
+		})
+	})
+
+	t.Run("across listeners", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.NET.MaxConnections = 1
+		app := New(addr).Listen(altAddr, TCP()).Tune(cfg)
+		stopped := runApp(t, app, getInbuiltRouter())
+
+		conn := hold(t)
+		rejected, err := net.Dial("tcp4", altAddr)
+		require.NoError(t, err)
+		response, err := io.ReadAll(rejected)
+		require.NoError(t, err)
+		require.Contains(t, string(response), "503 Service Unavailable")
+		require.Equal(t, uint64(1), app.RejectedConnections())
+		require.NoError(t, rejected.Close())
+		require.NoError(t, conn.Close())
+
+		stopApp(t, app, stopped)
+	})
+
+	t.Run("per ip", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.NET.MaxConnectionsPerIP = 1
+		cfg.NET.LimitPolicy = config.LimitDrop
+		run(t, cfg, func(t *testing.T) {
+			conn := hold(t)
+			defer conn.Close()
+
+			rejected, err := net.Dial("tcp4", addr)
+			require.NoError(t, err)
+			defer rejected.Close()
+			response, err := io.ReadAll(rejected)
+			require.NoError(t, err)
+			require.Empty(t, response)
+		})
+	})
+}
+
+// runApp serves the application in the background and returns as soon as all its transports
+// are bound. The returned channel receives the reason, the application was stopped with
+func runApp(t *testing.T, app *App, r router.Fabric) <-chan error {
+	return runAppContext(t, context.Background(), app, r)
+}
+
+func runAppContext(t *testing.T, ctx context.Context, app *App, r router.Fabric) <-chan error {
+	started, stopped, served := make(chan struct{}), make(chan error, 1), make(chan error, 1)
+	go func() {
+		served <- app.
+			OnStart(func() {
+				close(started)
+			}).
+			OnStop(func(reason error) {
+				stopped <- reason
+			}).
+			ServeContext(ctx, r)
+	}()
+
+	select {
+	case <-started:
+	case err := <-served:
+		require.FailNow(t, "server did not start", "%v", err)
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "server did not start")
+	}
+
+	return stopped
+}
+
+// stopApp stops the application and waits until it's down
+func stopApp(t *testing.T, app *App, stopped <-chan error) {
+	app.Stop()
+	_, ok := chanRead(stopped, 10*time.Second)
+	require.True(t, ok, "server did not shut down")
+}
+
 func chanRead[T any](ch <-chan T, timeout time.Duration) (value T, ok bool) {
 	timer := time.NewTimer(timeout)
 	select {
@@ -0,0 +1,351 @@
+// Package compression applies the negotiated content coding to responses.
+package compression
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/indigo-web/indigo/config"
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/http/coding"
+	"github.com/indigo-web/indigo/http/headers"
+	"github.com/indigo-web/indigo/http/method"
+	"github.com/indigo-web/indigo/http/status"
+	"github.com/indigo-web/indigo/internal/response"
+	"github.com/indigo-web/indigo/internal/strutil"
+	"github.com/indigo-web/indigo/internal/types"
+	"github.com/indigo-web/utils/strcomp"
+)
+
+// Apply compresses the response with the coding, which is the most preferred by the client
+// among the configured ones, if the response is eligible. In-memory bodies are compressed
+// into the buff, which is returned back, so it can be reused. The response refers to it
+// until it's written. Attachments are compressed on the fly. Responses to HEAD requests
+// get the same headers. In-memory bodies are compressed anyway, so the length is the same
+// as for GET, while attachments are left unknown length instead of being compressed.
+func Apply(cfg *config.Config, request *http.Request, resp *http.Response, buff []byte) []byte {
+	codings := cfg.Compression.Codings
+	fields := resp.Reveal()
+	if len(codings) == 0 || !eligible(cfg.Compression, fields) {
+		return buff
+	}
+
+	// the representation depends on the Accept-Encoding, whether it's compressed or not,
+	// so caches must be told about it
+	if !varies(fields) {
+		resp.Header("Vary", "Accept-Encoding")
+	}
+
+	pool := poolOf(Negotiate(codings, request.Headers.Values("accept-encoding")))
+	if pool == nil {
+		return buff
+	}
+
+	resp.Header("Content-Encoding", pool.coding)
+	// ranges are served for the identity representation only
+	fields.Headers = slices.DeleteFunc(fields.Headers, func(h headers.Header) bool {
+		return strcomp.EqualFold(h.Key, "accept-ranges")
+	})
+	weakenETag(fields)
+
+	if request.Method == method.HEAD && fields.Attachment.Content() != nil {
+		// the compressed length isn't known without compressing, so it's left unknown,
+		// just like for GET
+		fields.Attachment.Close()
+		resp.Attachment(bytes.NewReader(nil), 0)
+
+		return buff
+	}
+
+	compressor := pool.Get().(coding.Compressor)
+
+	if fields.Attachment.Content() != nil {
+		r := &reader{
+			attachment: fields.Attachment,
+			compressor: compressor,
+			pool:       pool,
+			buff:       make([]byte, cfg.HTTP.FileBuffSize),
+		}
+		compressor.Reset(&r.out)
+		// the compressed size is unknown in advance
+		resp.Attachment(r, 0)
+
+		return buff
+	}
+
+	out := bytes.NewBuffer(buff[:0])
+	compressor.Reset(out)
+	// writing into the bytes.Buffer never fails
+	_, _ = compressor.Write(fields.Body)
+	_ = compressor.Close()
+	pool.Put(compressor)
+	resp.Bytes(out.Bytes())
+
+	return out.Bytes()
+}
+
+// weakenETag makes the strong entity tag weak, as the compressed representation isn't
+// byte-to-byte identical to the one the tag was generated for, however it's still
+// semantically equivalent
+func weakenETag(fields *response.Fields) {
+	for i, h := range fields.Headers {
+		if strcomp.EqualFold(h.Key, "etag") && strings.HasPrefix(h.Value, `"`) {
+			fields.Headers[i].Value = "W/" + h.Value
+		}
+	}
+}
+
+// eligible tells whether the response may be compressed
+func eligible(cfg config.Compression, fields *response.Fields) bool {
+	switch {
+	case fields.Code < 200, fields.Code == status.NoContent, fields.Code == status.NotModified,
+		fields.Code == status.PartialContent:
+		return false
+	case fields.Stream != nil:
+		// flushing the compressor on each flush would've ruined the compression ratio
+		return false
+	}
+
+	for _, header := range fields.Headers {
+		// the response is already encoded, or it's a part of the representation
+		if strcomp.EqualFold(header.Key, "content-encoding") || strcomp.EqualFold(header.Key, "content-range") {
+			return false
+		}
+	}
+
+	if !compressible(cfg.MIMEs, fields.ContentType) {
+		return false
+	}
+
+	if fields.Attachment.Content() != nil {
+		size := fields.Attachment.Size()
+		return size <= 0 || size >= cfg.MinSize
+	}
+
+	return len(fields.Body) >= cfg.MinSize
+}
+
+func compressible(mimes []string, contentType string) bool {
+	value, _ := strutil.CutHeader(contentType)
+	value = strings.TrimSpace(value)
+
+	for _, mime := range mimes {
+		if prefix, found := strings.CutSuffix(mime, "*"); found {
+			if len(value) >= len(prefix) && strutil.CmpFold(value[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strutil.CmpFold(value, mime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func varies(fields *response.Fields) bool {
+	for _, header := range fields.Headers {
+		if strcomp.EqualFold(header.Key, "vary") &&
+			(strutil.HasToken(header.Value, "accept-encoding") || strutil.HasToken(header.Value, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Negotiate returns the coding with the highest quality. If several codings have the same
+// quality, the earliest one wins. Empty string means no compression must be applied.
+func Negotiate(codings []string, accept []string) string {
+	best, bestQ := "", 0
+	for _, c := range codings {
+		if q := acceptance(accept, c); q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+
+	return best
+}
+
+// acceptance returns the quality of the coding in thousandths. Explicitly listed codings
+// take precedence over the wildcard.
+func acceptance(accept []string, coding string) int {
+	var (
+		q, wildcard             int
+		explicit, wildcardFound bool
+	)
+
+	for _, value := range accept {
+		for len(value) > 0 {
+			var token string
+			token, value, _ = strings.Cut(value, ",")
+			name, params := strutil.CutHeader(token)
+			name = strings.TrimSpace(name)
+
+			switch {
+			case strutil.CmpFold(name, coding):
+				q, explicit = quality(params), true
+			case name == "*":
+				wildcard, wildcardFound = quality(params), true
+			}
+		}
+	}
+
+	switch {
+	case explicit:
+		return q
+	case wildcardFound:
+		return wildcard
+	default:
+		return 0
+	}
+}
+
+// quality returns the value of the q parameter in thousandths. Malformed values are
+// considered as zero.
+func quality(params string) int {
+	if len(params) == 0 {
+		return 1000
+	}
+
+	q := 1000
+	for key, value := range strutil.WalkKV(params) {
+		if strutil.CmpFold(key, "q") {
+			q = parseQ(value)
+		}
+	}
+
+	return q
+}
+
+func parseQ(value string) int {
+	if len(value) == 0 || len(value) > 5 {
+		return 0
+	}
+
+	var q int
+	switch value[0] {
+	case '0':
+	case '1':
+		q = 1000
+	default:
+		return 0
+	}
+
+	if len(value) == 1 {
+		return q
+	}
+
+	if value[1] != '.' {
+		return 0
+	}
+
+	multiplier := 100
+	for _, c := range value[2:] {
+		if c < '0' || c > '9' {
+			return 0
+		}
+
+		q += int(c-'0') * multiplier
+		multiplier /= 10
+	}
+
+	if q > 1000 {
+		return 0
+	}
+
+	return q
+}
+
+type pool struct {
+	sync.Pool
+	coding string
+}
+
+var pools sync.Map
+
+// poolOf returns the pool of compressors for the coding, or nil if it isn't supported
+func poolOf(name string) *pool {
+	if len(name) == 0 {
+		return nil
+	}
+
+	if p, found := pools.Load(name); found {
+		return p.(*pool)
+	}
+
+	constructor := coding.LookupCompressor(name)
+	if constructor == nil {
+		return nil
+	}
+
+	p, _ := pools.LoadOrStore(name, &pool{
+		Pool: sync.Pool{
+			New: func() any {
+				return constructor()
+			},
+		},
+		coding: strings.ToLower(name),
+	})
+
+	return p.(*pool)
+}
+
+// reader compresses the attachment on the fly
+type reader struct {
+	attachment types.Attachment
+	compressor coding.Compressor
+	pool       *pool
+	out        bytes.Buffer
+	buff       []byte
+	err        error
+}
+
+func (r *reader) Read(b []byte) (int, error) {
+	for r.out.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		r.fill()
+	}
+
+	return r.out.Read(b)
+}
+
+func (r *reader) fill() {
+	n, err := r.attachment.Content().Read(r.buff)
+	if n > 0 {
+		if _, werr := r.compressor.Write(r.buff[:n]); werr != nil {
+			r.err = werr
+			return
+		}
+	}
+
+	switch err {
+	case nil:
+	case io.EOF:
+		if r.err = r.compressor.Close(); r.err == nil {
+			r.err = io.EOF
+		}
+
+		r.release()
+	default:
+		r.err = err
+	}
+}
+
+func (r *reader) Close() error {
+	r.release()
+	r.attachment.Close()
+	return nil
+}
+
+func (r *reader) release() {
+	if r.compressor != nil {
+		r.pool.Put(r.compressor)
+		r.compressor = nil
+	}
+}
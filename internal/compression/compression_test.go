@@ -0,0 +1,230 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/indigo-web/indigo/config"
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/http/method"
+	"github.com/indigo-web/indigo/http/mime"
+	"github.com/indigo-web/indigo/http/status"
+	"github.com/indigo-web/indigo/internal/construct"
+	"github.com/indigo-web/indigo/transport/dummy"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	codings := []string{"zstd", "br", "gzip"}
+
+	for _, tc := range []struct {
+		accept []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{""}, ""},
+		{[]string{"identity"}, ""},
+		{[]string{"gzip"}, "gzip"},
+		{[]string{"GZIP"}, "gzip"},
+		{[]string{"gzip, deflate, br, zstd"}, "zstd"},
+		{[]string{"gzip;q=1.0, br;q=0.5"}, "gzip"},
+		{[]string{"gzip;q=0.5", "br;q=0.8"}, "br"},
+		{[]string{"zstd;q=0, *"}, "br"},
+		{[]string{"*;q=0.1, gzip;q=0.2"}, "gzip"},
+		{[]string{"*;q=0"}, ""},
+		{[]string{"gzip;q=0"}, ""},
+		{[]string{"gzip;q=0.001"}, "gzip"},
+		{[]string{"gzip;q=2"}, ""},
+		{[]string{"gzip;q=0.1234"}, ""},
+		{[]string{"gzip;q=abc, br"}, "br"},
+	} {
+		require.Equal(t, tc.want, Negotiate(codings, tc.accept), "%q", tc.accept)
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	mimes := config.Default().Compression.MIMEs
+	require.True(t, compressible(mimes, mime.HTML))
+	require.True(t, compressible(mimes, "text/plain; charset=utf-8"))
+	require.True(t, compressible(mimes, "Application/JSON"))
+	require.False(t, compressible(mimes, mime.PNG))
+	require.False(t, compressible(mimes, mime.OctetStream))
+	require.False(t, compressible(mimes, ""))
+}
+
+func decompress(t *testing.T, coding string, data []byte) string {
+	var (
+		r   io.Reader
+		err error
+	)
+
+	switch coding {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case "zstd":
+		r, err = zstd.NewReader(bytes.NewReader(data))
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(data))
+	default:
+		t.Fatalf("unexpected coding: %s", coding)
+	}
+	require.NoError(t, err)
+
+	decompressed, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	return string(decompressed)
+}
+
+func header(resp *http.Response, key string) (values []string) {
+	for _, h := range resp.Reveal().Headers {
+		if strings.EqualFold(h.Key, key) {
+			values = append(values, h.Value)
+		}
+	}
+
+	return values
+}
+
+type closer struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestApply(t *testing.T) {
+	cfg := config.Default()
+	payload := strings.Repeat("Hello, world! ", 1000)
+
+	newRequest := func(accept string) *http.Request {
+		request := construct.Request(cfg, dummy.NewNopClient(), nil)
+		request.Method = method.GET
+		request.Headers.Add("Accept-Encoding", accept)
+		return request
+	}
+
+	for _, coding := range cfg.Compression.Codings {
+		t.Run(coding, func(t *testing.T) {
+			request := newRequest(coding)
+			resp := http.String(request, payload)
+			buff := Apply(cfg, request, resp, nil)
+
+			body := resp.Reveal().Body
+			require.Equal(t, []string{coding}, header(resp, "content-encoding"))
+			require.Equal(t, []string{"Accept-Encoding"}, header(resp, "vary"))
+			require.Less(t, len(body), len(payload))
+			require.Equal(t, payload, decompress(t, coding, body))
+			require.Equal(t, buff, body)
+		})
+	}
+
+	t.Run("attachment", func(t *testing.T) {
+		request := newRequest("gzip")
+		source := &closer{Reader: strings.NewReader(payload)}
+		resp := request.Respond().
+			ContentType(mime.Plain).
+			Header("Accept-Ranges", "bytes").
+			Attachment(source, len(payload))
+		Apply(cfg, request, resp, nil)
+		require.Empty(t, header(resp, "accept-ranges"))
+
+		fields := resp.Reveal()
+		require.Zero(t, fields.Attachment.Size())
+		compressed, err := io.ReadAll(fields.Attachment.Content())
+		require.NoError(t, err)
+		require.Equal(t, payload, decompress(t, "gzip", compressed))
+		fields.Attachment.Close()
+		require.True(t, source.closed)
+	})
+
+	t.Run("not accepted", func(t *testing.T) {
+		request := newRequest("identity")
+		resp := http.String(request, payload)
+		Apply(cfg, request, resp, nil)
+		require.Empty(t, header(resp, "content-encoding"))
+		require.Equal(t, []string{"Accept-Encoding"}, header(resp, "vary"))
+		require.Equal(t, payload, string(resp.Reveal().Body))
+	})
+
+	t.Run("not eligible", func(t *testing.T) {
+		for name, build := range map[string]func(*http.Request) *http.Response{
+			"small": func(request *http.Request) *http.Response {
+				return http.String(request, "Hello, world!")
+			},
+			"already encoded": func(request *http.Request) *http.Response {
+				return http.String(request, payload).Header("Content-Encoding", "br")
+			},
+			"partial content": func(request *http.Request) *http.Response {
+				return http.String(request, payload).Code(status.PartialContent)
+			},
+			"not modified": func(request *http.Request) *http.Response {
+				return http.String(request, payload).Code(status.NotModified)
+			},
+			"image": func(request *http.Request) *http.Response {
+				return http.String(request, payload).ContentType(mime.PNG)
+			},
+			"small attachment": func(request *http.Request) *http.Response {
+				return request.Respond().Attachment(strings.NewReader("Hello"), 5)
+			},
+		} {
+			request := newRequest("gzip")
+			resp := build(request)
+			encoding := header(resp, "content-encoding")
+			Apply(cfg, request, resp, nil)
+			require.Equal(t, encoding, header(resp, "content-encoding"), name)
+		}
+	})
+
+	t.Run("head", func(t *testing.T) {
+		request := newRequest("gzip")
+		request.Method = method.HEAD
+		resp := http.String(request, payload)
+		Apply(cfg, request, resp, nil)
+		require.Equal(t, []string{"gzip"}, header(resp, "content-encoding"))
+		require.Equal(t, []string{"Accept-Encoding"}, header(resp, "vary"))
+		// the length must be the same as for GET
+		get := http.String(newRequest("gzip"), payload)
+		Apply(cfg, newRequest("gzip"), get, nil)
+		require.Len(t, resp.Reveal().Body, len(get.Reveal().Body))
+
+		source := &closer{Reader: strings.NewReader(payload)}
+		resp = request.Respond().ContentType(mime.Plain).Attachment(source, len(payload))
+		Apply(cfg, request, resp, nil)
+		require.Equal(t, []string{"gzip"}, header(resp, "content-encoding"))
+		require.Zero(t, resp.Reveal().Attachment.Size())
+		require.True(t, source.closed)
+	})
+
+	t.Run("entity tag is weakened", func(t *testing.T) {
+		request := newRequest("gzip")
+		resp := http.String(request, payload).ETag("v1")
+		Apply(cfg, request, resp, nil)
+		require.Equal(t, []string{`W/"v1"`}, header(resp, "etag"))
+	})
+
+	t.Run("vary is not duplicated", func(t *testing.T) {
+		request := newRequest("gzip")
+		resp := http.String(request, payload).Header("Vary", "Origin, accept-encoding")
+		Apply(cfg, request, resp, nil)
+		require.Equal(t, []string{"Origin, accept-encoding"}, header(resp, "vary"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.Compression.Codings = []string{}
+		request := newRequest("gzip")
+		resp := http.String(request, payload)
+		Apply(cfg, request, resp, nil)
+		require.Empty(t, header(resp, "content-encoding"))
+		require.Empty(t, header(resp, "vary"))
+	})
+}
@@ -0,0 +1,159 @@
+// Package conditional generates validators of files and evaluates the preconditions of
+// requests against the validators of responses, as described in RFC 9110, 13.
+package conditional
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/indigo-web/indigo/config"
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/http/method"
+	"github.com/indigo-web/indigo/http/status"
+	"github.com/indigo-web/indigo/internal/response"
+	"github.com/indigo-web/indigo/internal/strutil"
+	"github.com/indigo-web/indigo/internal/types"
+	"github.com/indigo-web/indigo/internal/validator"
+)
+
+// Apply sets the ETag of file attachments, unless it's already set, and evaluates the
+// preconditions of GET and HEAD requests. Unmet preconditions turn the response into either
+// 304 Not Modified or 412 Precondition Failed. Responses other than 2xx are left untouched,
+// as preconditions must be ignored for them.
+func Apply(cfg *config.Config, request *http.Request, resp *http.Response) {
+	fields := resp.Reveal()
+	if fields.Code < 200 || fields.Code >= 300 {
+		return
+	}
+
+	etag, found := header(fields, "etag")
+	if file, ok := fields.Attachment.Content().(*os.File); ok && !found {
+		if etag = fileTag(cfg.HTTP.ETag, file); len(etag) > 0 {
+			resp.Header("ETag", etag)
+		}
+	}
+
+	if request.Method != method.GET && request.Method != method.HEAD {
+		return
+	}
+
+	lastModified, _ := header(fields, "last-modified")
+
+	switch code := evaluate(request, etag, lastModified); code {
+	case status.NotModified, status.PreconditionFailed:
+		fields.Attachment.Close()
+		fields.Attachment = types.Attachment{}
+		fields.Stream = nil
+		fields.Body = nil
+		resp.Code(code)
+	}
+}
+
+// evaluate returns the status code the response must be replaced with, or 200 OK if all
+// the preconditions are met. The order of evaluation follows RFC 9110, 13.2.2
+func evaluate(request *http.Request, etag, lastModified string) status.Code {
+	modified, hasModified := validator.ParseDate(lastModified)
+
+	if validator.Failed(request.Headers, etag, modified) {
+		return status.PreconditionFailed
+	}
+
+	if values := request.Headers.Values("if-none-match"); len(values) > 0 {
+		if validator.Match(values, etag, true) {
+			return status.NotModified
+		}
+	} else if since, ok := validator.ParseDate(request.Headers.Value("if-modified-since")); ok && hasModified {
+		if !modified.After(since) {
+			return status.NotModified
+		}
+	}
+
+	return status.OK
+}
+
+func header(fields *response.Fields, key string) (string, bool) {
+	for _, h := range fields.Headers {
+		if strutil.CmpFold(h.Key, key) {
+			return h.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// fileTag returns the entity tag of the file, or an empty string if it can't be generated
+func fileTag(kind config.ETag, file *os.File) string {
+	if kind == config.ETagDisabled {
+		return ""
+	}
+
+	stat, err := file.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		return ""
+	}
+
+	if kind == config.ETagStrong {
+		return strongTag(file, stat)
+	}
+
+	buff := make([]byte, 0, 2*16+5)
+	buff = append(buff, `W/"`...)
+	buff = strconv.AppendInt(buff, stat.ModTime().UnixNano(), 16)
+	buff = append(buff, '-')
+	buff = strconv.AppendInt(buff, stat.Size(), 16)
+	buff = append(buff, '"')
+
+	return string(buff)
+}
+
+type fileKey struct {
+	name          string
+	modTime, size int64
+}
+
+// maxCachedTags limits the number of cached strong entity tags. The cache is simply reset,
+// when the limit is exceeded
+const maxCachedTags = 1024
+
+var cache struct {
+	mu   sync.Mutex
+	tags map[fileKey]string
+}
+
+// strongTag returns the hash of the file contents. The hash is cached until the file is
+// modified
+func strongTag(file *os.File, stat os.FileInfo) string {
+	key := fileKey{
+		name:    file.Name(),
+		modTime: stat.ModTime().UnixNano(),
+		size:    stat.Size(),
+	}
+
+	cache.mu.Lock()
+	tag, found := cache.tags[key]
+	cache.mu.Unlock()
+	if found {
+		return tag
+	}
+
+	hash := sha256.New()
+	// io.SectionReader doesn't move the file offset, so the file can be sent afterward
+	if _, err := io.Copy(hash, io.NewSectionReader(file, 0, stat.Size())); err != nil {
+		return ""
+	}
+
+	tag = `"` + base64.RawURLEncoding.EncodeToString(hash.Sum(nil)[:16]) + `"`
+
+	cache.mu.Lock()
+	if cache.tags == nil || len(cache.tags) >= maxCachedTags {
+		cache.tags = make(map[fileKey]string)
+	}
+	cache.tags[key] = tag
+	cache.mu.Unlock()
+
+	return tag
+}
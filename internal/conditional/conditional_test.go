@@ -0,0 +1,151 @@
+package conditional
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/indigo-web/indigo/config"
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/http/method"
+	"github.com/indigo-web/indigo/http/status"
+	"github.com/indigo-web/indigo/internal/construct"
+	"github.com/indigo-web/indigo/transport/dummy"
+	"github.com/stretchr/testify/require"
+)
+
+const lastModified = "Wed, 21 Oct 2015 07:28:00 GMT"
+
+func newRequest(m method.Method, hdrs ...string) *http.Request {
+	request := construct.Request(config.Default(), dummy.NewNopClient(), nil)
+	request.Method = m
+	for i := 0; i < len(hdrs); i += 2 {
+		request.Headers.Add(hdrs[i], hdrs[i+1])
+	}
+
+	return request
+}
+
+func TestEvaluate(t *testing.T) {
+	for _, tc := range []struct {
+		headers []string
+		want    status.Code
+	}{
+		{nil, status.OK},
+		{[]string{"If-None-Match", `"abc"`}, status.NotModified},
+		{[]string{"If-None-Match", `W/"abc"`}, status.NotModified},
+		{[]string{"If-None-Match", `"x", "abc"`}, status.NotModified},
+		{[]string{"If-None-Match", `"x"`, "If-None-Match", `"abc"`}, status.NotModified},
+		{[]string{"If-None-Match", `*`}, status.NotModified},
+		{[]string{"If-None-Match", `"x,y"`}, status.OK},
+		{[]string{"If-None-Match", `abc`}, status.OK},
+		{[]string{"If-Modified-Since", lastModified}, status.NotModified},
+		{[]string{"If-Modified-Since", "Wed, 21 Oct 2015 07:27:59 GMT"}, status.OK},
+		{[]string{"If-Modified-Since", "Wednesday, 21-Oct-15 07:28:00 GMT"}, status.NotModified},
+		{[]string{"If-Modified-Since", "Wed Oct 21 07:28:00 2015"}, status.NotModified},
+		{[]string{"If-Modified-Since", "yesterday"}, status.OK},
+		// If-Modified-Since is ignored, when If-None-Match is present
+		{[]string{"If-None-Match", `"x"`, "If-Modified-Since", lastModified}, status.OK},
+		{[]string{"If-Match", `"abc"`}, status.OK},
+		{[]string{"If-Match", `*`}, status.OK},
+		{[]string{"If-Match", `W/"abc"`}, status.PreconditionFailed},
+		{[]string{"If-Match", `"x"`}, status.PreconditionFailed},
+		{[]string{"If-Unmodified-Since", lastModified}, status.OK},
+		{[]string{"If-Unmodified-Since", "Wed, 21 Oct 2015 07:27:59 GMT"}, status.PreconditionFailed},
+		// If-Unmodified-Since is ignored, when If-Match is present
+		{[]string{"If-Match", `"abc"`, "If-Unmodified-Since", "Wed, 21 Oct 2015 07:27:59 GMT"}, status.OK},
+		{[]string{"If-Match", `"x"`, "If-None-Match", `"abc"`}, status.PreconditionFailed},
+	} {
+		request := newRequest(method.GET, tc.headers...)
+		require.Equal(t, tc.want, evaluate(request, `"abc"`, lastModified), "%q", tc.headers)
+	}
+
+	t.Run("weak entity tag", func(t *testing.T) {
+		request := newRequest(method.GET, "If-Match", `W/"abc"`)
+		require.Equal(t, status.PreconditionFailed, evaluate(request, `W/"abc"`, ""))
+		request = newRequest(method.GET, "If-None-Match", `"abc"`)
+		require.Equal(t, status.NotModified, evaluate(request, `W/"abc"`, ""))
+	})
+
+	t.Run("no validators", func(t *testing.T) {
+		request := newRequest(method.GET, "If-Match", `"abc"`)
+		require.Equal(t, status.PreconditionFailed, evaluate(request, "", ""))
+		request = newRequest(method.GET, "If-Unmodified-Since", lastModified)
+		require.Equal(t, status.OK, evaluate(request, "", ""))
+		request = newRequest(method.GET, "If-None-Match", "*")
+		require.Equal(t, status.NotModified, evaluate(request, "", ""))
+	})
+}
+
+func TestApply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hello.txt")
+	require.NoError(t, os.WriteFile(path, []byte("Hello, world!"), 0o644))
+	modTime := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+
+	etagOf := func(t *testing.T, kind config.ETag) string {
+		cfg := config.Default()
+		cfg.HTTP.ETag = kind
+		request := newRequest(method.GET)
+		resp := http.File(request, path)
+		Apply(cfg, request, resp)
+		resp.Reveal().Attachment.Close()
+		etag, _ := header(resp.Reveal(), "etag")
+
+		return etag
+	}
+
+	t.Run("file", func(t *testing.T) {
+		request := newRequest(method.GET)
+		resp := http.File(request, path)
+		Apply(config.Default(), request, resp)
+		resp.Reveal().Attachment.Close()
+
+		value, _ := header(resp.Reveal(), "last-modified")
+		require.Equal(t, lastModified, value)
+		weak := `W/"` + strconv.FormatInt(modTime.UnixNano(), 16) + `-d"`
+		require.Equal(t, weak, etagOf(t, config.ETagWeak))
+		require.Empty(t, etagOf(t, config.ETagDisabled))
+
+		strong := etagOf(t, config.ETagStrong)
+		require.Regexp(t, `^"[\w-]{22}"$`, strong)
+		require.Equal(t, strong, etagOf(t, config.ETagStrong))
+
+		require.NoError(t, os.WriteFile(path, []byte("Hello, World!"), 0o644))
+		require.NotEqual(t, strong, etagOf(t, config.ETagStrong))
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		for _, m := range []method.Method{method.GET, method.HEAD} {
+			request := newRequest(m, "If-None-Match", `"v1"`)
+			resp := http.String(request, "Hello, world!").ETag("v1")
+			Apply(config.Default(), request, resp)
+			require.Equal(t, status.NotModified, resp.Reveal().Code)
+			require.Empty(t, resp.Reveal().Body)
+			etag, _ := header(resp.Reveal(), "etag")
+			require.Equal(t, `"v1"`, etag)
+		}
+	})
+
+	t.Run("precondition failed", func(t *testing.T) {
+		request := newRequest(method.GET, "If-Match", `"v2"`)
+		resp := http.File(request, path).ETag(`W/"v1"`)
+		Apply(config.Default(), request, resp)
+		require.Equal(t, status.PreconditionFailed, resp.Reveal().Code)
+		require.Nil(t, resp.Reveal().Attachment.Content())
+	})
+
+	t.Run("ignored", func(t *testing.T) {
+		request := newRequest(method.POST, "If-Match", `"v2"`)
+		resp := http.String(request, "Hello, world!").ETag("v1")
+		Apply(config.Default(), request, resp)
+		require.Equal(t, status.OK, resp.Reveal().Code)
+
+		request = newRequest(method.GET, "If-None-Match", `"v1"`)
+		resp = http.String(request, "Hello, world!").ETag("v1").Code(status.NotFound)
+		Apply(config.Default(), request, resp)
+		require.Equal(t, status.NotFound, resp.Reveal().Code)
+	})
+}
@@ -32,7 +32,7 @@ func Chunked(cfg config.Body) *chunkedbody.Parser {
 func Client(cfg config.NET, conn net.Conn) transport.Client {
 	readBuff := make([]byte, cfg.ReadBufferSize)
 
-	return transport.NewClient(conn, cfg.ReadTimeout, readBuff)
+	return transport.NewClient(conn, cfg.WriteTimeout, readBuff)
 }
 
 func Buffers(s *config.Config) (keyBuff *buffer.Buffer, valBuff *buffer.Buffer, startLineBuff *buffer.Buffer) {
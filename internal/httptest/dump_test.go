@@ -20,7 +20,7 @@ func TestDump(t *testing.T) {
 
 	cfg := config.Default()
 	client := dummy.NewCircularClient([]byte("Hello, world!")).OneTime()
-	body := http1.NewBody(client, construct.Chunked(cfg.Body), cfg.Body)
+	body := http1.NewBody(client, construct.Chunked(cfg.Body), cfg)
 	request := construct.Request(config.Default(), client, body)
 	request.Headers = headers.New().
 		Add("hello", "world").
@@ -4,10 +4,13 @@ import (
 	"github.com/indigo-web/chunkedbody"
 	"github.com/indigo-web/indigo/config"
 	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/http/proto"
 	"github.com/indigo-web/indigo/http/status"
 	"github.com/indigo-web/indigo/transport"
+	"github.com/indigo-web/utils/strcomp"
 	"io"
 	"math"
+	"time"
 )
 
 type chunkedBodyReader struct {
@@ -20,14 +23,21 @@ type Body struct {
 	client  transport.Client
 	maxLen  uint
 	counter uint
+	timeout time.Duration
 	chunked chunkedBodyReader
+	// expect tells whether the client waits for 100 Continue before sending the body
+	expect    bool
+	continuer func() error
+	// plain tells whether the body is delimited by the content length
+	plain bool
 }
 
-func NewBody(client transport.Client, chunkedParser *chunkedbody.Parser, s config.Body) *Body {
+func NewBody(client transport.Client, chunkedParser *chunkedbody.Parser, cfg *config.Config) *Body {
 	return &Body{
 		reader:  nop,
 		client:  client,
-		maxLen:  s.MaxSize,
+		maxLen:  cfg.Body.MaxSize,
+		timeout: cfg.NET.BodyReadTimeout,
 		chunked: newChunkedBodyReader(chunkedParser),
 	}
 }
@@ -36,7 +46,32 @@ func (b *Body) Retrieve() ([]byte, error) {
 	return b.reader()
 }
 
+// OnContinue sets the callback, which is called in order to send 100 Continue, when the
+// client expects it and the body is read first time
+func (b *Body) OnContinue(cb func() error) {
+	b.continuer = cb
+}
+
+// Exhausted tells whether the request body was entirely read, so the connection can be read
+// further without consuming it
+func (b *Body) Exhausted() bool {
+	return b.plain && b.counter == 0
+}
+
+// Expecting tells whether the client still waits for 100 Continue, i.e. the body wasn't
+// requested yet and therefore wasn't sent
+func (b *Body) Expecting() bool {
+	return b.expect
+}
+
 func (b *Body) Reset(request *http.Request) {
+	// HTTP/1.0 clients don't understand interim responses, so the expectation must be ignored.
+	// Requests without body have nothing to wait for either
+	b.expect = b.continuer != nil && request.Proto == proto.HTTP11 &&
+		(request.Encoding.Chunked || request.ContentLength > 0) &&
+		strcomp.EqualFold(request.Expect, "100-continue")
+
+	b.plain = false
 	if request.Encoding.Chunked {
 		b.initChunked(request.Encoding.HasTrailer)
 		b.reader = b.readChunked
@@ -46,6 +81,7 @@ func (b *Body) Reset(request *http.Request) {
 	} else {
 		b.initPlain(uint(request.ContentLength))
 		b.reader = b.readPlain
+		b.plain = true
 	}
 }
 
@@ -62,7 +98,7 @@ func (b *Body) readPlain() (body []byte, err error) {
 		return nil, status.ErrBodyTooLarge
 	}
 
-	data, err := b.client.Read()
+	data, err := b.read()
 	if err != nil {
 		return nil, err
 	}
@@ -85,7 +121,7 @@ func (b *Body) initEOFReader() {
 }
 
 func (b *Body) readTillEOF() ([]byte, error) {
-	chunk, err := b.client.Read()
+	chunk, err := b.read()
 	if b.counter > math.MaxUint-uint(len(chunk)) {
 		return nil, status.ErrBodyTooLarge
 	}
@@ -107,7 +143,7 @@ func (b *Body) initChunked(hasTrailer bool) {
 }
 
 func (b *Body) readChunked() (body []byte, err error) {
-	data, err := b.client.Read()
+	data, err := b.read()
 	if err != nil {
 		return nil, err
 	}
@@ -129,6 +165,29 @@ func (b *Body) readChunked() (body []byte, err error) {
 	return chunk, err
 }
 
+// read reads the next piece of the body, waiting for it at most for the body read timeout
+func (b *Body) read() ([]byte, error) {
+	if b.expect {
+		b.expect = false
+		if err := b.continuer(); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.timeout > 0 {
+		if err := b.client.Conn().SetReadDeadline(time.Now().Add(b.timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := b.client.Read()
+	if isTimeout(err) {
+		err = status.ErrBodyReadTimeout
+	}
+
+	return data, err
+}
+
 func nop() ([]byte, error) {
 	return nil, io.EOF
 }
@@ -2,6 +2,7 @@ package http1
 
 import (
 	"github.com/indigo-web/indigo/config"
+	"github.com/indigo-web/indigo/http/proto"
 	"github.com/indigo-web/indigo/http/status"
 	"github.com/indigo-web/indigo/internal/construct"
 	"github.com/indigo-web/indigo/transport/dummy"
@@ -20,7 +21,7 @@ import (
 func getRequestWithBody(chunked bool, body ...[]byte) (*http.Request, *Body) {
 	client := dummy.NewCircularClient(body...).OneTime()
 	chunkedParser := chunkedbody.NewParser(chunkedbody.DefaultSettings())
-	reqBody := NewBody(client, chunkedParser, config.Default().Body)
+	reqBody := NewBody(client, chunkedParser, config.Default())
 
 	var (
 		contentLength int
@@ -102,7 +103,7 @@ func TestBodyReader_Plain(t *testing.T) {
 		request := construct.Request(config.Default(), dummy.NewNopClient(), nil)
 		request.ContentLength = buffSize
 		chunkedParser := chunkedbody.NewParser(chunkedbody.DefaultSettings())
-		body := NewBody(client, chunkedParser, config.Default().Body)
+		body := NewBody(client, chunkedParser, config.Default())
 		body.Reset(request)
 
 		data, err := body.Retrieve()
@@ -123,9 +124,9 @@ func TestBodyReader_Plain(t *testing.T) {
 		request, _ := getRequestWithBody(false, []byte(data))
 		client := dummy.NewCircularClient([]byte(data))
 		chunkedParser := chunkedbody.NewParser(chunkedbody.DefaultSettings())
-		s := config.Default().Body
-		s.MaxSize = 9
-		body := NewBody(client, chunkedParser, s)
+		cfg := config.Default()
+		cfg.Body.MaxSize = 9
+		body := NewBody(client, chunkedParser, cfg)
 		body.Reset(request)
 
 		_, err := readall(body)
@@ -153,3 +154,51 @@ func TestBodyReader_TillEOF(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "Hello, world!", string(actualBody))
 }
+
+func TestBodyReader_Expect(t *testing.T) {
+	newBody := func(proto proto.Proto, contentLength int) (*Body, *int) {
+		var continued int
+		client := dummy.NewCircularClient([]byte("Hello, world!")).OneTime()
+		body := NewBody(client, chunkedbody.NewParser(chunkedbody.DefaultSettings()), config.Default())
+		body.OnContinue(func() error {
+			continued++
+			return nil
+		})
+
+		request := construct.Request(config.Default(), dummy.NewNopClient(), nil)
+		request.Proto = proto
+		request.Expect = "100-Continue"
+		request.ContentLength = contentLength
+		body.Reset(request)
+
+		return body, &continued
+	}
+
+	t.Run("lazily", func(t *testing.T) {
+		body, continued := newBody(proto.HTTP11, 13)
+		require.True(t, body.Expecting())
+		require.Zero(t, *continued)
+
+		data, err := readall(body)
+		require.NoError(t, err)
+		require.Equal(t, "Hello, world!", string(data))
+		require.Equal(t, 1, *continued)
+		require.False(t, body.Expecting())
+	})
+
+	t.Run("no body", func(t *testing.T) {
+		body, continued := newBody(proto.HTTP11, 0)
+		require.False(t, body.Expecting())
+		_, err := readall(body)
+		require.NoError(t, err)
+		require.Zero(t, *continued)
+	})
+
+	t.Run("HTTP/1.0", func(t *testing.T) {
+		body, continued := newBody(proto.HTTP10, 13)
+		require.False(t, body.Expecting())
+		_, err := readall(body)
+		require.NoError(t, err)
+		require.Zero(t, *continued)
+	})
+}
@@ -0,0 +1,102 @@
+package http1
+
+import (
+	"bytes"
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/http/crypt"
+	"github.com/indigo-web/indigo/internal/protocol/http2"
+	"github.com/indigo-web/indigo/internal/strutil"
+	"github.com/indigo-web/utils/uf"
+	"net"
+)
+
+var switchToH2C = []byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+
+// h2cAllowed tells whether the connection may be switched to HTTP/2 in cleartext. It's
+// done over plain TCP only, as over TLS the protocol is negotiated via ALPN instead.
+func (s *Suit) h2cAllowed() bool {
+	if s.Parser.request.Env.Encryption != crypt.Plain {
+		return false
+	}
+
+	_, isTCP := s.client.Conn().LocalAddr().(*net.TCPAddr)
+	return isTCP
+}
+
+// priorKnowledge tells whether the data is the beginning of the HTTP/2 connection preface.
+// If it's incomplete, the rest is read. The returned data must be used instead of the
+// passed one afterward.
+func (s *Suit) priorKnowledge(data []byte) ([]byte, bool) {
+	if !s.h2cAllowed() || !isPrefacePrefix(data) {
+		return data, false
+	}
+
+	if len(data) >= len(http2.Preface) {
+		return data, true
+	}
+
+	// the preface is split, which practically never happens. So it's fine to copy it
+	buff := append([]byte(nil), data...)
+	for len(buff) < len(http2.Preface) {
+		next, err := s.client.Read()
+		if err != nil {
+			// the connection is anyway broken
+			return buff, true
+		}
+
+		buff = append(buff, next...)
+		if !isPrefacePrefix(buff) {
+			return buff, false
+		}
+	}
+
+	return buff, true
+}
+
+func isPrefacePrefix(data []byte) bool {
+	n := min(len(data), len(http2.Preface))
+	return n > 0 && uf.B2S(data[:n]) == http2.Preface[:n]
+}
+
+// serveH2C switches the connection to HTTP/2 with prior knowledge
+func (s *Suit) serveH2C(pending []byte) {
+	req := s.Parser.request
+	http2.New(s.cfg, s.client.Conn(), s.router, req.Env, s.drain).ServePriorKnowledge(pending)
+}
+
+// upgradeH2C switches the connection to HTTP/2, if the request is a valid h2c upgrade
+// request. Otherwise, false is returned and the request must be processed as usual.
+// The request body is read completely before switching, as the request is then served
+// via HTTP/2.
+func (s *Suit) upgradeH2C(req *http.Request) bool {
+	values := req.Headers.Values("http2-settings")
+	if !s.h2cAllowed() || len(values) != 1 || !strutil.HasToken(req.Connection, "http2-settings") {
+		return false
+	}
+
+	settings, err := http2.ParseSettings(values[0])
+	if err != nil {
+		return false
+	}
+
+	// the body is passed to the stream as is, so it's decompressed there
+	body, err := req.Body.Raw().Bytes()
+	if err != nil {
+		// the upgrade is ignored, as it's impossible to process the request anyway
+		return false
+	}
+
+	if err = s.client.Write(switchToH2C); err != nil {
+		return true
+	}
+
+	var pending []byte
+	if pender, ok := s.client.(interface{ Pending() []byte }); ok {
+		pending = pender.Pending()
+	}
+
+	http2.New(s.cfg, s.client.Conn(), s.router, req.Env, s.drain).
+		ServeUpgrade(req, bytes.Clone(body), settings, pending)
+
+	return true
+}
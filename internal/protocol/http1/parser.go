@@ -317,6 +317,12 @@ headerValue:
 		request.Headers.Add(key, value)
 
 		switch len(key) {
+		case 6:
+			if cExpect == encodeU64(
+				key[0]|0x20, key[1]|0x20, key[2]|0x20, key[3]|0x20, key[4]|0x20, key[5]|0x20, 0, 0,
+			) {
+				request.Expect = value
+			}
 		case 7:
 			encoded := encodeU64(
 				key[0]|0x20, key[1]|0x20, key[2]|0x20, key[3]|0x20, key[4]|0x20, key[5]|0x20, key[6]|0x20, 0,
@@ -431,6 +437,7 @@ func trimPrefixSpaces(b []byte) []byte {
 }
 
 var (
+	cExpect   = encodeU64('e', 'x', 'p', 'e', 'c', 't', 0, 0)
 	cUpgrade  = encodeU64('u', 'p', 'g', 'r', 'a', 'd', 'e', 0)
 	cTrailer  = encodeU64('t', 'r', 'a', 'i', 'l', 'e', 'r', 0)
 	cContent  = encodeU64('c', 'o', 'n', 't', 'e', 'n', 't', '-')
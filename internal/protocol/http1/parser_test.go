@@ -5,6 +5,7 @@ import (
 	"github.com/dchest/uniuri"
 	"github.com/indigo-web/indigo/internal/construct"
 	"github.com/indigo-web/indigo/internal/requestgen"
+	"github.com/indigo-web/indigo/transport"
 	"github.com/indigo-web/indigo/transport/dummy"
 	"strings"
 	"testing"
@@ -21,9 +22,9 @@ import (
 func getParser() (*Parser, *http.Request) {
 	cfg := config.Default()
 	client := dummy.NewNopClient()
-	body := NewBody(client, construct.Chunked(cfg.Body), cfg.Body)
+	body := NewBody(client, construct.Chunked(cfg.Body), cfg)
 	req := construct.Request(cfg, client, body)
-	suit := Initialize(cfg, nil, client, req, body)
+	suit := Initialize(cfg, nil, client, req, body, transport.NewDrain())
 
 	return suit.Parser, req
 }
@@ -279,6 +280,17 @@ func TestHttpRequestsParser_Parse_GET(t *testing.T) {
 		require.Equal(t, "Keep-Alive", request.Connection)
 		require.NoError(t, request.Reset())
 	})
+
+	t.Run("expect", func(t *testing.T) {
+		raw := "POST / HTTP/1.1\r\nEXPECT: 100-continue\r\nContent-Length: 13\r\n\r\n"
+		state, extra, err := parser.Parse([]byte(raw))
+		require.NoError(t, err)
+		require.Equal(t, HeadersCompleted, state)
+		require.Empty(t, string(extra))
+		require.Equal(t, "100-continue", request.Expect)
+		require.NoError(t, request.Reset())
+		require.Empty(t, request.Expect)
+	})
 }
 
 func TestHttpRequestsParser_POST(t *testing.T) {
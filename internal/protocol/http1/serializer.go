@@ -12,8 +12,10 @@ import (
 	"github.com/indigo-web/utils/strcomp"
 	"io"
 	"log"
+	"math"
+	"net"
+	"os"
 	"strconv"
-	"time"
 )
 
 const (
@@ -24,28 +26,36 @@ const (
 	crlf             = "\r\n"
 )
 
+// vectoredBodySize is the minimal size of the body, which is sent along with the headers
+// via vectored write instead of being copied into the response buffer
+const vectoredBodySize = 4096
+
 // minimalFileBuffSize defines the minimal size of the file buffer. In case it's less
 // it'll be set to this value and debug log will be printed
 const minimalFileBuffSize = 16
 
-var (
-	chunkedFinalizer = []byte("0\r\n\r\n")
-	gmt              = time.FixedZone("GMT", 0)
-)
+var chunkedFinalizer = []byte("0\r\n\r\n")
+
+var continueResponse = http.NewResponse().Code(status.Continue)
 
 type Writer interface {
 	Write([]byte) error
+	// Writev sends both buffers at once, preferably without merging them
+	Writev(head, body []byte) error
 }
 
 type Serializer struct {
 	request *http.Request
 	writer  Writer
 	buff    []byte
+	// informBuff holds interim responses, as they're sent apart from the main buffer
+	informBuff []byte
 	// fileBuff isn't allocated until needed in order to save memory in cases,
 	// where no files are being sent
 	fileBuff       []byte
 	fileBuffSize   int
 	defaultHeaders defaultHeaders
+	watcher        func() (done <-chan struct{}, stop func())
 }
 
 func NewSerializer(
@@ -73,6 +83,13 @@ func NewSerializer(
 	}
 }
 
+// OnStream sets the callback, which starts watching the connection while the streaming
+// response is sent. The returned channel is closed as soon as the stream must finish,
+// and the function stops watching
+func (d *Serializer) OnStream(cb func() (done <-chan struct{}, stop func())) {
+	d.watcher = cb
+}
+
 // PreWrite writes the response into the buffer without actually sending it. Usually used
 // for informational responses
 func (d *Serializer) PreWrite(protocol proto.Proto, response *http.Response) {
@@ -81,6 +98,27 @@ func (d *Serializer) PreWrite(protocol proto.Proto, response *http.Response) {
 	d.renderResponseLine(fields)
 	d.renderHeaders(fields)
 	d.crlf()
+	// the headers of the pre-written response mustn't exclude the defaults of the final one
+	d.defaultHeaders.Reset()
+}
+
+// Inform sends the informational response immediately
+func (d *Serializer) Inform(response *http.Response) error {
+	// the buffer may hold the pre-written response, which must be sent along with the final
+	// one, so the interim response is rendered separately
+	pending := d.buff
+	d.buff = d.informBuff[:0]
+	d.PreWrite(proto.HTTP11, response)
+	err := d.writer.Write(d.buff)
+	d.informBuff, d.buff = d.buff, pending
+
+	return err
+}
+
+// Continue sends the 100 Continue interim response, permitting the client to transmit
+// the request body
+func (d *Serializer) Continue() error {
+	return d.Inform(continueResponse)
 }
 
 // Write writes the response, keeping in mind difference between 1.0 and 1.1 HTTP versions
@@ -93,6 +131,10 @@ func (d *Serializer) Write(
 	fields := response.Reveal()
 	d.renderResponseLine(fields)
 
+	if fields.Stream != nil {
+		return d.sendStream(protocol, fields)
+	}
+
 	if fields.Attachment.Content() != nil {
 		return d.sendAttachment(d.request, response, d.writer)
 	}
@@ -103,17 +145,17 @@ func (d *Serializer) Write(
 		d.renderCookie(c)
 	}
 
-	d.renderContentLength(int64(len(fields.Body)))
+	// 304 Not Modified may carry the length of the selected representation only, which
+	// isn't known here
+	if !isInformational(fields.Code) && fields.Code != status.NotModified {
+		d.renderContentLength(int64(len(fields.Body)))
+	}
 	d.crlf()
 
-	if d.request.Method != method.HEAD {
-		// HEAD request responses must be similar to GET request responses, except
-		// forced lack of body, even if Content-Length is specified
-		d.buff = append(d.buff, fields.Body...)
+	if err = d.writeBody(fields.Body); err != nil {
+		return err
 	}
 
-	err = d.writer.Write(d.buff)
-
 	if !isKeepAlive(protocol, d.request) && d.request.Upgrade == proto.Unknown {
 		err = status.ErrCloseConnection
 	}
@@ -121,6 +163,23 @@ func (d *Serializer) Write(
 	return err
 }
 
+// writeBody sends the rendered headers along with the body. Big bodies are sent by vectored
+// write, so they aren't copied into the response buffer
+func (d *Serializer) writeBody(body []byte) error {
+	if d.request.Method == method.HEAD {
+		// HEAD request responses must be similar to GET request responses, except
+		// forced lack of body, even if Content-Length is specified
+		body = nil
+	}
+
+	if len(body) >= vectoredBodySize {
+		return d.writer.Writev(d.buff, body)
+	}
+
+	d.buff = append(d.buff, body...)
+	return d.writer.Write(d.buff)
+}
+
 func (d *Serializer) renderResponseLine(fields *response.Fields) {
 	statusLine := status.Line(fields.Code)
 
@@ -144,6 +203,12 @@ func (d *Serializer) renderHeaders(fields *response.Fields) {
 		d.defaultHeaders.Exclude(header.Key)
 	}
 
+	if isInformational(fields.Code) {
+		// informational responses have no content, so there's nothing to describe. Default
+		// headers are meant for the final response only
+		return
+	}
+
 	for _, header := range d.defaultHeaders {
 		if header.Excluded {
 			continue
@@ -170,6 +235,9 @@ func (d *Serializer) sendAttachment(
 	if size > 0 {
 		d.renderHeaders(fields)
 		d.renderContentLength(int64(size))
+	} else if request.Method == method.HEAD {
+		// the length is unknown, however there's no body to be chunked either
+		d.renderHeaders(fields)
 	} else {
 		d.renderHeaders(response.TransferEncoding("chunked").Reveal())
 	}
@@ -181,7 +249,7 @@ func (d *Serializer) sendAttachment(
 	d.crlf()
 
 	if err = writer.Write(d.buff); err != nil {
-		return status.ErrCloseConnection
+		return err
 	}
 
 	if request.Method == method.HEAD {
@@ -190,13 +258,10 @@ func (d *Serializer) sendAttachment(
 		return nil
 	}
 
-	if len(d.fileBuff) == 0 {
-		d.fileBuff = make([]byte, d.fileBuffSize)
-	}
-
-	if fields.Attachment.Size() > 0 {
-		err = d.writePlainBody(fields.Attachment.Content(), writer)
+	if size := fields.Attachment.Size(); size > 0 {
+		err = d.writePlainBody(fields.Attachment.Content(), int64(size), writer)
 	} else {
+		d.allocFileBuff()
 		err = d.writeChunkedBody(fields.Attachment.Content(), writer)
 	}
 
@@ -205,13 +270,88 @@ func (d *Serializer) sendAttachment(
 	return err
 }
 
-func (d *Serializer) writePlainBody(r io.Reader, writer Writer) error {
-	// TODO: implement checking whether r implements io.ReaderAt interface. In case it does
-	//       body may be transferred more efficiently. This requires implementing io.Writer
-	//       *http.ResponseWriter
+// sendStream writes the headers and lets the stream function produce the body. HTTP/1.1
+// responses are chunked, and HTTP/1.0 ones are delimited by closing the connection
+func (d *Serializer) sendStream(protocol proto.Proto, fields *response.Fields) error {
+	chunked := protocol != proto.HTTP10
+	if chunked {
+		fields.TransferEncoding = "chunked"
+	}
+
+	d.renderHeaders(fields)
+	for _, c := range fields.Cookies {
+		d.renderCookie(c)
+	}
+	d.crlf()
+
+	if err := d.writer.Write(d.buff); err != nil {
+		return err
+	}
+
+	if d.request.Method != method.HEAD {
+		d.allocFileBuff()
+		w := newStreamWriter(d.writer, d.fileBuff, chunked)
+		if d.watcher != nil {
+			var stop func()
+			w.done, stop = d.watcher()
+			defer stop()
+		}
+
+		if err := fields.Stream(w); err != nil {
+			if w.err != nil {
+				return w.err
+			}
+
+			// the body is already partially sent, so closing the connection without
+			// finalizing it is the only way to tell the client the response is broken
+			return status.ErrCloseConnection
+		}
+
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if !chunked {
+			return status.ErrCloseConnection
+		}
+
+		if err := d.writer.Write(chunkedFinalizer); err != nil {
+			return err
+		}
+	}
+
+	if !isKeepAlive(protocol, d.request) {
+		return status.ErrCloseConnection
+	}
+
+	return nil
+}
+
+func (d *Serializer) writePlainBody(r io.Reader, size int64, writer Writer) error {
+	if file, limit, ok := fileOf(r); ok {
+		if rf := zeroCopy(writer); rf != nil {
+			// the kernel transmits the file directly into the socket. The limit is essential,
+			// as the file might've grown since its size was taken
+			n, err := rf.ReadFrom(io.LimitReader(file, min(size, limit)))
+			if err != nil || n < size {
+				// the Content-Length is already sent, so the response can't be completed
+				return status.ErrCloseConnection
+			}
+
+			return nil
+		}
+	}
+
+	d.allocFileBuff()
 
 	for {
 		n, err := r.Read(d.fileBuff)
+		if n > 0 {
+			if werr := writer.Write(d.fileBuff[:n]); werr != nil {
+				return werr
+			}
+		}
+
 		switch err {
 		case nil:
 		case io.EOF:
@@ -219,10 +359,49 @@ func (d *Serializer) writePlainBody(r io.Reader, writer Writer) error {
 		default:
 			return status.ErrCloseConnection
 		}
+	}
+}
 
-		if err = writer.Write(d.fileBuff[:n]); err != nil {
-			return status.ErrCloseConnection
-		}
+// fileOf returns the file behind the reader along with the number of bytes it's limited to.
+// Files limited via io.LimitedReader, e.g. a single range of them, are recognized as well
+func fileOf(r io.Reader) (file *os.File, limit int64, ok bool) {
+	if wrapper, ok := r.(interface{ Unwrap() io.Reader }); ok {
+		r = wrapper.Unwrap()
+	}
+
+	switch r := r.(type) {
+	case *os.File:
+		return r, math.MaxInt64, true
+	case *io.LimitedReader:
+		file, ok = r.R.(*os.File)
+		return file, r.N, ok
+	default:
+		return nil, 0, false
+	}
+}
+
+// zeroCopy returns the writer as io.ReaderFrom, if it's backed by a plain TCP connection,
+// which is able to make use of sendfile(2). Other connections, e.g. TLS ones, would've
+// copied the data through the user space anyway, so nil is returned for them
+func zeroCopy(writer Writer) io.ReaderFrom {
+	client, ok := writer.(interface {
+		io.ReaderFrom
+		Conn() net.Conn
+	})
+	if !ok {
+		return nil
+	}
+
+	if _, ok = client.Conn().(*net.TCPConn); !ok {
+		return nil
+	}
+
+	return client
+}
+
+func (d *Serializer) allocFileBuff() {
+	if len(d.fileBuff) == 0 {
+		d.fileBuff = make([]byte, d.fileBuffSize)
 	}
 }
 
@@ -247,7 +426,7 @@ func (d *Serializer) writeChunkedBody(r io.Reader, writer Writer) error {
 			copy(d.fileBuff[buffOffset+n:], crlf)
 
 			if err := writer.Write(d.fileBuff[blankSpace : buffOffset+n+crlfSize]); err != nil {
-				return status.ErrCloseConnection
+				return err
 			}
 		}
 
@@ -261,68 +440,103 @@ func (d *Serializer) writeChunkedBody(r io.Reader, writer Writer) error {
 	}
 }
 
-// renderHeaderInto the buffer. Appends CRLF in the end
-func (d *Serializer) renderHeader(header headers.Header) {
-	d.buff = append(d.buff, header.Key...)
-	d.colonsp()
-	d.buff = append(d.buff, header.Value...)
-	d.crlf()
+// streamWriter buffers the data written by the stream function. In case of chunked
+// transfer encoding, the space for the chunk length is reserved in the beginning of
+// the buffer and for the trailing CRLF in the end of it, so chunks are sent without
+// copying
+type streamWriter struct {
+	writer     Writer
+	buff       []byte
+	begin, end int
+	n          int
+	chunked    bool
+	err        error
+	done       <-chan struct{}
 }
 
-func (d *Serializer) renderCookie(c cookie.Cookie) {
-	d.buff = append(d.buff, setCookie...)
-	d.buff = append(d.buff, c.Name...)
-	d.buff = append(d.buff, '=')
-	d.buff = append(d.buff, c.Value...)
-	d.buff = append(d.buff, ';', ' ')
+// chunkLengthSize is the space reserved for the hexadecimal chunk length
+const chunkLengthSize = 8
 
-	if len(c.Path) > 0 {
-		d.buff = append(d.buff, "Path="...)
-		d.buff = append(d.buff, c.Path...)
-		d.buff = append(d.buff, ';', ' ')
+func newStreamWriter(writer Writer, buff []byte, chunked bool) *streamWriter {
+	begin, end := 0, len(buff)
+	if chunked {
+		begin, end = chunkLengthSize+len(crlf), end-len(crlf)
 	}
 
-	if len(c.Domain) > 0 {
-		d.buff = append(d.buff, "Domain="...)
-		d.buff = append(d.buff, c.Domain...)
-		d.buff = append(d.buff, ';', ' ')
+	return &streamWriter{
+		writer:  writer,
+		buff:    buff,
+		begin:   begin,
+		end:     end,
+		n:       begin,
+		chunked: chunked,
 	}
+}
 
-	if !c.Expires.IsZero() {
-		d.buff = append(d.buff, "Expires="...)
-		// TODO: this will probably be slow. Can be optimized via rendering it manually
-		//  directly into the d.buff
-		d.buff = append(d.buff, c.Expires.In(gmt).Format(time.RFC1123)...)
-		d.buff = append(d.buff, ';', ' ')
+func (s *streamWriter) Write(b []byte) (n int, err error) {
+	if s.err != nil {
+		return 0, s.err
 	}
 
-	if c.MaxAge != 0 {
-		maxage := "0"
-		if c.MaxAge > 0 {
-			maxage = strconv.Itoa(c.MaxAge)
+	for len(b) > 0 {
+		if s.n == s.end {
+			if err = s.Flush(); err != nil {
+				return n, err
+			}
 		}
 
-		d.buff = append(d.buff, "MaxAge="...)
-		d.buff = append(d.buff, maxage...)
-		d.buff = append(d.buff, ';', ' ')
+		copied := copy(s.buff[s.n:s.end], b)
+		s.n += copied
+		n += copied
+		b = b[copied:]
 	}
 
-	if len(c.SameSite) > 0 {
-		d.buff = append(d.buff, "SameSite="...)
-		d.buff = append(d.buff, c.SameSite...)
-		d.buff = append(d.buff, ';', ' ')
+	return n, nil
+}
+
+func (s *streamWriter) Flush() error {
+	if s.err != nil {
+		return s.err
 	}
 
-	if c.Secure {
-		d.buff = append(d.buff, "Secure; "...)
+	if s.n == s.begin {
+		// empty chunk would've terminated the body
+		return nil
 	}
 
-	if c.HttpOnly {
-		d.buff = append(d.buff, "HttpOnly; "...)
+	data := s.buff[s.begin:s.n]
+	if s.chunked {
+		length := strconv.AppendUint(s.buff[:0], uint64(len(data)), 16)
+		offset := chunkLengthSize - len(length)
+		copy(s.buff[offset:], length)
+		copy(s.buff[chunkLengthSize:], crlf)
+		copy(s.buff[s.n:], crlf)
+		data = s.buff[offset : s.n+len(crlf)]
 	}
 
-	// strip last 2 bytes, which are always a semicolon and a space
-	d.buff = d.buff[:len(d.buff)-2]
+	s.n = s.begin
+	s.err = s.writer.Write(data)
+
+	return s.err
+}
+
+// Done returns a channel, which is closed as soon as the client is gone or the draining
+// begins. It's never closed, if the connection isn't watched
+func (s *streamWriter) Done() <-chan struct{} {
+	return s.done
+}
+
+// renderHeaderInto the buffer. Appends CRLF in the end
+func (d *Serializer) renderHeader(header headers.Header) {
+	d.buff = append(d.buff, header.Key...)
+	d.colonsp()
+	d.buff = append(d.buff, header.Value...)
+	d.crlf()
+}
+
+func (d *Serializer) renderCookie(c cookie.Cookie) {
+	d.buff = append(d.buff, setCookie...)
+	d.buff = cookie.Render(d.buff, c)
 	d.crlf()
 }
 
@@ -358,6 +572,10 @@ func (d *Serializer) clear() {
 	d.defaultHeaders.Reset()
 }
 
+func isInformational(code status.Code) bool {
+	return code >= 100 && code < 200
+}
+
 func isKeepAlive(protocol proto.Proto, req *http.Request) bool {
 	switch protocol {
 	case proto.HTTP10:
@@ -412,7 +630,7 @@ func (d defaultHeaders) Exclude(key string) {
 }
 
 func (d defaultHeaders) Reset() {
-	for _, header := range d {
-		header.Excluded = false
+	for i := range d {
+		d[i].Excluded = false
 	}
 }
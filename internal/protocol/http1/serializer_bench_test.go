@@ -18,6 +18,10 @@ func (n NopClientWriter) Write([]byte) error {
 	return nil
 }
 
+func (n NopClientWriter) Writev(_, _ []byte) error {
+	return nil
+}
+
 func BenchmarkSerializer(b *testing.B) {
 	defaultHeadersSmall := map[string]string{
 		"Server": "indigo",
@@ -40,7 +44,7 @@ func BenchmarkSerializer(b *testing.B) {
 
 	response := http.NewResponse()
 	request := construct.Request(config.Default(), dummy.NewNopClient(), NewBody(
-		dummy.NewNopClient(), nil, config.Default().Body,
+		dummy.NewNopClient(), nil, config.Default(),
 	))
 	client := NopClientWriter{}
 
@@ -118,7 +122,7 @@ func BenchmarkSerializer(b *testing.B) {
 	b.Run("no body 15 headers", func(b *testing.B) {
 		buff := make([]byte, 0, 1024)
 		request := construct.Request(config.Default(), dummy.NewNopClient(), NewBody(
-			dummy.NewNopClient(), nil, config.Default().Body,
+			dummy.NewNopClient(), nil, config.Default(),
 		))
 		request.Headers = requestgen.Headers(15)
 		serializer := NewSerializer(buff, 128, nil, request, client)
@@ -11,13 +11,18 @@ import (
 	"github.com/indigo-web/indigo/http/proto"
 	"github.com/indigo-web/indigo/http/status"
 	"github.com/indigo-web/indigo/internal/construct"
+	"github.com/indigo-web/indigo/transport"
 	"github.com/indigo-web/indigo/transport/dummy"
 	"github.com/stretchr/testify/require"
 	"io"
 	"math"
+	"net"
 	stdhttp "net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -38,6 +43,11 @@ func (a *accumulativeWriter) Write(b []byte) error {
 	return nil
 }
 
+func (a *accumulativeWriter) Writev(head, body []byte) error {
+	a.Data = append(append(a.Data, head...), body...)
+	return nil
+}
+
 func TestSerializer_Write(t *testing.T) {
 	request := newRequest()
 	request.Method = method.GET
@@ -186,6 +196,22 @@ func TestSerializer_Write(t *testing.T) {
 		require.Empty(t, string(fullBody))
 	})
 
+	t.Run("attachment of unknown size in response to a HEAD request", func(t *testing.T) {
+		request.Method = method.HEAD
+		writer := new(accumulativeWriter)
+		serializer := newSerializer(nil, request, writer)
+		response := http.NewResponse().Attachment(strings.NewReader("Hello, world!"), 0)
+		stdreq, err := stdhttp.NewRequest(stdhttp.MethodHead, "/", nil)
+		require.NoError(t, err)
+
+		require.NoError(t, serializer.Write(proto.HTTP11, response))
+		resp, err := stdhttp.ReadResponse(bufio.NewReader(bytes.NewBuffer(writer.Data)), stdreq)
+		require.NoError(t, err)
+		require.Nil(t, resp.TransferEncoding)
+		require.NotContains(t, resp.Header, "Content-Length")
+		require.True(t, strings.HasSuffix(string(writer.Data), "\r\n\r\n"))
+	})
+
 	t.Run("cookies", func(t *testing.T) {
 		t.Run("single pair no params", func(t *testing.T) {
 			writer := new(accumulativeWriter)
@@ -273,15 +299,52 @@ func TestSerializer_PreWrite(t *testing.T) {
 		resp, err := stdhttp.ReadResponse(reader, r)
 		require.NoError(t, err)
 		require.Equal(t, 101, resp.StatusCode)
-		require.Contains(t, resp.Header, "Hello")
-		require.Equal(t, []string{"world"}, resp.Header["Hello"])
+		require.NotContains(t, resp.Header, "Hello")
 		require.Equal(t, "HTTP/1.0", resp.Proto)
 
 		resp, err = stdhttp.ReadResponse(reader, r)
 		require.NoError(t, err)
 		require.Equal(t, 200, resp.StatusCode)
+		require.Equal(t, []string{"world"}, resp.Header["Hello"])
 		require.Equal(t, "HTTP/1.1", resp.Proto)
 	})
+
+	t.Run("default headers after interim response", func(t *testing.T) {
+		request := newRequest()
+		writer := new(accumulativeWriter)
+		serializer := newSerializer(map[string]string{"Hello": "world"}, request, writer)
+		require.NoError(t, serializer.Inform(http.NewResponse().
+			Code(status.EarlyHints).
+			Header("Hello", "early"),
+		))
+		require.NoError(t, serializer.Write(proto.HTTP11, http.NewResponse()))
+
+		reader := bufio.NewReader(bytes.NewBuffer(writer.Data))
+		resp, err := stdhttp.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		require.Equal(t, 103, resp.StatusCode)
+		require.Equal(t, []string{"early"}, resp.Header["Hello"])
+
+		resp, err = stdhttp.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		require.Equal(t, 200, resp.StatusCode)
+		require.Equal(t, []string{"world"}, resp.Header["Hello"])
+	})
+
+	t.Run("interim response keeps the pre-written one", func(t *testing.T) {
+		request := newRequest()
+		writer := new(accumulativeWriter)
+		serializer := newSerializer(nil, request, writer)
+		serializer.PreWrite(proto.HTTP11, http.NewResponse().Code(status.SwitchingProtocols))
+		require.NoError(t, serializer.Inform(http.NewResponse().Code(status.EarlyHints)))
+		require.Equal(t, "HTTP/1.1 103 Early Hints\r\n\r\n", string(writer.Data))
+
+		require.NoError(t, serializer.Write(proto.HTTP11, http.NewResponse()))
+		data := strings.TrimPrefix(string(writer.Data), "HTTP/1.1 103 Early Hints\r\n\r\n")
+		require.True(t, strings.HasPrefix(data, "HTTP/1.1 101 "), data)
+		require.Equal(t, 1, strings.Count(data, " 101 "))
+		require.Contains(t, data, "HTTP/1.1 200 OK\r\n")
+	})
 }
 
 func TestSerializer_ChunkedTransfer(t *testing.T) {
@@ -304,7 +367,7 @@ func TestSerializer_ChunkedTransfer(t *testing.T) {
 		reader := bytes.NewBuffer([]byte(payload))
 		writer := new(accumulativeWriter)
 		cfg := config.Default()
-		req := construct.Request(cfg, dummy.NewNopClient(), NewBody(nil, nil, cfg.Body))
+		req := construct.Request(cfg, dummy.NewNopClient(), NewBody(nil, nil, cfg))
 		serializer := newSerializer(nil, req, writer)
 		serializer.fileBuff = make([]byte, buffSize)
 
@@ -326,3 +389,269 @@ func TestSerializer_ChunkedTransfer(t *testing.T) {
 		require.Equal(t, payload, string(data))
 	})
 }
+
+type vectorWriter struct {
+	accumulativeWriter
+	Vectors [][][]byte
+}
+
+func (v *vectorWriter) Writev(head, body []byte) error {
+	v.Vectors = append(v.Vectors, [][]byte{head, body})
+	return v.accumulativeWriter.Writev(head, body)
+}
+
+func TestSerializer_Vectored(t *testing.T) {
+	body := strings.Repeat("a", vectoredBodySize)
+
+	t.Run("big body", func(t *testing.T) {
+		writer := new(vectorWriter)
+		serializer := newSerializer(nil, newRequest(), writer)
+		response := http.NewResponse().String(body)
+		require.NoError(t, serializer.Write(proto.HTTP11, response))
+		require.Len(t, writer.Vectors, 1)
+		require.Len(t, writer.Vectors[0], 2)
+		require.Equal(t, body, string(writer.Vectors[0][1]))
+		// the response buffer must not grow to the size of the body
+		require.Less(t, cap(serializer.buff), vectoredBodySize)
+
+		resp, err := stdhttp.ReadResponse(bufio.NewReader(bytes.NewReader(writer.Data)), nil)
+		require.NoError(t, err)
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, body, string(data))
+	})
+
+	t.Run("small body", func(t *testing.T) {
+		writer := new(vectorWriter)
+		serializer := newSerializer(nil, newRequest(), writer)
+		response := http.NewResponse().String(body[:vectoredBodySize-1])
+		require.NoError(t, serializer.Write(proto.HTTP11, response))
+		require.Empty(t, writer.Vectors)
+		require.Contains(t, string(writer.Data), body[:vectoredBodySize-1])
+	})
+
+	t.Run("transport client", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+
+		received := make(chan []byte, 1)
+		go func() {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				received <- nil
+				return
+			}
+
+			defer conn.Close()
+			resp, err := stdhttp.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				received <- nil
+				return
+			}
+
+			data, _ := io.ReadAll(resp.Body)
+			received <- data
+		}()
+
+		conn, err := ln.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+		client := transport.NewClient(conn, time.Second, nil)
+		serializer := newSerializer(nil, newRequest(), client)
+		require.NoError(t, serializer.Write(proto.HTTP11, http.NewResponse().String(body)))
+
+		require.Equal(t, body, string(<-received))
+	})
+
+	t.Run("HEAD", func(t *testing.T) {
+		writer := new(vectorWriter)
+		request := newRequest()
+		request.Method = method.HEAD
+		serializer := newSerializer(nil, request, writer)
+		response := http.NewResponse().String(body)
+		require.NoError(t, serializer.Write(proto.HTTP11, response))
+		require.Empty(t, writer.Vectors)
+		require.NotContains(t, string(writer.Data), body)
+	})
+}
+
+func TestSerializer_PlainTransfer(t *testing.T) {
+	createFile := func(t *testing.T, size int) (*os.File, []byte) {
+		content := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+		path := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(path, content, 0o644))
+		file, err := os.Open(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = file.Close() })
+
+		return file, content
+	}
+
+	t.Run("zero-copy", func(t *testing.T) {
+		// bigger than a single chunk of client's ReadFrom
+		file, content := createFile(t, 3*1024*1024+16)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+
+		received := make(chan []byte, 1)
+		go func() {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				received <- nil
+				return
+			}
+
+			data, _ := io.ReadAll(conn)
+			_ = conn.Close()
+			received <- data
+		}()
+
+		conn, err := ln.Accept()
+		require.NoError(t, err)
+		client := transport.NewClient(conn, time.Second, nil)
+		serializer := newSerializer(nil, nil, client)
+
+		// the file is longer than declared, so the exceeding part must not be sent
+		require.NoError(t, serializer.writePlainBody(file, int64(len(content)-16), client))
+		require.Nil(t, serializer.fileBuff)
+		require.NoError(t, conn.Close())
+		require.Equal(t, content[:len(content)-16], <-received)
+	})
+
+	t.Run("buffered fallback", func(t *testing.T) {
+		file, content := createFile(t, 1024)
+		writer := new(accumulativeWriter)
+		serializer := newSerializer(nil, nil, writer)
+
+		require.NoError(t, serializer.writePlainBody(file, int64(len(content)), writer))
+		require.NotNil(t, serializer.fileBuff)
+		require.Equal(t, content, writer.Data)
+	})
+
+	t.Run("data along with EOF", func(t *testing.T) {
+		writer := new(accumulativeWriter)
+		serializer := newSerializer(nil, nil, writer)
+		reader := iotest.DataErrReader(strings.NewReader("Hello, world!"))
+
+		require.NoError(t, serializer.writePlainBody(reader, 13, writer))
+		require.Equal(t, "Hello, world!", string(writer.Data))
+	})
+}
+
+type writesCounter struct {
+	accumulativeWriter
+	Writes int
+	Err    error
+}
+
+func (w *writesCounter) Write(b []byte) error {
+	w.Writes++
+	if w.Err != nil {
+		return w.Err
+	}
+
+	return w.accumulativeWriter.Write(b)
+}
+
+func TestSerializer_Stream(t *testing.T) {
+	request := newRequest()
+	request.Method = method.GET
+	stdreq, err := stdhttp.NewRequest(stdhttp.MethodGet, "/", nil)
+	require.NoError(t, err)
+
+	produce := func(w http.StreamWriter) error {
+		for _, part := range []string{"Hello", ", ", strings.Repeat("world", 100), "!"} {
+			if _, err := w.Write([]byte(part)); err != nil {
+				return err
+			}
+
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+
+		// flushing nothing must not terminate the body
+		return w.Flush()
+	}
+	want := "Hello, " + strings.Repeat("world", 100) + "!"
+
+	t.Run("HTTP/1.1", func(t *testing.T) {
+		writer := new(writesCounter)
+		serializer := newSerializer(nil, request, writer)
+		response := http.NewResponse().String("ignored").Stream(produce)
+
+		require.NoError(t, serializer.Write(proto.HTTP11, response))
+		// headers, every flushed part (the longest one exceeds the buffer) and the finalizer
+		require.Greater(t, writer.Writes, 6)
+		resp, err := stdhttp.ReadResponse(bufio.NewReader(bytes.NewBuffer(writer.Data)), stdreq)
+		require.NoError(t, err)
+		require.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, want, string(body))
+	})
+
+	t.Run("HTTP/1.0", func(t *testing.T) {
+		writer := new(accumulativeWriter)
+		serializer := newSerializer(nil, request, writer)
+		response := http.NewResponse().Stream(produce)
+
+		require.EqualError(t, serializer.Write(proto.HTTP10, response), status.ErrCloseConnection.Error())
+		resp, err := stdhttp.ReadResponse(bufio.NewReader(bytes.NewBuffer(writer.Data)), stdreq)
+		require.NoError(t, err)
+		require.Nil(t, resp.TransferEncoding)
+		require.Equal(t, int64(-1), resp.ContentLength)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, want, string(body))
+	})
+
+	t.Run("HEAD request", func(t *testing.T) {
+		request := newRequest()
+		request.Method = method.HEAD
+		writer := new(accumulativeWriter)
+		serializer := newSerializer(nil, request, writer)
+		response := http.NewResponse().Stream(func(http.StreamWriter) error {
+			panic("must not be called")
+		})
+
+		require.NoError(t, serializer.Write(proto.HTTP11, response))
+		require.True(t, bytes.HasSuffix(writer.Data, []byte("\r\n\r\n")))
+	})
+
+	t.Run("handler error", func(t *testing.T) {
+		writer := new(accumulativeWriter)
+		serializer := newSerializer(nil, request, writer)
+		response := http.NewResponse().Stream(func(w http.StreamWriter) error {
+			_, _ = w.Write([]byte("Hello"))
+			_ = w.Flush()
+			return io.ErrUnexpectedEOF
+		})
+		require.EqualError(t, serializer.Write(proto.HTTP11, response), status.ErrCloseConnection.Error())
+		require.True(t, bytes.HasSuffix(writer.Data, []byte("5\r\nHello\r\n")))
+	})
+
+	t.Run("client disconnect", func(t *testing.T) {
+		writer := &writesCounter{Err: io.ErrClosedPipe}
+		serializer := newSerializer(nil, request, writer)
+		var writeErr error
+		response := http.NewResponse().Stream(func(w http.StreamWriter) error {
+			_, writeErr = w.Write([]byte("Hello"))
+			return writeErr
+		})
+
+		require.EqualError(t, serializer.Write(proto.HTTP11, response), io.ErrClosedPipe.Error())
+		// the headers write fails, so the stream function is never called
+		require.NoError(t, writeErr)
+		require.Equal(t, 1, writer.Writes)
+
+		sw := newStreamWriter(writer, make([]byte, 32), true)
+		_, err := sw.Write([]byte(strings.Repeat("a", 64)))
+		require.ErrorIs(t, err, io.ErrClosedPipe)
+		_, err = sw.Write([]byte("a"))
+		require.ErrorIs(t, err, io.ErrClosedPipe)
+		require.ErrorIs(t, sw.Flush(), io.ErrClosedPipe)
+	})
+}
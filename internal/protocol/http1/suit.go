@@ -1,24 +1,37 @@
 package http1
 
 import (
+	"errors"
 	"fmt"
 	"github.com/indigo-web/indigo/config"
 	"github.com/indigo-web/indigo/http"
 	"github.com/indigo-web/indigo/http/proto"
 	"github.com/indigo-web/indigo/http/status"
+	"github.com/indigo-web/indigo/internal/compression"
+	"github.com/indigo-web/indigo/internal/conditional"
 	"github.com/indigo-web/indigo/internal/construct"
+	"github.com/indigo-web/indigo/internal/ranges"
 	"github.com/indigo-web/indigo/router"
 	"github.com/indigo-web/indigo/transport"
 	"github.com/indigo-web/utils/buffer"
+	"net"
+	"sync"
+	"time"
 )
 
 type Suit struct {
 	*Parser
 	*Serializer
+	cfg            *config.Config
 	upgradePreResp *http.Response
 	body           *Body
 	router         router.Router
 	client         transport.Client
+	drain          *transport.Drain
+	idleTimeout    time.Duration
+	headerTimeout  time.Duration
+	// compressBuff holds the compressed response body
+	compressBuff []byte
 }
 
 func New(
@@ -30,25 +43,40 @@ func New(
 	keyBuff, valBuff, startLineBuff *buffer.Buffer,
 	respBuff []byte,
 	respFileBuffSize int,
+	drain *transport.Drain,
 ) *Suit {
-	return &Suit{
+	serializer := NewSerializer(respBuff, respFileBuffSize, cfg.Headers.Default, request, client)
+	request.SetSerializer(serializer)
+	body.OnContinue(serializer.Continue)
+
+	suit := &Suit{
 		Parser:         NewParser(request, keyBuff, valBuff, startLineBuff, cfg.Headers),
-		Serializer:     NewSerializer(respBuff, respFileBuffSize, cfg.Headers.Default, request, client),
+		Serializer:     serializer,
+		cfg:            cfg,
 		upgradePreResp: http.NewResponse(),
 		body:           body,
 		router:         r,
 		client:         client,
+		drain:          drain,
+		idleTimeout:    cfg.NET.IdleTimeout,
+		headerTimeout:  cfg.NET.HeaderReadTimeout,
 	}
+	serializer.OnStream(suit.watch)
+
+	return suit
 }
 
 // Initialize is the same constructor as just New, but consumes fewer arguments.
-func Initialize(cfg *config.Config, r router.Router, client transport.Client, req *http.Request, body *Body) *Suit {
+func Initialize(
+	cfg *config.Config, r router.Router, client transport.Client, req *http.Request, body *Body,
+	drain *transport.Drain,
+) *Suit {
 	keyBuff, valBuff, startLineBuff := construct.Buffers(cfg)
 	respBuff := make([]byte, 0, cfg.HTTP.ResponseBuffSize)
 
 	return New(
 		cfg, r, req, client, body, keyBuff, valBuff, startLineBuff,
-		respBuff, cfg.HTTP.ResponseBuffSize,
+		respBuff, cfg.HTTP.ResponseBuffSize, drain,
 	)
 }
 
@@ -63,16 +91,59 @@ func (s *Suit) Serve() {
 func (s *Suit) serve(once bool) (ok bool) {
 	req := s.Parser.request
 	client := s.client
+	// idle tells whether no bytes of the next request were received yet
+	idle := true
+	// fresh tells whether nothing was received on the connection yet, so it may turn
+	// out to be HTTP/2 with prior knowledge
+	fresh := true
+	conn := client.Conn()
+	// idle connections are interrupted as soon as the draining begins, so they don't
+	// delay the shutdown until the idle timeout exceeds
+	defer s.drain.Busy(conn)
+	if !s.wait(conn) {
+		return false
+	}
 
 	for {
 		data, err := client.Read()
 		if err != nil {
-			// read-error most probably means deadline exceeding. Just notify the user in
-			// this case and return
-			s.router.OnError(req, status.ErrCloseConnection)
+			switch {
+			case idle && s.drain.Draining():
+				// interrupted by the graceful shutdown
+			case !isTimeout(err):
+				s.router.OnError(req, status.ErrCloseConnection)
+			case idle:
+				// the client simply didn't send anything. Close the connection silently
+				s.router.OnError(req, status.ErrIdleTimeout)
+			default:
+				// the client is too slow at sending the headers. The connection is anyway
+				// going to be closed, so we don't care whether writing succeeds
+				resp := respond(req, s.router.OnError(req, status.ErrHeaderReadTimeout))
+				_ = s.Write(req.Proto, resp)
+			}
+
 			return false
 		}
 
+		if fresh {
+			fresh = false
+
+			var h2c bool
+			if data, h2c = s.priorKnowledge(data); h2c {
+				s.serveH2C(data)
+				return false
+			}
+		}
+
+		if idle {
+			// the header timeout is set once, so it can't be extended by dripping the request
+			// byte by byte. It also overrides the deadline, exceeded by the draining, if it
+			// has begun in the meantime
+			idle = false
+			s.drain.Busy(conn)
+			s.setReadDeadline(s.headerTimeout)
+		}
+
 		state, extra, err := s.Parse(data)
 		switch state {
 		case Pending:
@@ -80,6 +151,10 @@ func (s *Suit) serve(once bool) (ok bool) {
 			client.Unread(extra)
 			s.body.Reset(req)
 
+			if req.Upgrade == proto.HTTP2 && s.upgradeH2C(req) {
+				return false
+			}
+
 			version := req.Proto
 			if req.Upgrade != proto.Unknown && proto.HTTP1&req.Upgrade == req.Upgrade {
 				// TODO: replace this with a method "WriteUpgrade" or similar
@@ -100,16 +175,45 @@ func (s *Suit) serve(once bool) (ok bool) {
 				return false
 			}
 
+			conditional.Apply(s.cfg, req, resp)
+			ranges.Apply(req, resp)
+			s.compressBuff = compression.Apply(s.cfg, req, resp, s.compressBuff)
+
+			if s.drain.Draining() || s.body.Expecting() {
+				// the server is shutting down, so the connection is closed right after
+				// the current response. Tell the client about it explicitly, so it won't
+				// try to reuse the connection.
+				// The same applies to a rejected upload: the client may or may not send
+				// the body anyway, so the next request can't be reliably told apart from it
+				_ = s.Write(version, resp.Header("Connection", "close"))
+				return false
+			}
+
 			if err = s.Write(version, resp); err != nil {
 				// if error happened during writing the response, it makes no sense to try
 				// to write anything again
-				s.router.OnError(req, status.ErrCloseConnection)
+				if isTimeout(err) {
+					err = status.ErrWriteTimeout
+				} else {
+					err = status.ErrCloseConnection
+				}
+
+				s.router.OnError(req, err)
 				return false
 			}
 
 			if err = req.Reset(); err != nil {
 				// abusing the fact that req.Clear() can fail only due to read error
-				s.router.OnError(req, status.ErrCloseConnection)
+				if err != status.ErrBodyReadTimeout {
+					err = status.ErrCloseConnection
+				}
+
+				s.router.OnError(req, err)
+				return false
+			}
+
+			idle = true
+			if !s.wait(conn) {
 				return false
 			}
 		case Error:
@@ -128,6 +232,91 @@ func (s *Suit) serve(once bool) (ok bool) {
 	}
 }
 
+// wait prepares the connection for waiting for the next request. False is returned, if
+// the connection must be closed instead, as the draining has already begun
+func (s *Suit) wait(conn net.Conn) bool {
+	s.setReadDeadline(s.idleTimeout)
+	return s.drain.Idle(conn)
+}
+
+// watch returns a channel, which is closed as soon as the draining begins or the client
+// disconnects. The latter is noticed only if the request body is already read, as otherwise
+// it would've been consumed. The returned function stops watching and must be called before
+// the connection is read again
+func (s *Suit) watch() (done <-chan struct{}, stop func()) {
+	ch, stopch := make(chan struct{}), make(chan struct{})
+	var (
+		once sync.Once
+		wg   sync.WaitGroup
+	)
+	leave := func() {
+		once.Do(func() {
+			close(ch)
+		})
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		select {
+		case <-s.drain.Done():
+			leave()
+		case <-stopch:
+		}
+	}()
+
+	conn := s.client.Conn()
+	watchConn := s.body.Exhausted()
+	if watchConn {
+		_ = conn.SetReadDeadline(time.Time{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			data, err := s.client.Read()
+			if err == nil {
+				// the client is alive and already sends the next request
+				s.client.Unread(data)
+				return
+			}
+
+			select {
+			case <-stopch:
+				// interrupted by stopping
+			default:
+				leave()
+			}
+		}()
+	}
+
+	return ch, func() {
+		close(stopch)
+		if watchConn {
+			_ = conn.SetReadDeadline(time.Now())
+		}
+
+		wg.Wait()
+	}
+}
+
+// setReadDeadline sets the read deadline of the connection relatively to the current
+// moment. Zero timeout disables the deadline
+func (s *Suit) setReadDeadline(timeout time.Duration) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	_ = s.client.Conn().SetReadDeadline(deadline)
+}
+
+// isTimeout tells whether the error is caused by an exceeded deadline
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // respond ensures the passed resp is not nil, otherwise http.Respond(req) is returned
 func respond(req *http.Request, resp *http.Response) *http.Response {
 	if resp != nil {
@@ -162,10 +162,10 @@ func newSuit(client transport.Client) (*Suit, *http.Request) {
 	// as in wildlife simple router will be barely used
 	cfg := config.Default()
 	r := getInbuiltRouter()
-	body := NewBody(client, construct.Chunked(cfg.Body), cfg.Body)
+	body := NewBody(client, construct.Chunked(cfg.Body), cfg)
 	req := construct.Request(cfg, client, body)
 
-	return Initialize(config.Default(), r, client, req, body), req
+	return Initialize(config.Default(), r, client, req, body, transport.NewDrain()), req
 }
 
 func disperse(data []byte, n int) (parts [][]byte) {
@@ -0,0 +1,760 @@
+package http2
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/indigo-web/indigo/config"
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/router"
+	"github.com/indigo-web/indigo/transport"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	// defaultWindowSize is the initial flow control window size, as defined by RFC 9113
+	defaultWindowSize = 65535
+	// defaultMaxFrameSize is the initial maximal frame size, as defined by RFC 9113
+	defaultMaxFrameSize = 16384
+	// defaultHeaderTableSize is the initial size of the HPACK dynamic table
+	defaultHeaderTableSize = 4096
+)
+
+var (
+	errConnClosed  = errors.New("connection is closed")
+	errStreamReset = errors.New("stream was reset")
+	errMalformed   = errors.New("malformed request")
+)
+
+type readResult struct {
+	frame http2.Frame
+	err   error
+}
+
+// Conn serves a single HTTP/2 connection. Each stream is served in its own goroutine,
+// while the frames are read and dispatched by the connection loop.
+type Conn struct {
+	cfg    *config.Config
+	conn   net.Conn
+	router router.Router
+	client transport.Client
+	env    http.Environment
+	drain  *transport.Drain
+
+	br      *bufio.Reader
+	framer  *http2.Framer
+	reads   chan readResult
+	gate    chan struct{}
+	done    chan *stream
+	streams map[uint32]*stream
+	// lastStream is the highest stream identifier, initiated by the client
+	lastStream uint32
+	goingAway  bool
+
+	// wmu serializes writing frames. As the header encoder is stateful, it's also guarded
+	// by it
+	wmu  sync.Mutex
+	bw   *bufio.Writer
+	henc *hpack.Encoder
+	hbuf []byte
+	werr error
+
+	// mu guards flow control windows and peer settings, which are accessed both by
+	// the connection loop and streams
+	mu                sync.Mutex
+	cond              *sync.Cond
+	closed            bool
+	sendWindow        int64
+	recvWindow        int64
+	peerInitialWindow int64
+	peerMaxFrame      uint32
+
+	// buffs are the spare buffers for compressed bodies. Streams are served concurrently,
+	// so each one takes its own and returns it back as soon as the response is written
+	buffsMu sync.Mutex
+	buffs   [][]byte
+}
+
+// New returns a new connection. The env is copied into each request, so it usually
+// contains encryption, TLS state and the PROXY protocol header of the connection.
+func New(
+	cfg *config.Config, conn net.Conn, r router.Router, env http.Environment, drain *transport.Drain,
+) *Conn {
+	c := &Conn{
+		cfg:               cfg,
+		conn:              conn,
+		router:            r,
+		client:            transport.NewClient(conn, cfg.NET.WriteTimeout, nil),
+		env:               env,
+		drain:             drain,
+		reads:             make(chan readResult, 1),
+		gate:              make(chan struct{}),
+		done:              make(chan *stream),
+		streams:           make(map[uint32]*stream),
+		bw:                bufio.NewWriterSize(conn, cfg.HTTP.ResponseBuffSize),
+		sendWindow:        defaultWindowSize,
+		recvWindow:        int64(cfg.HTTP2.ConnWindowSize),
+		peerInitialWindow: defaultWindowSize,
+		peerMaxFrame:      defaultMaxFrameSize,
+	}
+	c.cond = sync.NewCond(&c.mu)
+
+	c.br = bufio.NewReaderSize(conn, cfg.NET.ReadBufferSize)
+	c.framer = http2.NewFramer(c.bw, c.br)
+	c.framer.SetMaxReadFrameSize(defaultMaxFrameSize)
+	c.framer.MaxHeaderListSize = maxHeaderListSize(cfg)
+	c.framer.ReadMetaHeaders = hpack.NewDecoder(defaultHeaderTableSize, nil)
+	c.framer.ReadMetaHeaders.SetMaxStringLength(max(cfg.Headers.MaxKeyLength, cfg.Headers.MaxValueLength))
+	c.henc = hpack.NewEncoder(hbufWriter{c})
+
+	return c
+}
+
+// Serve serves the connection until it's closed by either side or the server is
+// shutting down. The connection is closed afterward.
+func (c *Conn) Serve() {
+	c.serveConn(nil)
+}
+
+// serveConn serves the connection, optionally starting with the upgraded request.
+func (c *Conn) serveConn(upgraded *stream) {
+	defer c.conn.Close()
+
+	if !c.handshake() {
+		return
+	}
+
+	if upgraded != nil {
+		c.lastStream = upgraded.id
+		c.streams[upgraded.id] = upgraded
+		go upgraded.serve(nil)
+	}
+
+	go c.readFrames()
+	c.serve()
+}
+
+// handshake reads the client preface and sends the server one. The client preface
+// is expected to arrive within the header read timeout.
+func (c *Conn) handshake() bool {
+	if timeout := c.cfg.NET.HeaderReadTimeout; timeout > 0 {
+		_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+
+	preface := make([]byte, len(http2.ClientPreface))
+	if _, err := io.ReadFull(c.br, preface); err != nil || string(preface) != http2.ClientPreface {
+		return false
+	}
+
+	_ = c.conn.SetReadDeadline(time.Time{})
+
+	err := c.write(func(fr *http2.Framer) error {
+		err := fr.WriteSettings(
+			http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: c.cfg.HTTP2.MaxConcurrentStreams},
+			http2.Setting{ID: http2.SettingInitialWindowSize, Val: c.cfg.HTTP2.StreamWindowSize},
+			http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: c.framer.MaxHeaderListSize},
+		)
+		if err != nil {
+			return err
+		}
+
+		if increment := c.cfg.HTTP2.ConnWindowSize - defaultWindowSize; increment > 0 {
+			return fr.WriteWindowUpdate(0, increment)
+		}
+
+		return nil
+	})
+
+	return err == nil
+}
+
+// readFrames reads frames and passes them to the connection loop. As the frame is valid
+// only until the next one is read, it waits until the loop is done with it.
+func (c *Conn) readFrames() {
+	for {
+		frame, err := c.framer.ReadFrame()
+		c.reads <- readResult{frame, err}
+		if err != nil && !isStreamError(err) {
+			return
+		}
+
+		if _, ok := <-c.gate; !ok {
+			return
+		}
+	}
+}
+
+func (c *Conn) serve() {
+	idle := time.NewTimer(c.idleTimeout())
+	defer idle.Stop()
+	drained := c.drain.Done()
+
+	settingsReceived := false
+	defer c.close()
+
+	for {
+		select {
+		case res := <-c.reads:
+			if res.err != nil {
+				c.onReadError(res.err)
+				if !isStreamError(res.err) {
+					return
+				}
+
+				c.gate <- struct{}{}
+				continue
+			}
+
+			if !settingsReceived {
+				// the client preface must be followed by the SETTINGS frame
+				if _, ok := res.frame.(*http2.SettingsFrame); !ok {
+					c.goAway(http2.ErrCodeProtocol)
+					return
+				}
+
+				settingsReceived = true
+			}
+
+			hadStreams := len(c.streams) > 0
+			if code, ok := c.handle(res.frame); !ok {
+				c.goAway(code)
+				return
+			}
+
+			if len(c.streams) == 0 && c.goingAway {
+				return
+			}
+
+			if !hadStreams && len(c.streams) > 0 {
+				idle.Stop()
+			}
+
+			c.gate <- struct{}{}
+		case s := <-c.done:
+			c.finish(s)
+			if len(c.streams) == 0 {
+				if c.goingAway {
+					return
+				}
+
+				idle.Reset(c.idleTimeout())
+			}
+		case <-idle.C:
+			if len(c.streams) == 0 {
+				c.goAway(http2.ErrCodeNo)
+				return
+			}
+		case <-drained:
+			// the channel stays closed, so it mustn't be selected anymore
+			drained = nil
+			if !c.goingAway {
+				// let the client know, which streams are going to be completed, so it
+				// can safely retry the rest
+				c.goingAway = true
+				c.goAway(http2.ErrCodeNo)
+				if len(c.streams) == 0 {
+					return
+				}
+			}
+		}
+	}
+}
+
+// handle processes the frame. If a connection error occurred, the error code is returned.
+func (c *Conn) handle(frame http2.Frame) (http2.ErrCode, bool) {
+	switch f := frame.(type) {
+	case *http2.MetaHeadersFrame:
+		return c.onHeaders(f)
+	case *http2.DataFrame:
+		return c.onData(f)
+	case *http2.SettingsFrame:
+		return c.onSettings(f)
+	case *http2.WindowUpdateFrame:
+		return c.onWindowUpdate(f)
+	case *http2.PingFrame:
+		if f.IsAck() {
+			return 0, true
+		}
+
+		_ = c.write(func(fr *http2.Framer) error {
+			return fr.WritePing(true, f.Data)
+		})
+	case *http2.RSTStreamFrame:
+		if s, found := c.streams[f.StreamID]; found {
+			s.remoteClosed = true
+			s.cancel(errStreamReset)
+		} else if f.StreamID > c.lastStream {
+			// resetting an idle stream
+			return http2.ErrCodeProtocol, false
+		}
+	case *http2.GoAwayFrame:
+		c.goingAway = true
+	case *http2.PushPromiseFrame:
+		// clients must not push
+		return http2.ErrCodeProtocol, false
+	case *http2.PriorityFrame, *http2.UnknownFrame:
+		// prioritization is deprecated by RFC 9113, so it's ignored as well as unknown
+		// frame types
+	}
+
+	return 0, true
+}
+
+func (c *Conn) onHeaders(f *http2.MetaHeadersFrame) (http2.ErrCode, bool) {
+	id := f.StreamID
+	if id%2 == 0 {
+		return http2.ErrCodeProtocol, false
+	}
+
+	if s, found := c.streams[id]; found {
+		// trailers. They are ignored, as the request is already being processed
+		if s.remoteClosed {
+			c.resetStream(s.id, http2.ErrCodeStreamClosed)
+			return 0, true
+		}
+
+		if !f.StreamEnded() {
+			return http2.ErrCodeProtocol, false
+		}
+
+		c.endStream(s)
+		return 0, true
+	}
+
+	if id <= c.lastStream {
+		c.resetStream(id, http2.ErrCodeStreamClosed)
+		return 0, true
+	}
+
+	c.lastStream = id
+
+	if c.goingAway || uint32(len(c.streams)) >= c.cfg.HTTP2.MaxConcurrentStreams {
+		c.resetStream(id, http2.ErrCodeRefusedStream)
+		return 0, true
+	}
+
+	s := newStream(c, id)
+	err := s.parse(f)
+	if f.StreamEnded() && s.declared > 0 {
+		// the declared body is never going to be sent
+		err = errMalformed
+	}
+
+	if err == errMalformed {
+		c.resetStream(id, http2.ErrCodeProtocol)
+		return 0, true
+	}
+
+	if f.StreamEnded() {
+		s.remoteClosed = true
+		s.body.end(io.EOF)
+	}
+
+	c.streams[id] = s
+	go s.serve(err)
+
+	return 0, true
+}
+
+func (c *Conn) onData(f *http2.DataFrame) (http2.ErrCode, bool) {
+	length := int64(f.Length)
+
+	c.mu.Lock()
+	if length > c.recvWindow {
+		c.mu.Unlock()
+		return http2.ErrCodeFlowControl, false
+	}
+	c.recvWindow -= length
+	c.mu.Unlock()
+
+	s, found := c.streams[f.StreamID]
+	if !found || s.remoteClosed {
+		// the stream is either already completed or reset, so the data is dropped. The
+		// connection window must be anyway refunded
+		c.refund(nil, length)
+		if !found && f.StreamID > c.lastStream {
+			return http2.ErrCodeProtocol, false
+		}
+
+		if found {
+			c.resetStream(s.id, http2.ErrCodeStreamClosed)
+		}
+
+		return 0, true
+	}
+
+	c.mu.Lock()
+	if length > s.recvWindow {
+		c.mu.Unlock()
+		c.refund(nil, length)
+		c.resetStream(s.id, http2.ErrCodeFlowControl)
+		s.remoteClosed = true
+		s.cancel(errStreamReset)
+		return 0, true
+	}
+	s.recvWindow -= length
+	c.mu.Unlock()
+
+	data := f.Data()
+	if padding := length - int64(len(data)); padding > 0 {
+		c.refund(s, padding)
+	}
+
+	s.received += int64(len(data))
+	if s.declared >= 0 && s.received > s.declared {
+		c.refund(nil, int64(len(data)))
+		c.malformed(s)
+		return 0, true
+	}
+
+	if len(data) > 0 && !s.body.push(data) {
+		// the body is too large or isn't read anymore
+		c.refund(nil, int64(len(data)))
+	}
+
+	if f.StreamEnded() {
+		c.endStream(s)
+	}
+
+	return 0, true
+}
+
+// endStream completes the request body. As defined by RFC 9113 §8.1.1, the request is
+// malformed, if its length doesn't match the declared content-length.
+func (c *Conn) endStream(s *stream) {
+	if s.declared >= 0 && s.received != s.declared {
+		c.malformed(s)
+		return
+	}
+
+	s.remoteClosed = true
+	s.body.end(io.EOF)
+}
+
+// malformed resets the stream of the malformed request.
+func (c *Conn) malformed(s *stream) {
+	s.remoteClosed = true
+	c.resetStream(s.id, http2.ErrCodeProtocol)
+	s.cancel(errStreamReset)
+}
+
+func (c *Conn) onSettings(f *http2.SettingsFrame) (http2.ErrCode, bool) {
+	if f.IsAck() {
+		return 0, true
+	}
+
+	if err := f.ForeachSetting(c.applySetting); err != nil {
+		return errCode(err), false
+	}
+
+	_ = c.write(func(fr *http2.Framer) error {
+		return fr.WriteSettingsAck()
+	})
+
+	return 0, true
+}
+
+func (c *Conn) applySetting(setting http2.Setting) error {
+	if err := setting.Valid(); err != nil {
+		return err
+	}
+
+	switch setting.ID {
+	case http2.SettingInitialWindowSize:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		delta := int64(setting.Val) - c.peerInitialWindow
+		c.peerInitialWindow = int64(setting.Val)
+		for _, s := range c.streams {
+			s.sendWindow += delta
+			if s.sendWindow > math.MaxInt32 {
+				return http2.ConnectionError(http2.ErrCodeFlowControl)
+			}
+		}
+
+		c.cond.Broadcast()
+	case http2.SettingMaxFrameSize:
+		c.mu.Lock()
+		c.peerMaxFrame = setting.Val
+		c.mu.Unlock()
+	case http2.SettingHeaderTableSize:
+		c.wmu.Lock()
+		c.henc.SetMaxDynamicTableSizeLimit(setting.Val)
+		c.wmu.Unlock()
+	}
+
+	return nil
+}
+
+func (c *Conn) onWindowUpdate(f *http2.WindowUpdateFrame) (http2.ErrCode, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f.StreamID == 0 {
+		c.sendWindow += int64(f.Increment)
+		if c.sendWindow > math.MaxInt32 {
+			return http2.ErrCodeFlowControl, false
+		}
+
+		c.cond.Broadcast()
+		return 0, true
+	}
+
+	s, found := c.streams[f.StreamID]
+	if !found {
+		if f.StreamID > c.lastStream {
+			return http2.ErrCodeProtocol, false
+		}
+
+		return 0, true
+	}
+
+	s.sendWindow += int64(f.Increment)
+	if s.sendWindow > math.MaxInt32 {
+		s.reset = true
+		go c.resetStream(s.id, http2.ErrCodeFlowControl)
+	}
+
+	c.cond.Broadcast()
+	return 0, true
+}
+
+func (c *Conn) onReadError(err error) {
+	var streamErr http2.StreamError
+	if errors.As(err, &streamErr) {
+		if streamErr.StreamID > c.lastStream {
+			c.lastStream = streamErr.StreamID
+		}
+
+		if s, found := c.streams[streamErr.StreamID]; found {
+			s.remoteClosed = true
+			s.cancel(errStreamReset)
+		}
+
+		c.resetStream(streamErr.StreamID, streamErr.Code)
+		return
+	}
+
+	var connErr http2.ConnectionError
+	if errors.As(err, &connErr) {
+		c.goAway(http2.ErrCode(connErr))
+		return
+	}
+
+	if errors.Is(err, http2.ErrFrameTooLarge) {
+		c.goAway(http2.ErrCodeFrameSize)
+	}
+}
+
+// finish releases the completed stream. If the request wasn't received completely, the
+// client is told to stop sending it.
+func (c *Conn) finish(s *stream) {
+	delete(c.streams, s.id)
+	if !s.remoteClosed {
+		c.resetStream(s.id, http2.ErrCodeNo)
+	}
+
+	if unread := s.body.drop(); unread > 0 {
+		c.refund(nil, unread)
+	}
+}
+
+// takeBuff returns a spare buffer for the compressed body, if there's any
+func (c *Conn) takeBuff() []byte {
+	c.buffsMu.Lock()
+	defer c.buffsMu.Unlock()
+
+	if len(c.buffs) == 0 {
+		return nil
+	}
+
+	buff := c.buffs[len(c.buffs)-1]
+	c.buffs = c.buffs[:len(c.buffs)-1]
+
+	return buff
+}
+
+// releaseBuff returns the buffer back, so it can be reused by the next streams
+func (c *Conn) releaseBuff(buff []byte) {
+	if cap(buff) == 0 {
+		return
+	}
+
+	c.buffsMu.Lock()
+	c.buffs = append(c.buffs, buff[:0])
+	c.buffsMu.Unlock()
+}
+
+// close fails all the pending streams and waits until they are done.
+func (c *Conn) close() {
+	c.mu.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	for _, s := range c.streams {
+		s.cancel(errConnClosed)
+	}
+
+	// unblock the frames reader, if it's waiting for the gate
+	close(c.gate)
+
+	for len(c.streams) > 0 {
+		s := <-c.done
+		delete(c.streams, s.id)
+	}
+}
+
+// refund returns consumed bytes to the flow control windows of the connection and
+// the stream, if non-nil, letting the client send more data.
+func (c *Conn) refund(s *stream, n int64) {
+	if n <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	c.recvWindow += n
+	if s != nil {
+		s.recvWindow += n
+	}
+	c.mu.Unlock()
+
+	_ = c.write(func(fr *http2.Framer) error {
+		if err := fr.WriteWindowUpdate(0, uint32(n)); err != nil {
+			return err
+		}
+
+		if s == nil {
+			return nil
+		}
+
+		return fr.WriteWindowUpdate(s.id, uint32(n))
+	})
+}
+
+// reserve waits until at least a single byte may be sent on the stream and returns how
+// many bytes at most may be sent at once.
+func (c *Conn) reserve(s *stream, want int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		switch {
+		case c.closed:
+			return 0, errConnClosed
+		case s.reset:
+			return 0, errStreamReset
+		}
+
+		n := min(int64(want), c.sendWindow, s.sendWindow, int64(c.peerMaxFrame))
+		if n > 0 {
+			c.sendWindow -= n
+			s.sendWindow -= n
+			return int(n), nil
+		}
+
+		c.cond.Wait()
+	}
+}
+
+func (c *Conn) maxFrameSize() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return int(c.peerMaxFrame)
+}
+
+func (c *Conn) resetStream(id uint32, code http2.ErrCode) {
+	_ = c.write(func(fr *http2.Framer) error {
+		return fr.WriteRSTStream(id, code)
+	})
+}
+
+func (c *Conn) goAway(code http2.ErrCode) {
+	_ = c.write(func(fr *http2.Framer) error {
+		return fr.WriteGoAway(c.lastStream, code, nil)
+	})
+}
+
+// write runs the callback with exclusive access to the framer and flushes the written
+// frames afterward. Once a write failed, all the following ones fail immediately.
+func (c *Conn) write(cb func(fr *http2.Framer) error) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.werr != nil {
+		return c.werr
+	}
+
+	if timeout := c.cfg.NET.WriteTimeout; timeout > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+
+	err := cb(c.framer)
+	if err == nil {
+		err = c.bw.Flush()
+	}
+
+	if err != nil {
+		c.werr = err
+		// unblock everyone waiting on flow control, as nothing can be sent anymore
+		c.mu.Lock()
+		c.closed = true
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	}
+
+	return err
+}
+
+func (c *Conn) idleTimeout() time.Duration {
+	if c.cfg.NET.IdleTimeout > 0 {
+		return c.cfg.NET.IdleTimeout
+	}
+
+	// effectively never fires
+	return math.MaxInt64
+}
+
+// hbufWriter accumulates the encoded header block. It must be used only while holding
+// the write lock
+type hbufWriter struct {
+	c *Conn
+}
+
+func (w hbufWriter) Write(b []byte) (int, error) {
+	w.c.hbuf = append(w.c.hbuf, b...)
+	return len(b), nil
+}
+
+func maxHeaderListSize(cfg *config.Config) uint32 {
+	// every header field additionally costs 32 bytes, as defined by RFC 7541
+	size := int64(cfg.Headers.KeySpace.Maximal) + int64(cfg.Headers.ValueSpace.Maximal) +
+		32*int64(cfg.Headers.Number.Maximal)
+	if size <= 0 || size > math.MaxUint32 {
+		return math.MaxUint32
+	}
+
+	return uint32(size)
+}
+
+func isStreamError(err error) bool {
+	var streamErr http2.StreamError
+	return errors.As(err, &streamErr)
+}
+
+func errCode(err error) http2.ErrCode {
+	var connErr http2.ConnectionError
+	if errors.As(err, &connErr) {
+		return http2.ErrCode(connErr)
+	}
+
+	return http2.ErrCodeProtocol
+}
@@ -0,0 +1,208 @@
+package http2
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/indigo-web/indigo/config"
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/transport"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+type echoRouter struct{}
+
+func (echoRouter) OnRequest(request *http.Request) *http.Response {
+	body, err := request.Body.Bytes()
+	if err != nil {
+		return http.Error(request, err)
+	}
+
+	return http.Bytes(request, body).Header("X-Path", request.Path)
+}
+
+func (echoRouter) OnError(request *http.Request, err error) *http.Response {
+	return http.Error(request, err)
+}
+
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	framer *http2.Framer
+	henc   *hpack.Encoder
+	hbuf   bytes.Buffer
+	// writes are done sequentially in a separate goroutine, as the pipe is synchronous
+	writes chan func()
+}
+
+func newTestClient(t *testing.T, cfg *config.Config, drain *transport.Drain) (*testClient, <-chan struct{}) {
+	server, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		New(cfg, server, echoRouter{}, http.Environment{}, drain).Serve()
+		close(done)
+	}()
+
+	c := &testClient{t: t, conn: conn, writes: make(chan func(), 16)}
+	c.framer = http2.NewFramer(conn, conn)
+	c.framer.ReadMetaHeaders = hpack.NewDecoder(defaultHeaderTableSize, nil)
+	c.henc = hpack.NewEncoder(&c.hbuf)
+
+	go func() {
+		for write := range c.writes {
+			write()
+		}
+	}()
+
+	c.write(func() {
+		_, _ = conn.Write([]byte(http2.ClientPreface))
+		_ = c.framer.WriteSettings()
+	})
+
+	return c, done
+}
+
+func (c *testClient) write(fn func()) {
+	c.writes <- fn
+}
+
+func (c *testClient) close() {
+	close(c.writes)
+	require.NoError(c.t, c.conn.Close())
+}
+
+func (c *testClient) request(id uint32, method, path string, endStream bool, headers ...[2]string) {
+	c.hbuf.Reset()
+	fields := [][2]string{{":method", method}, {":scheme", "https"}, {":path", path}, {":authority", "localhost"}}
+	for _, field := range append(fields, headers...) {
+		require.NoError(c.t, c.henc.WriteField(hpack.HeaderField{Name: field[0], Value: field[1]}))
+	}
+
+	block := bytes.Clone(c.hbuf.Bytes())
+	c.write(func() {
+		_ = c.framer.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      id,
+			BlockFragment: block,
+			EndStream:     endStream,
+			EndHeaders:    true,
+		})
+	})
+}
+
+// next returns the next frame of the stream, skipping connection-level ones
+func (c *testClient) next(id uint32) http2.Frame {
+	for {
+		require.NoError(c.t, c.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		frame, err := c.framer.ReadFrame()
+		require.NoError(c.t, err)
+
+		if frame.Header().StreamID == id {
+			return frame
+		}
+
+		if settings, ok := frame.(*http2.SettingsFrame); ok && !settings.IsAck() {
+			c.write(func() {
+				_ = c.framer.WriteSettingsAck()
+			})
+		}
+	}
+}
+
+func TestConn(t *testing.T) {
+	t.Run("request with body", func(t *testing.T) {
+		c, done := newTestClient(t, config.Default(), transport.NewDrain())
+		c.request(1, "POST", "/hello%20world?a=b", false)
+		c.write(func() {
+			_ = c.framer.WriteData(1, true, []byte("Hello, world!"))
+		})
+
+		headers := c.next(1).(*http2.MetaHeadersFrame)
+		require.Equal(t, "200", headers.PseudoValue("status"))
+		require.False(t, headers.StreamEnded())
+		fields := make(map[string]string)
+		for _, field := range headers.RegularFields() {
+			fields[field.Name] = field.Value
+		}
+		require.Equal(t, "/hello world", fields["x-path"])
+		require.Equal(t, "13", fields["content-length"])
+
+		data := c.next(1).(*http2.DataFrame)
+		require.Equal(t, "Hello, world!", string(data.Data()))
+		require.True(t, data.StreamEnded())
+
+		c.close()
+		<-done
+	})
+
+	t.Run("malformed request", func(t *testing.T) {
+		c, done := newTestClient(t, config.Default(), transport.NewDrain())
+		c.request(1, "GET", "", true)
+
+		rst := c.next(1).(*http2.RSTStreamFrame)
+		require.Equal(t, http2.ErrCodeProtocol, rst.ErrCode)
+
+		c.close()
+		<-done
+	})
+
+	t.Run("content length mismatch", func(t *testing.T) {
+		for _, tc := range []struct {
+			name, length, body string
+		}{
+			{"exceeded", "5", "Hello, world!"},
+			{"incomplete", "20", "Hello, world!"},
+			{"no body", "5", ""},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				c, done := newTestClient(t, config.Default(), transport.NewDrain())
+				c.request(1, "POST", "/", len(tc.body) == 0, [2]string{"content-length", tc.length})
+				if len(tc.body) > 0 {
+					c.write(func() {
+						_ = c.framer.WriteData(1, true, []byte(tc.body))
+					})
+				}
+
+				rst := c.next(1).(*http2.RSTStreamFrame)
+				require.Equal(t, http2.ErrCodeProtocol, rst.ErrCode)
+
+				c.close()
+				<-done
+			})
+		}
+	})
+
+	t.Run("refused stream", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.HTTP2.MaxConcurrentStreams = 1
+		c, done := newTestClient(t, cfg, transport.NewDrain())
+		// the first stream is kept open, as the request body isn't completed
+		c.request(1, "POST", "/", false)
+		c.request(3, "GET", "/", true)
+
+		rst := c.next(3).(*http2.RSTStreamFrame)
+		require.Equal(t, http2.ErrCodeRefusedStream, rst.ErrCode)
+
+		c.close()
+		<-done
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		drain := transport.NewDrain()
+		c, done := newTestClient(t, config.Default(), drain)
+		drain.Start()
+
+		for {
+			if goAway, ok := c.next(0).(*http2.GoAwayFrame); ok {
+				require.Equal(t, http2.ErrCodeNo, goAway.ErrCode)
+				break
+			}
+		}
+
+		<-done
+		c.close()
+	})
+}
@@ -0,0 +1,648 @@
+package http2
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/http/cookie"
+	"github.com/indigo-web/indigo/http/method"
+	"github.com/indigo-web/indigo/http/proto"
+	"github.com/indigo-web/indigo/http/status"
+	"github.com/indigo-web/indigo/internal/compression"
+	"github.com/indigo-web/indigo/internal/conditional"
+	"github.com/indigo-web/indigo/internal/construct"
+	"github.com/indigo-web/indigo/internal/ranges"
+	"github.com/indigo-web/indigo/internal/response"
+	"github.com/indigo-web/indigo/internal/types"
+	"github.com/indigo-web/indigo/internal/urlencoded"
+	"github.com/indigo-web/utils/strcomp"
+	"github.com/indigo-web/utils/uf"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+type stream struct {
+	conn    *Conn
+	id      uint32
+	request *http.Request
+	body    *body
+	// sendWindow, recvWindow and reset are guarded by Conn.mu
+	sendWindow, recvWindow int64
+	reset                  bool
+	// gone is closed as soon as the stream is reset or the connection is closed
+	gone     chan struct{}
+	goneOnce sync.Once
+	// remoteClosed tells whether the client is done with the stream. It's accessed
+	// only by the connection loop, as well as declared and received. The declared length
+	// is -1, if the content-length header is absent
+	remoteClosed       bool
+	declared, received int64
+}
+
+func newStream(c *Conn, id uint32) *stream {
+	c.mu.Lock()
+	sendWindow := c.peerInitialWindow
+	c.mu.Unlock()
+
+	s := &stream{
+		conn:       c,
+		id:         id,
+		sendWindow: sendWindow,
+		recvWindow: int64(c.cfg.HTTP2.StreamWindowSize),
+		declared:   -1,
+		gone:       make(chan struct{}),
+	}
+	s.body = newBody(s)
+	s.request = construct.Request(c.cfg, c.client, s.body)
+	s.request.Proto = proto.HTTP2
+	s.request.Env = c.env
+	s.request.SetSerializer(s)
+
+	return s
+}
+
+// parse fills the request from the headers. Returns errMalformed, if the stream must
+// be reset, or an HTTP error, which must be responded with.
+func (s *stream) parse(f *http2.MetaHeadersFrame) error {
+	if f.Truncated {
+		return status.ErrHeaderFieldsTooLarge
+	}
+
+	req := s.request
+	cfg := s.conn.cfg
+	var path, authority string
+
+	for _, field := range f.Fields {
+		if field.IsPseudo() {
+			switch field.Name {
+			case ":method":
+				req.Method = method.Parse(field.Value)
+			case ":path":
+				path = field.Value
+			case ":authority":
+				authority = field.Value
+			}
+
+			continue
+		}
+
+		switch field.Name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			// connection-specific headers are prohibited in HTTP/2
+			return errMalformed
+		case "te":
+			if field.Value != "trailers" {
+				return errMalformed
+			}
+		case "content-length":
+			length, err := strconv.Atoi(field.Value)
+			if err != nil || length < 0 {
+				return errMalformed
+			}
+
+			req.ContentLength = length
+			s.declared = int64(length)
+		case "content-type":
+			req.ContentType = field.Value
+		case "content-encoding":
+			req.Encoding.Content = parseTokens(req.Encoding.Content, field.Value)
+		}
+
+		if req.Headers.Len() >= cfg.Headers.Number.Maximal {
+			return status.ErrTooManyHeaders
+		}
+
+		req.Headers.Add(field.Name, field.Value)
+	}
+
+	if len(authority) > 0 && !req.Headers.Has("host") {
+		req.Headers.Add("host", authority)
+	}
+
+	switch {
+	case req.Method == method.CONNECT:
+		return status.ErrMethodNotImplemented
+	case len(path) == 0:
+		return errMalformed
+	case req.Method == method.Unknown:
+		return status.ErrMethodNotImplemented
+	}
+
+	if query := strings.IndexByte(path, '?'); query != -1 {
+		req.Query.Update([]byte(path[query+1:]))
+		path = path[:query]
+	}
+
+	decoded, err := urlencoded.Decode([]byte(path))
+	if err != nil {
+		return err
+	}
+
+	if len(decoded) == 0 {
+		return status.ErrBadRequest
+	}
+
+	req.Path = uf.B2S(decoded)
+
+	if uint(req.ContentLength) > cfg.Body.MaxSize {
+		s.body.end(status.ErrBodyTooLarge)
+	}
+
+	return nil
+}
+
+// serve processes the request and writes the response. If err is not nil, the error
+// response is written instead.
+func (s *stream) serve(err error) {
+	defer func() {
+		s.conn.done <- s
+	}()
+
+	c := s.conn
+	req := s.request
+
+	var resp *http.Response
+	if err != nil {
+		resp = c.router.OnError(req, err)
+	} else {
+		resp = c.router.OnRequest(req)
+	}
+
+	if resp == nil {
+		resp = http.Respond(req)
+	}
+
+	if err = s.write(resp); err != nil && err != errStreamReset {
+		if isTimeout(err) {
+			err = status.ErrWriteTimeout
+		} else {
+			err = status.ErrCloseConnection
+		}
+
+		c.router.OnError(req, err)
+	}
+}
+
+// cancel stops the stream from both sides: the body isn't received and the response
+// isn't sent anymore.
+func (s *stream) cancel(err error) {
+	c := s.conn
+	c.mu.Lock()
+	s.reset = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	s.goneOnce.Do(func() {
+		close(s.gone)
+	})
+	s.body.end(err)
+}
+
+func (s *stream) isReset() bool {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+
+	return s.reset
+}
+
+// Write implements http.Serializer. The protocol is always HTTP/2, so it's ignored
+func (s *stream) Write(_ proto.Proto, resp *http.Response) error {
+	return s.write(resp)
+}
+
+// Inform implements http.Serializer. Informational responses are sent as separate
+// header blocks, which don't end the stream
+func (s *stream) Inform(resp *http.Response) error {
+	return s.writeHeaders(resp.Reveal(), 0, false)
+}
+
+func (s *stream) write(resp *http.Response) error {
+	conditional.Apply(s.conn.cfg, s.request, resp)
+	ranges.Apply(s.request, resp)
+	buff := compression.Apply(s.conn.cfg, s.request, resp, s.conn.takeBuff())
+	// the body refers to the buffer until it's written
+	defer s.conn.releaseBuff(buff)
+	fields := resp.Reveal()
+	attachment := fields.Attachment.Content()
+	if attachment != nil {
+		defer fields.Attachment.Close()
+	}
+
+	length := len(fields.Body)
+	switch {
+	case fields.Code == status.NotModified:
+		// the length of the representation isn't known here, so it's better to omit it
+		length = -1
+	case fields.Stream != nil:
+		length = -1
+	case attachment != nil:
+		length = fields.Attachment.Size()
+	}
+
+	noBody := s.request.Method == method.HEAD ||
+		(fields.Stream == nil && attachment == nil && len(fields.Body) == 0) ||
+		fields.Code == status.NoContent || fields.Code == status.NotModified
+
+	if err := s.writeHeaders(fields, length, noBody); err != nil || noBody {
+		return err
+	}
+
+	if fields.Stream != nil {
+		return s.writeStream(fields.Stream)
+	}
+
+	if attachment != nil {
+		return s.writeReader(attachment)
+	}
+
+	return s.writeData(fields.Body, true)
+}
+
+// writeHeaders encodes and writes the response headers. Length is rendered as the
+// Content-Length, unless it's negative. The header block is split into CONTINUATION
+// frames, if it doesn't fit the frame size.
+func (s *stream) writeHeaders(fields *response.Fields, length int, endStream bool) error {
+	if s.isReset() {
+		return errStreamReset
+	}
+
+	c := s.conn
+	maxFrame := c.maxFrameSize()
+
+	return c.write(func(fr *http2.Framer) error {
+		c.hbuf = c.hbuf[:0]
+		c.encode(":status", strconv.Itoa(int(fields.Code)))
+
+		for _, header := range fields.Headers {
+			if isConnectionSpecific(header.Key) {
+				continue
+			}
+
+			c.encode(strings.ToLower(header.Key), header.Value)
+		}
+
+		if fields.Code >= 200 {
+			// informational responses have no content, so there's nothing to describe. Default
+			// headers are meant for the final response only
+		defaults:
+			for key, value := range c.cfg.Headers.Default {
+				for _, header := range fields.Headers {
+					if strcomp.EqualFold(header.Key, key) {
+						continue defaults
+					}
+				}
+
+				c.encode(strings.ToLower(key), value)
+			}
+
+			if len(fields.ContentType) > 0 {
+				c.encode("content-type", fields.ContentType)
+			}
+
+			for _, cc := range fields.Cookies {
+				c.encode("set-cookie", string(cookie.Render(nil, cc)))
+			}
+
+			if length > 0 || (length == 0 && fields.Attachment.Content() == nil) {
+				c.encode("content-length", strconv.Itoa(length))
+			}
+		}
+
+		block := c.hbuf
+		for first := true; first || len(block) > 0; first = false {
+			fragment := block[:min(len(block), maxFrame)]
+			block = block[len(fragment):]
+
+			var err error
+			if first {
+				err = fr.WriteHeaders(http2.HeadersFrameParam{
+					StreamID:      s.id,
+					BlockFragment: fragment,
+					EndStream:     endStream,
+					EndHeaders:    len(block) == 0,
+				})
+			} else {
+				err = fr.WriteContinuation(s.id, len(block) == 0, fragment)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// writeData writes the data, respecting the flow control. If end is set, the stream is
+// closed after the last frame.
+func (s *stream) writeData(data []byte, end bool) error {
+	c := s.conn
+
+	if len(data) == 0 {
+		if !end {
+			return nil
+		}
+
+		if s.isReset() {
+			return errStreamReset
+		}
+
+		return c.write(func(fr *http2.Framer) error {
+			return fr.WriteData(s.id, true, nil)
+		})
+	}
+
+	for len(data) > 0 {
+		n, err := c.reserve(s, len(data))
+		if err != nil {
+			return err
+		}
+
+		chunk := data[:n]
+		data = data[n:]
+		last := end && len(data) == 0
+
+		err = c.write(func(fr *http2.Framer) error {
+			return fr.WriteData(s.id, last, chunk)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *stream) writeReader(r io.Reader) error {
+	buff := make([]byte, s.conn.cfg.HTTP.FileBuffSize)
+
+	for {
+		n, err := r.Read(buff)
+		if n > 0 {
+			if werr := s.writeData(buff[:n], false); werr != nil {
+				return werr
+			}
+		}
+
+		switch err {
+		case nil:
+		case io.EOF:
+			return s.writeData(nil, true)
+		default:
+			// the response is already partially sent, so the only way to tell the client
+			// it's broken is to reset the stream
+			s.cancel(errStreamReset)
+			s.conn.resetStream(s.id, http2.ErrCodeInternal)
+			return errStreamReset
+		}
+	}
+}
+
+// writeStream lets the stream function produce the response body. Every flush is sent
+// as DATA frames, and the stream is reset if the function fails.
+func (s *stream) writeStream(fn types.Stream) error {
+	done, stop := s.watch()
+	defer stop()
+
+	w := &streamWriter{
+		stream: s,
+		buff:   make([]byte, 0, s.conn.cfg.HTTP.FileBuffSize),
+		done:   done,
+	}
+
+	if err := fn(w); err != nil {
+		if w.err != nil {
+			return w.err
+		}
+
+		s.cancel(errStreamReset)
+		s.conn.resetStream(s.id, http2.ErrCodeInternal)
+		return errStreamReset
+	}
+
+	if w.err != nil {
+		return w.err
+	}
+
+	return s.writeData(w.buff, true)
+}
+
+// watch returns a channel, which is closed as soon as the stream is gone or the draining
+// begins. The returned function stops watching
+func (s *stream) watch() (done <-chan struct{}, stop func()) {
+	ch, stopch := make(chan struct{}), make(chan struct{})
+	go func() {
+		select {
+		case <-s.gone:
+		case <-s.conn.drain.Done():
+		case <-stopch:
+			return
+		}
+
+		close(ch)
+	}()
+
+	return ch, func() {
+		close(stopch)
+	}
+}
+
+// streamWriter buffers the data written by the stream function until it's flushed
+type streamWriter struct {
+	stream *stream
+	buff   []byte
+	err    error
+	done   <-chan struct{}
+}
+
+func (w *streamWriter) Write(b []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	for len(b) > 0 {
+		if len(w.buff) == cap(w.buff) {
+			if err = w.Flush(); err != nil {
+				return n, err
+			}
+		}
+
+		copied := min(len(b), cap(w.buff)-len(w.buff))
+		w.buff = append(w.buff, b[:copied]...)
+		n += copied
+		b = b[copied:]
+	}
+
+	return n, nil
+}
+
+func (w *streamWriter) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	w.err = w.stream.writeData(w.buff, false)
+	w.buff = w.buff[:0]
+
+	return w.err
+}
+
+func (w *streamWriter) Done() <-chan struct{} {
+	return w.done
+}
+
+// encode appends the header field to the header block. Must be called only while
+// holding the write lock.
+func (c *Conn) encode(key, value string) {
+	_ = c.henc.WriteField(hpack.HeaderField{Name: key, Value: value})
+}
+
+// body is the request body of a stream. DATA frames are pushed into it by the connection
+// loop and retrieved by the handler.
+type body struct {
+	stream   *stream
+	mu       sync.Mutex
+	chunks   [][]byte
+	queued   int64
+	received uint
+	err      error
+	notify   chan struct{}
+}
+
+func newBody(s *stream) *body {
+	return &body{
+		stream: s,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Retrieve returns the next piece of the body. The flow control window is refunded
+// as soon as the data is handed to the consumer.
+func (b *body) Retrieve() ([]byte, error) {
+	var timeout <-chan time.Time
+
+	for {
+		b.mu.Lock()
+		if len(b.chunks) > 0 {
+			chunk := b.chunks[0]
+			b.chunks[0] = nil
+			b.chunks = b.chunks[1:]
+			b.queued -= int64(len(chunk))
+			// once the client is done with the stream, there's no need to extend its window
+			s := b.stream
+			if b.err != nil {
+				s = nil
+			}
+			b.mu.Unlock()
+
+			b.stream.conn.refund(s, int64(len(chunk)))
+			return chunk, nil
+		}
+
+		err := b.err
+		b.mu.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if timeout == nil {
+			if bodyTimeout := b.stream.conn.cfg.NET.BodyReadTimeout; bodyTimeout > 0 {
+				timer := time.NewTimer(bodyTimeout)
+				defer timer.Stop()
+				timeout = timer.C
+			}
+		}
+
+		select {
+		case <-b.notify:
+		case <-timeout:
+			return nil, status.ErrBodyReadTimeout
+		}
+	}
+}
+
+// push enqueues a copy of the data. Returns false, if the data was dropped.
+func (b *body) push(data []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return false
+	}
+
+	b.received += uint(len(data))
+	if b.received > b.stream.conn.cfg.Body.MaxSize {
+		b.err = status.ErrBodyTooLarge
+		b.wake()
+		return false
+	}
+
+	b.chunks = append(b.chunks, append([]byte(nil), data...))
+	b.queued += int64(len(data))
+	b.wake()
+
+	return true
+}
+
+// end marks the body as completed with the error, which is io.EOF in case of success.
+// The data, which is already enqueued, is still retrievable.
+func (b *body) end(err error) {
+	b.mu.Lock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.wake()
+	b.mu.Unlock()
+}
+
+// drop discards enqueued data and returns its size.
+func (b *body) drop() (n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n = b.queued
+	b.chunks, b.queued = nil, 0
+	if b.err == nil {
+		b.err = errStreamReset
+	}
+
+	return n
+}
+
+func (b *body) wake() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+func isConnectionSpecific(key string) bool {
+	return strcomp.EqualFold(key, "connection") ||
+		strcomp.EqualFold(key, "keep-alive") ||
+		strcomp.EqualFold(key, "proxy-connection") ||
+		strcomp.EqualFold(key, "transfer-encoding") ||
+		strcomp.EqualFold(key, "upgrade")
+}
+
+func parseTokens(buff []string, value string) []string {
+	for _, token := range strings.Split(value, ",") {
+		if token = strings.TrimSpace(token); len(token) > 0 {
+			buff = append(buff, token)
+		}
+	}
+
+	return buff
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,99 @@
+package http2
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/utils/strcomp"
+	"golang.org/x/net/http2"
+)
+
+// Preface is the connection preface, which is sent by clients with prior knowledge
+// of HTTP/2 support.
+const Preface = http2.ClientPreface
+
+var errBadSettings = errors.New("malformed HTTP2-Settings header")
+
+// ServePriorKnowledge serves the cleartext connection, whose first bytes were already
+// read while looking for the connection preface. The pending data must start with it.
+func (c *Conn) ServePriorKnowledge(pending []byte) {
+	c.prepend(pending)
+	c.serveConn(nil)
+}
+
+// ServeUpgrade serves the connection, which was upgraded from HTTP/1.1 via the h2c
+// upgrade. The upgrade request is served as the stream 1 with the already read body,
+// settings are the decoded HTTP2-Settings header value. Pending is the data read from
+// the connection after the request.
+func (c *Conn) ServeUpgrade(req *http.Request, body []byte, settings []http2.Setting, pending []byte) {
+	c.prepend(pending)
+
+	for _, setting := range settings {
+		if err := c.applySetting(setting); err != nil {
+			_ = c.conn.Close()
+			return
+		}
+	}
+
+	s := newStream(c, 1)
+	s.adopt(req)
+	if len(body) > 0 {
+		s.body.push(body)
+	}
+
+	s.remoteClosed = true
+	s.body.end(io.EOF)
+
+	c.serveConn(s)
+}
+
+// prepend makes the data to be read before anything else from the connection.
+func (c *Conn) prepend(pending []byte) {
+	if len(pending) > 0 {
+		c.br.Reset(io.MultiReader(bytes.NewReader(pending), c.conn))
+	}
+}
+
+// adopt copies the HTTP/1.1 request into the stream's one. Connection-specific headers
+// are dropped.
+func (s *stream) adopt(req *http.Request) {
+	r := s.request
+	r.Method = req.Method
+	r.Path = req.Path
+	r.Query.Update(req.Query.Bytes())
+	r.ContentLength = req.ContentLength
+	r.ContentType = req.ContentType
+	r.Encoding.Content = req.Encoding.Content
+
+	for key, value := range req.Headers.Iter() {
+		if isConnectionSpecific(key) || strcomp.EqualFold(key, "http2-settings") {
+			continue
+		}
+
+		r.Headers.Add(strings.ToLower(key), value)
+	}
+}
+
+// ParseSettings decodes the HTTP2-Settings header value, which is a base64url-encoded
+// payload of the SETTINGS frame.
+func ParseSettings(value string) ([]http2.Setting, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil || len(payload)%6 != 0 {
+		return nil, errBadSettings
+	}
+
+	settings := make([]http2.Setting, 0, len(payload)/6)
+	for ; len(payload) > 0; payload = payload[6:] {
+		settings = append(settings, http2.Setting{
+			ID:  http2.SettingID(binary.BigEndian.Uint16(payload)),
+			Val: binary.BigEndian.Uint32(payload[2:]),
+		})
+	}
+
+	return settings, nil
+}
@@ -0,0 +1,342 @@
+// Package ranges serves the byte ranges of seekable attachments, as requested by the
+// Range header.
+package ranges
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/http/method"
+	"github.com/indigo-web/indigo/http/mime"
+	"github.com/indigo-web/indigo/http/status"
+	"github.com/indigo-web/indigo/internal/response"
+	"github.com/indigo-web/indigo/internal/strutil"
+	"github.com/indigo-web/indigo/internal/types"
+	"github.com/indigo-web/utils/strcomp"
+)
+
+// maxRanges limits the number of ranges in a single request. Requests with more ranges
+// are served the whole representation, as tiny ranges are no better than an amplification
+const maxRanges = 32
+
+// Apply advertises the range support for successful responses with an attachment, which
+// can be seeked, i.e. implements io.ReaderAt or io.Seeker. If the request asks for ranges,
+// the response is turned into 206 Partial Content, carrying either a single range or
+// multipart/byteranges, or 416 Range Not Satisfiable, if none of the ranges can be served.
+func Apply(request *http.Request, resp *http.Response) {
+	fields := resp.Reveal()
+	content := fields.Attachment.Content()
+	size := int64(fields.Attachment.Size())
+	if fields.Code != status.OK || content == nil || size <= 0 || !seekable(content) {
+		return
+	}
+
+	if accept, found := header(fields, "accept-ranges"); found {
+		if strcomp.EqualFold(strings.TrimSpace(accept), "none") {
+			return
+		}
+	} else {
+		resp.Header("Accept-Ranges", "bytes")
+	}
+
+	if request.Method != method.GET {
+		// range requests are defined for GET only
+		return
+	}
+
+	values := request.Headers.Values("range")
+	if len(values) != 1 || !fresh(request, fields) {
+		return
+	}
+
+	spans, ok := parse(values[0], size)
+	switch {
+	case !ok:
+	case len(spans) == 0:
+		fields.Attachment.Close()
+		resp.
+			Code(status.RequestedRangeNotSatisfiable).
+			Header("Content-Range", "bytes */"+strconv.FormatInt(size, 10)).
+			Attachment(nil, 0)
+	case len(spans) == 1:
+		s := spans[0]
+		resp.
+			Code(status.PartialContent).
+			Header("Content-Range", contentRange(s, size)).
+			Attachment(partial{
+				Reader:     single(content, s),
+				attachment: fields.Attachment,
+			}, int(s.length))
+	default:
+		byteranges(resp, fields, spans, size)
+	}
+}
+
+type span struct {
+	start, length int64
+}
+
+// parse returns the satisfiable ranges of the Range header value. If the value is malformed
+// or uses an unknown unit, ok is false and the header must be ignored.
+func parse(value string, size int64) (spans []span, ok bool) {
+	unit, set, found := strings.Cut(value, "=")
+	if !found || !strcomp.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, false
+	}
+
+	var total int64
+	for len(set) > 0 {
+		var spec string
+		spec, set, _ = strings.Cut(set, ",")
+		spec = strings.TrimSpace(spec)
+		if len(spec) == 0 {
+			continue
+		}
+
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, false
+		}
+
+		var s span
+		switch {
+		case len(first) == 0:
+			// suffix range, i.e. the last N bytes
+			n, valid := parseInt(last)
+			if !valid {
+				return nil, false
+			}
+
+			if n == 0 {
+				continue
+			}
+
+			n = min(n, size)
+			s = span{start: size - n, length: n}
+		default:
+			start, valid := parseInt(first)
+			if !valid {
+				return nil, false
+			}
+
+			end := size - 1
+			if len(last) > 0 {
+				if end, valid = parseInt(last); !valid || end < start {
+					return nil, false
+				}
+			}
+
+			if start >= size {
+				continue
+			}
+
+			s = span{start: start, length: min(end, size-1) - start + 1}
+		}
+
+		if len(spans) == maxRanges {
+			return nil, false
+		}
+
+		spans = append(spans, s)
+		total += s.length
+	}
+
+	if total > size {
+		// overlapping ranges are cheaper to be served as a whole
+		return nil, false
+	}
+
+	return spans, true
+}
+
+func parseInt(str string) (int64, bool) {
+	if len(str) == 0 || str[0] < '0' || str[0] > '9' {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(str, 10, 64)
+	return n, err == nil
+}
+
+// fresh tells whether the If-Range validator, if any, matches the response. Weak entity
+// tags never match.
+func fresh(request *http.Request, fields *response.Fields) bool {
+	validator, found := request.Headers.Get("if-range")
+	if !found {
+		return true
+	}
+
+	validator = strings.TrimSpace(validator)
+	if strings.HasPrefix(validator, "W/") {
+		return false
+	}
+
+	if strings.HasPrefix(validator, `"`) {
+		etag, found := header(fields, "etag")
+		return found && etag == validator
+	}
+
+	lastModified, found := header(fields, "last-modified")
+	return found && lastModified == validator
+}
+
+// byteranges turns the response into multipart/byteranges, carrying all the spans
+func byteranges(resp *http.Response, fields *response.Fields, spans []span, size int64) {
+	boundary := newBoundary()
+	contentType := fields.ContentType
+	content := fields.Attachment.Content()
+
+	var (
+		readers = make([]io.Reader, 0, len(spans)*2+1)
+		length  int64
+		buff    []byte
+	)
+
+	for i, s := range spans {
+		if i > 0 {
+			buff = append(buff, "\r\n"...)
+		}
+
+		buff = append(buff, "--"...)
+		buff = append(buff, boundary...)
+		buff = append(buff, "\r\n"...)
+		if len(contentType) > 0 {
+			buff = append(buff, "Content-Type: "...)
+			buff = append(buff, contentType...)
+			buff = append(buff, "\r\n"...)
+		}
+		buff = append(buff, "Content-Range: "...)
+		buff = append(buff, contentRange(s, size)...)
+		buff = append(buff, "\r\n\r\n"...)
+
+		readers = append(readers, strings.NewReader(string(buff)), section(content, s))
+		length += int64(len(buff)) + s.length
+		buff = buff[:0]
+	}
+
+	trailer := "\r\n--" + boundary + "--\r\n"
+	readers = append(readers, strings.NewReader(trailer))
+	length += int64(len(trailer))
+
+	resp.
+		Code(status.PartialContent).
+		ContentType(mime.ByteRanges+"; boundary="+boundary).
+		Attachment(partial{
+			Reader:     io.MultiReader(readers...),
+			attachment: fields.Attachment,
+		}, int(length))
+}
+
+func contentRange(s span, size int64) string {
+	buff := make([]byte, 0, len("bytes ")+3*20+2)
+	buff = append(buff, "bytes "...)
+	buff = strconv.AppendInt(buff, s.start, 10)
+	buff = append(buff, '-')
+	buff = strconv.AppendInt(buff, s.start+s.length-1, 10)
+	buff = append(buff, '/')
+	buff = strconv.AppendInt(buff, size, 10)
+
+	return string(buff)
+}
+
+func newBoundary() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func header(fields *response.Fields, key string) (string, bool) {
+	for _, h := range fields.Headers {
+		if strutil.CmpFold(h.Key, key) {
+			return h.Value, true
+		}
+	}
+
+	return "", false
+}
+
+func seekable(r io.Reader) bool {
+	switch r.(type) {
+	case io.ReaderAt, io.Seeker:
+		return true
+	default:
+		return false
+	}
+}
+
+// single returns the reader of the only span. Files are seeked and limited instead of being
+// wrapped, so they're still recognized by the serializer and transmitted via sendfile(2)
+func single(r io.Reader, s span) io.Reader {
+	if file, ok := r.(*os.File); ok {
+		if _, err := file.Seek(s.start, io.SeekStart); err == nil {
+			return &io.LimitedReader{R: file, N: s.length}
+		}
+	}
+
+	return section(r, s)
+}
+
+// section returns the reader of the span. io.ReaderAt is preferred, as it doesn't depend
+// on the current offset
+func section(r io.Reader, s span) io.Reader {
+	if at, ok := r.(io.ReaderAt); ok {
+		return io.NewSectionReader(at, s.start, s.length)
+	}
+
+	return &seeker{r: r, span: s}
+}
+
+// seeker reads the span from the io.ReadSeeker. Seeking is deferred until the first read,
+// so multiple spans of the same reader can be read one by one
+type seeker struct {
+	r      io.Reader
+	span   span
+	seeked bool
+}
+
+func (s *seeker) Read(b []byte) (n int, err error) {
+	if !s.seeked {
+		if _, err = s.r.(io.Seeker).Seek(s.span.start, io.SeekStart); err != nil {
+			return 0, err
+		}
+
+		s.seeked = true
+	}
+
+	if s.span.length <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(b)) > s.span.length {
+		b = b[:s.span.length]
+	}
+
+	n, err = s.r.Read(b)
+	s.span.length -= int64(n)
+	if err == io.EOF && s.span.length > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// partial is the ranged view of the attachment, closing the original one
+type partial struct {
+	io.Reader
+	attachment types.Attachment
+}
+
+func (p partial) Close() error {
+	p.attachment.Close()
+	return nil
+}
+
+// Unwrap returns the ranged reader, so the underlying file can be recognized
+func (p partial) Unwrap() io.Reader {
+	return p.Reader
+}
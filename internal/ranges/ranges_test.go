@@ -0,0 +1,217 @@
+package ranges
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/indigo-web/indigo/config"
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/http/method"
+	"github.com/indigo-web/indigo/http/status"
+	"github.com/indigo-web/indigo/internal/construct"
+	"github.com/indigo-web/indigo/transport/dummy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		spans []span
+		ok    bool
+	}{
+		{"bytes=0-4", []span{{0, 5}}, true},
+		{"BYTES = 0-4", []span{{0, 5}}, true},
+		{"bytes=5-", []span{{5, 8}}, true},
+		{"bytes=-3", []span{{10, 3}}, true},
+		{"bytes=-100", []span{{0, 13}}, true},
+		{"bytes=7-100", []span{{7, 6}}, true},
+		{"bytes=0-0, ,-1", []span{{0, 1}, {12, 1}}, true},
+		{"bytes=13-", nil, true},
+		{"bytes=-0", nil, true},
+		{"bytes=20-30, 13-", nil, true},
+		{"bytes=0-1, 20-", []span{{0, 2}}, true},
+		{"items=0-4", nil, false},
+		{"bytes", nil, false},
+		{"bytes=4-0", nil, false},
+		{"bytes=a-b", nil, false},
+		{"bytes=-", nil, false},
+		{"bytes=+1-2", nil, false},
+		{"bytes=1", nil, false},
+		{"bytes=0-, 0-", nil, false},
+		{"bytes=" + strings.Repeat("0-0,", maxRanges+1), nil, false},
+	} {
+		spans, ok := parse(tc.value, 13)
+		require.Equal(t, tc.ok, ok, tc.value)
+		require.Equal(t, tc.spans, spans, tc.value)
+	}
+}
+
+// readSeeker hides io.ReaderAt of the underlying reader
+type readSeeker struct {
+	io.ReadSeeker
+	closed bool
+}
+
+func (r *readSeeker) Close() error {
+	r.closed = true
+	return nil
+}
+
+func value(resp *http.Response, key string) string {
+	v, _ := header(resp.Reveal(), key)
+	return v
+}
+
+func TestApply(t *testing.T) {
+	const content = "Hello, world!"
+
+	newRequest := func(hdrs ...string) *http.Request {
+		request := construct.Request(config.Default(), dummy.NewNopClient(), nil)
+		request.Method = method.GET
+		for i := 0; i < len(hdrs); i += 2 {
+			request.Headers.Add(hdrs[i], hdrs[i+1])
+		}
+
+		return request
+	}
+
+	apply := func(request *http.Request, r io.Reader) (*http.Response, string) {
+		resp := request.Respond().ContentType("text/plain").Attachment(r, len(content))
+		Apply(request, resp)
+
+		fields := resp.Reveal()
+		if fields.Attachment.Content() == nil {
+			return resp, ""
+		}
+
+		data, err := io.ReadAll(fields.Attachment.Content())
+		require.NoError(t, err)
+		require.Len(t, data, fields.Attachment.Size())
+		fields.Attachment.Close()
+
+		return resp, string(data)
+	}
+
+	t.Run("no range", func(t *testing.T) {
+		resp, body := apply(newRequest(), strings.NewReader(content))
+		require.Equal(t, status.OK, resp.Reveal().Code)
+		require.Equal(t, "bytes", value(resp, "accept-ranges"))
+		require.Equal(t, content, body)
+	})
+
+	t.Run("single range", func(t *testing.T) {
+		for _, r := range []io.Reader{strings.NewReader(content), &readSeeker{ReadSeeker: strings.NewReader(content)}} {
+			resp, body := apply(newRequest("Range", "bytes=7-11"), r)
+			require.Equal(t, status.PartialContent, resp.Reveal().Code)
+			require.Equal(t, "bytes 7-11/13", value(resp, "content-range"))
+			require.Equal(t, "world", body)
+		}
+	})
+
+	t.Run("single range of a file", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "file.txt")
+		require.NoError(t, os.WriteFile(name, []byte(content), 0o600))
+		file, err := os.Open(name)
+		require.NoError(t, err)
+
+		request := newRequest("Range", "bytes=7-11")
+		resp := request.Respond().Attachment(file, len(content))
+		Apply(request, resp)
+		// the file must stay recognizable, so it can be sent via sendfile(2)
+		inner := resp.Reveal().Attachment.Content().(interface{ Unwrap() io.Reader }).Unwrap()
+		require.IsType(t, new(io.LimitedReader), inner)
+		require.Equal(t, file, inner.(*io.LimitedReader).R)
+
+		data, err := io.ReadAll(inner)
+		require.NoError(t, err)
+		require.Equal(t, "world", string(data))
+		resp.Reveal().Attachment.Close()
+		require.ErrorIs(t, file.Close(), os.ErrClosed)
+	})
+
+	t.Run("multiple ranges", func(t *testing.T) {
+		source := &readSeeker{ReadSeeker: strings.NewReader(content)}
+		resp, body := apply(newRequest("Range", "bytes=0-4, -1"), source)
+		require.True(t, source.closed)
+		require.Equal(t, status.PartialContent, resp.Reveal().Code)
+		_, found := header(resp.Reveal(), "content-range")
+		require.False(t, found)
+
+		mediatype, params, err := mime.ParseMediaType(resp.Reveal().ContentType)
+		require.NoError(t, err)
+		require.Equal(t, "multipart/byteranges", mediatype)
+
+		reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+		for _, want := range []struct{ contentRange, data string }{
+			{"bytes 0-4/13", "Hello"},
+			{"bytes 12-12/13", "!"},
+		} {
+			part, err := reader.NextPart()
+			require.NoError(t, err)
+			require.Equal(t, "text/plain", part.Header.Get("Content-Type"))
+			require.Equal(t, want.contentRange, part.Header.Get("Content-Range"))
+			data, err := io.ReadAll(part)
+			require.NoError(t, err)
+			require.Equal(t, want.data, string(data))
+		}
+
+		_, err = reader.NextPart()
+		require.Equal(t, io.EOF, err)
+	})
+
+	t.Run("not satisfiable", func(t *testing.T) {
+		source := &readSeeker{ReadSeeker: strings.NewReader(content)}
+		resp, body := apply(newRequest("Range", "bytes=13-"), source)
+		require.True(t, source.closed)
+		require.Equal(t, status.RequestedRangeNotSatisfiable, resp.Reveal().Code)
+		require.Equal(t, "bytes */13", value(resp, "content-range"))
+		require.Empty(t, body)
+	})
+
+	t.Run("if-range", func(t *testing.T) {
+		for validator, partial := range map[string]bool{
+			`"abc"`:                         true,
+			`"abd"`:                         false,
+			`W/"abc"`:                       false,
+			"Wed, 21 Oct 2015 07:28:00 GMT": true,
+			"Wed, 21 Oct 2015 07:28:01 GMT": false,
+		} {
+			request := newRequest("Range", "bytes=0-4", "If-Range", validator)
+			resp := request.Respond().
+				Header("ETag", `"abc"`).
+				Header("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT").
+				Attachment(strings.NewReader(content), len(content))
+			Apply(request, resp)
+			require.Equal(t, partial, resp.Reveal().Code == status.PartialContent, validator)
+		}
+	})
+
+	t.Run("ignored", func(t *testing.T) {
+		request := newRequest("Range", "bytes=0-4")
+		request.Method = method.HEAD
+		resp, body := apply(request, strings.NewReader(content))
+		require.Equal(t, status.OK, resp.Reveal().Code)
+		require.Equal(t, "bytes", value(resp, "accept-ranges"))
+		require.Equal(t, content, body)
+
+		resp, body = apply(newRequest("Range", "bytes=0-4"), bytes.NewBufferString(content))
+		require.Equal(t, status.OK, resp.Reveal().Code)
+		_, found := header(resp.Reveal(), "accept-ranges")
+		require.False(t, found, "non-seekable attachments must not advertise ranges")
+		require.Equal(t, content, body)
+
+		request = newRequest("Range", "bytes=0-4")
+		resp = request.Respond().
+			Header("Accept-Ranges", "none").
+			Attachment(strings.NewReader(content), len(content))
+		Apply(request, resp)
+		require.Equal(t, status.OK, resp.Reveal().Code)
+		require.Equal(t, "none", value(resp, "accept-ranges"))
+	})
+}
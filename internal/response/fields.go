@@ -12,6 +12,7 @@ const DefaultContentType = mime.HTML
 
 type Fields struct {
 	Attachment  types.Attachment
+	Stream      types.Stream
 	Headers     []headers.Header
 	Body        []byte
 	Cookies     []cookie.Cookie
@@ -32,4 +33,5 @@ func (f *Fields) Clear() {
 	f.Body = nil
 	f.Cookies = f.Cookies[:0]
 	f.Attachment = types.Attachment{}
+	f.Stream = nil
 }
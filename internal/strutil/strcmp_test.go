@@ -7,6 +7,15 @@ import (
 
 func TestFold(t *testing.T) {
 	require.True(t, CmpFold("HELLO", "hello"))
+	require.True(t, CmpFold("GZIP", "gzip"))
 	require.True(t, CmpFold("\r\n\r\n", "\r\n\r\n"))
 	require.False(t, CmpFold("\v\t", "\r\t"))
 }
+
+func TestHasToken(t *testing.T) {
+	require.True(t, HasToken("Upgrade", "upgrade"))
+	require.True(t, HasToken("keep-alive, Upgrade", "upgrade"))
+	require.True(t, HasToken("keep-alive,upgrade ,close", "UPGRADE"))
+	require.False(t, HasToken("keep-alive, upgraded", "upgrade"))
+	require.False(t, HasToken("", "upgrade"))
+}
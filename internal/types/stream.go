@@ -0,0 +1,17 @@
+package types
+
+import "io"
+
+// StreamWriter is an io.Writer, which buffers the written data until it's either flushed
+// explicitly or the buffer is full.
+type StreamWriter interface {
+	io.Writer
+	// Flush sends all the buffered data to the client
+	Flush() error
+	// Done returns a channel, which is closed as soon as the client is gone or the server
+	// begins shutting down. Long-living streams are expected to finish then
+	Done() <-chan struct{}
+}
+
+// Stream produces the response body by writing it into the StreamWriter
+type Stream func(w StreamWriter) error
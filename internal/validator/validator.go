@@ -0,0 +1,114 @@
+// Package validator compares the validators of representations, i.e. entity tags and
+// modification dates, against the preconditions of requests, as described in RFC 9110, 13.
+package validator
+
+import (
+	"strings"
+	"time"
+
+	"github.com/indigo-web/indigo/http/headers"
+)
+
+// Failed tells whether If-Match or If-Unmodified-Since precondition of the request isn't met
+// by the current state of the resource. Empty etag means the resource has no entity tag, and
+// zero modification time means it's unknown. The order of evaluation follows RFC 9110, 13.2.2
+func Failed(hdrs headers.Headers, etag string, modified time.Time) bool {
+	if values := hdrs.Values("if-match"); len(values) > 0 {
+		return !Match(values, etag, false)
+	}
+
+	since, ok := ParseDate(hdrs.Value("if-unmodified-since"))
+
+	return ok && !modified.IsZero() && modified.After(since)
+}
+
+// Match tells whether the list of entity tags contains the etag. Weak comparison
+// disregards the weakness of both tags, while strong one requires both to be strong.
+// The wildcard matches any existing representation, which is always the case here
+func Match(list []string, etag string, weak bool) bool {
+	// if the response has no valid entity tag, the opaque tag is empty and therefore only
+	// the wildcard can match
+	etagWeak, opaque := split(etag)
+
+	for _, value := range list {
+		for len(value) > 0 {
+			value = strings.TrimLeft(value, " \t,")
+			if len(value) == 0 {
+				break
+			}
+
+			if value[0] == '*' {
+				return true
+			}
+
+			var (
+				tagWeak bool
+				tag     string
+			)
+			if tagWeak, tag, value = next(value); len(tag) == 0 {
+				// malformed list
+				return false
+			}
+
+			if tag == opaque && (weak || (!tagWeak && !etagWeak)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// next cuts the leading entity tag off the list. The returned tag is empty, if the list
+// is malformed
+func next(list string) (weak bool, tag, rest string) {
+	if strings.HasPrefix(list, "W/") {
+		weak, list = true, list[2:]
+	}
+
+	if len(list) == 0 || list[0] != '"' {
+		return false, "", ""
+	}
+
+	end := strings.IndexByte(list[1:], '"')
+	if end == -1 {
+		return false, "", ""
+	}
+
+	return weak, list[:end+2], list[end+2:]
+}
+
+// split separates the weakness indicator from the opaque tag
+func split(etag string) (weak bool, opaque string) {
+	etag = strings.TrimSpace(etag)
+	weak, tag, rest := next(etag)
+	if len(rest) > 0 {
+		return false, ""
+	}
+
+	return weak, tag
+}
+
+// dateFormats are the formats HTTP-date may come in. Only the first one is generated,
+// the others are obsolete, yet must be accepted
+var dateFormats = []string{
+	"Mon, 02 Jan 2006 15:04:05 GMT",
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	time.ANSIC,
+}
+
+// ParseDate parses the HTTP-date. False is returned, if the value is empty or malformed
+func ParseDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return time.Time{}, false
+	}
+
+	for _, format := range dateFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -1,6 +1,8 @@
 package inbuilt
 
 import (
+	"io/fs"
+
 	"github.com/indigo-web/indigo/http/method"
 )
 
@@ -41,6 +43,12 @@ func (r Resource) Static(prefix, root string) Resource {
 	return r
 }
 
+// StaticFS adds a catcher of prefix, that returns files from the file system
+func (r Resource) StaticFS(prefix string, fsys fs.FS, cfg StaticConfig) Resource {
+	r.group.StaticFS(prefix, fsys, cfg)
+	return r
+}
+
 // Route is a shortcut to group.Route, providing the extra empty path to the call
 func (r Resource) Route(method method.Method, fun Handler, mwares ...Middleware) Resource {
 	r.group.Route(method, "", fun, mwares...)
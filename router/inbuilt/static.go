@@ -1,42 +1,266 @@
 package inbuilt
 
 import (
+	"errors"
+	"html"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
 	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/http/mime"
 	"github.com/indigo-web/indigo/http/status"
-	"github.com/indigo-web/indigo/internal/pathlib"
-	"strings"
+	"github.com/indigo-web/indigo/internal/compression"
 )
 
+// StaticConfig tunes the distribution of static files. The zero value serves files and
+// index files of directories only.
+type StaticConfig struct {
+	// Index is the name of the file, served in response to the request of the directory.
+	// By default, index.html
+	Index string
+	// Listing enables rendering the list of entries of directories, having no index file
+	Listing bool
+	// Precompressed enables serving the .br and .gz siblings of the requested file instead,
+	// if they're present and accepted by the client
+	Precompressed bool
+	// CacheControl maps file extensions, e.g. ".js", to the values of the Cache-Control
+	// header. The value of the empty extension is used for all the files, which aren't listed
+	CacheControl map[string]string
+	// Fallback is the file served if the requested one isn't found, e.g. the entrypoint of
+	// the single page application, so its routes can be resolved on the client side
+	Fallback string
+}
+
+// precompressed lists the content codings, which are looked up as the siblings of the
+// requested file, in order of preference
+var precompressed = []struct {
+	coding, suffix string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
 // Static adds a catcher of prefix, that automatically returns files from defined root
 // directory
 func (r *Router) Static(prefix, root string, mwares ...Middleware) *Router {
-	pathReplacer := pathlib.NewPath(prefix, root)
+	return r.StaticFS(prefix, os.DirFS(root), StaticConfig{}, mwares...)
+}
+
+// StaticFS adds a catcher of prefix, that returns files from the file system, e.g. embed.FS.
+// The requested path is cleaned before it's looked up, so it can't escape the file system
+func (r *Router) StaticFS(prefix string, fsys fs.FS, cfg StaticConfig, mwares ...Middleware) *Router {
+	if len(cfg.Index) == 0 {
+		cfg.Index = "index.html"
+	}
 
-	return r.Catch(prefix, func(request *http.Request) *http.Response {
-		pathReplacer.Set(request.Path)
-		path := pathReplacer.Relative()
-		if !isSafe(path) {
+	s := static{
+		prefix: path.Join(r.prefix, prefix),
+		fsys:   fsys,
+		cfg:    cfg,
+	}
+
+	return r.Catch(prefix, s.serve, mwares...)
+}
+
+type static struct {
+	prefix string
+	fsys   fs.FS
+	cfg    StaticConfig
+}
+
+func (s static) serve(request *http.Request) *http.Response {
+	name := clean(strings.TrimPrefix(request.Path, s.prefix))
+	file, stat, err := s.open(name)
+	if errors.Is(err, fs.ErrPermission) {
+		return http.Error(request, status.ErrForbidden)
+	}
+
+	if err != nil {
+		// besides missing files, a path going through a regular file also ends up here
+		if len(s.cfg.Fallback) == 0 {
 			return http.Error(request, status.ErrNotFound)
 		}
 
-		return request.Respond().File(path)
-	}, mwares...)
+		name = clean(s.cfg.Fallback)
+		if file, stat, err = s.open(name); err != nil || stat.IsDir() {
+			closeFile(file)
+			return http.Error(request, status.ErrNotFound)
+		}
+	}
+
+	if stat.IsDir() {
+		_ = file.Close()
+		return s.serveDir(request, name)
+	}
+
+	return s.serveFile(request, name, file, stat)
 }
 
-// isSafe checks for path traversal (basically - double dots)
-func isSafe(path string) bool {
-	for len(path) > 0 {
-		dot := strings.IndexByte(path, '.')
-		if dot == -1 {
-			return true
+// serveDir responds with the index file of the directory, or with the list of its entries
+func (s static) serveDir(request *http.Request, name string) *http.Response {
+	index := path.Join(name, s.cfg.Index)
+	file, stat, err := s.open(index)
+	if err == nil && !stat.IsDir() {
+		return s.serveFile(request, index, file, stat)
+	}
+
+	closeFile(file)
+	if !s.cfg.Listing {
+		return http.Error(request, status.ErrNotFound)
+	}
+
+	entries, err := fs.ReadDir(s.fsys, name)
+	if err != nil {
+		return http.Error(request, status.ErrInternalServerError)
+	}
+
+	return request.Respond().
+		ContentType(mime.HTML + "; charset=utf-8").
+		Bytes(listing(path.Join(s.prefix, name), entries))
+}
+
+func (s static) serveFile(request *http.Request, name string, file fs.File, stat fs.FileInfo) *http.Response {
+	resp := request.Respond()
+	contentType := mime.Extension[path.Ext(name)]
+	if len(contentType) == 0 {
+		contentType = mime.OctetStream
+	}
+
+	if cacheControl := s.cacheControl(name); len(cacheControl) > 0 {
+		resp.Header("Cache-Control", cacheControl)
+	}
+
+	if s.cfg.Precompressed {
+		// the representation depends on the Accept-Encoding, even if it's the identity one
+		resp.Header("Vary", "Accept-Encoding")
+
+		if coding, f, st := s.variant(request, name); f != nil {
+			_ = file.Close()
+			file, stat = f, st
+			resp.Header("Content-Encoding", coding)
 		}
+	}
 
-		if dot < len(path)-1 && path[dot+1] == '.' {
-			return false
+	if modTime := stat.ModTime(); !modTime.IsZero() {
+		resp.LastModified(modTime)
+	}
+
+	return resp.
+		ContentType(contentType).
+		Attachment(file, int(stat.Size()))
+}
+
+// variant opens the precompressed sibling of the file, which is the most preferred by
+// the client. Nil file is returned, if there's none
+func (s static) variant(request *http.Request, name string) (coding string, file fs.File, stat fs.FileInfo) {
+	accept := request.Headers.Values("accept-encoding")
+	if len(accept) == 0 {
+		return "", nil, nil
+	}
+
+	available := make([]string, 0, len(precompressed))
+	for _, p := range precompressed {
+		if st, err := fs.Stat(s.fsys, name+p.suffix); err == nil && !st.IsDir() {
+			available = append(available, p.coding)
+		}
+	}
+
+	coding = compression.Negotiate(available, accept)
+	for _, p := range precompressed {
+		if p.coding != coding {
+			continue
+		}
+
+		file, stat, err := s.open(name + p.suffix)
+		if err != nil || stat.IsDir() {
+			closeFile(file)
+			return "", nil, nil
+		}
+
+		return coding, file, stat
+	}
+
+	return "", nil, nil
+}
+
+func (s static) cacheControl(name string) string {
+	if value, found := s.cfg.CacheControl[path.Ext(name)]; found {
+		return value
+	}
+
+	return s.cfg.CacheControl[""]
+}
+
+func (s static) open(name string) (fs.File, fs.FileInfo, error) {
+	file, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, err
+	}
+
+	return file, stat, nil
+}
+
+// clean turns the request path into the valid name of the file system. Dot-dot elements
+// are resolved against the root, so they can't escape it
+func clean(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if len(name) == 0 {
+		return "."
+	}
+
+	return name
+}
+
+func closeFile(file fs.File) {
+	if file != nil {
+		_ = file.Close()
+	}
+}
+
+// listing renders the list of the directory entries as a HTML page. The links are absolute,
+// as the router trims the trailing slash, so relative ones would've been resolved against
+// the parent directory
+func listing(dir string, entries []fs.DirEntry) []byte {
+	var b strings.Builder
+	title := html.EscapeString(dir)
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>")
+	b.WriteString(title)
+	b.WriteString("</title></head>\n<body>\n<h1>")
+	b.WriteString(title)
+	b.WriteString("</h1>\n<ul>\n")
+	if dir != "/" {
+		b.WriteString("<li><a href=\"")
+		b.WriteString(href(path.Dir(dir)))
+		b.WriteString("\">../</a></li>\n")
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
 		}
 
-		path = path[dot+1:]
+		b.WriteString("<li><a href=\"")
+		b.WriteString(href(path.Join(dir, name)))
+		b.WriteString("\">")
+		b.WriteString(html.EscapeString(name))
+		b.WriteString("</a></li>\n")
 	}
 
-	return true
+	b.WriteString("</ul>\n</body>\n</html>\n")
+
+	return []byte(b.String())
+}
+
+func href(p string) string {
+	return html.EscapeString((&url.URL{Path: p}).EscapedPath())
 }
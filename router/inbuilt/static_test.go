@@ -0,0 +1,205 @@
+package inbuilt
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/http/method"
+	"github.com/indigo-web/indigo/http/mime"
+	"github.com/indigo-web/indigo/http/status"
+	"github.com/indigo-web/indigo/router"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatic(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"index.html":          {Data: []byte("root index"), ModTime: modTime},
+		"app.js":              {Data: []byte("plain js")},
+		"app.js.br":           {Data: []byte("brotli js")},
+		"app.js.gz":           {Data: []byte("gzip js")},
+		"style.css":           {Data: []byte("css")},
+		"docs/index.html":     {Data: []byte("docs index")},
+		"assets/a b.txt":      {Data: []byte("a")},
+		"assets/<script>.txt": {Data: []byte("b")},
+		"assets/nested/c.txt": {Data: []byte("c")},
+	}
+
+	get := func(r router.Router, path string, hdrs ...string) *http.Response {
+		request := getRequest(method.GET, path)
+		for i := 0; i+1 < len(hdrs); i += 2 {
+			request.Headers.Add(hdrs[i], hdrs[i+1])
+		}
+
+		return r.OnRequest(request)
+	}
+
+	body := func(t *testing.T, resp *http.Response) string {
+		fields := resp.Reveal()
+		if content := fields.Attachment.Content(); content != nil {
+			data, err := io.ReadAll(content)
+			require.NoError(t, err)
+			fields.Attachment.Close()
+
+			return string(data)
+		}
+
+		return string(fields.Body)
+	}
+
+	header := func(resp *http.Response, key string) []string {
+		var values []string
+		for _, h := range resp.Reveal().Headers {
+			if h.Key == key {
+				values = append(values, h.Value)
+			}
+		}
+
+		return values
+	}
+
+	t.Run("file", func(t *testing.T) {
+		r := New().StaticFS("/static", fsys, StaticConfig{}).Initialize()
+		resp := get(r, "/static/style.css")
+		require.Equal(t, status.OK, resp.Reveal().Code)
+		require.Equal(t, mime.Extension[".css"], resp.Reveal().ContentType)
+		require.Equal(t, "css", body(t, resp))
+		require.Empty(t, header(resp, "Content-Encoding"))
+		require.Empty(t, header(resp, "Vary"))
+	})
+
+	t.Run("index", func(t *testing.T) {
+		r := New().StaticFS("/", fsys, StaticConfig{}).Initialize()
+		resp := get(r, "/")
+		require.Equal(t, status.OK, resp.Reveal().Code)
+		require.Equal(t, "root index", body(t, resp))
+		require.Equal(t, []string{"Tue, 02 Jan 2024 03:04:05 GMT"}, header(resp, "Last-Modified"))
+
+		require.Equal(t, "docs index", body(t, get(r, "/docs")))
+		require.Equal(t, "docs index", body(t, get(r, "/docs/")))
+	})
+
+	t.Run("custom index", func(t *testing.T) {
+		r := New().StaticFS("/", fsys, StaticConfig{Index: "style.css"}).Initialize()
+		require.Equal(t, "css", body(t, get(r, "/")))
+	})
+
+	t.Run("group", func(t *testing.T) {
+		raw := New()
+		raw.Group("/api").StaticFS("/files", fsys, StaticConfig{})
+		r := raw.Initialize()
+		require.Equal(t, "css", body(t, get(r, "/api/files/style.css")))
+		require.Equal(t, "docs index", body(t, get(r, "/api/files/docs")))
+	})
+
+	t.Run("listing", func(t *testing.T) {
+		r := New().StaticFS("/", fsys, StaticConfig{}).Initialize()
+		require.Equal(t, status.NotFound, get(r, "/assets/").Reveal().Code)
+
+		r = New().StaticFS("/static", fsys, StaticConfig{Listing: true}).Initialize()
+		resp := get(r, "/static/assets/")
+		require.Equal(t, status.OK, resp.Reveal().Code)
+		require.Contains(t, resp.Reveal().ContentType, mime.HTML)
+		page := body(t, resp)
+		require.Contains(t, page, `<a href="/static">../</a>`)
+		require.Contains(t, page, `<a href="/static/assets/a%20b.txt">a b.txt</a>`)
+		require.Contains(t, page, `<a href="/static/assets/nested">nested/</a>`)
+		require.Contains(t, page, `&lt;script&gt;.txt`)
+		require.NotContains(t, page, `<script>`)
+	})
+
+	t.Run("traversal", func(t *testing.T) {
+		r := New().StaticFS("/static", fsys, StaticConfig{}).Initialize()
+		for _, path := range []string{
+			"/static/../index.html",
+			"/static/docs/../../index.html",
+			"/static/../../etc/passwd",
+		} {
+			resp := get(r, path)
+			if resp.Reveal().Code == status.OK {
+				// dot-dot elements are resolved against the root
+				require.Contains(t, []string{"root index", "docs index"}, body(t, resp), path)
+			} else {
+				require.Equal(t, status.NotFound, resp.Reveal().Code, path)
+			}
+		}
+
+		resp := get(r, "/static/style.css/../../../index.html")
+		require.Equal(t, "root index", body(t, resp))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		r := New().StaticFS("/", fsys, StaticConfig{}).Initialize()
+		require.Equal(t, status.NotFound, get(r, "/missing.txt").Reveal().Code)
+		require.Equal(t, status.NotFound, get(r, "/style.css/child").Reveal().Code)
+	})
+
+	t.Run("precompressed", func(t *testing.T) {
+		r := New().StaticFS("/", fsys, StaticConfig{Precompressed: true}).Initialize()
+
+		resp := get(r, "/app.js", "accept-encoding", "gzip, br")
+		require.Equal(t, "brotli js", body(t, resp))
+		require.Equal(t, []string{"br"}, header(resp, "Content-Encoding"))
+		require.Equal(t, []string{"Accept-Encoding"}, header(resp, "Vary"))
+		require.Equal(t, mime.Extension[".js"], resp.Reveal().ContentType)
+
+		resp = get(r, "/app.js", "accept-encoding", "gzip, br;q=0.5")
+		require.Equal(t, "gzip js", body(t, resp))
+		require.Equal(t, []string{"gzip"}, header(resp, "Content-Encoding"))
+
+		resp = get(r, "/app.js", "accept-encoding", "zstd")
+		require.Equal(t, "plain js", body(t, resp))
+		require.Empty(t, header(resp, "Content-Encoding"))
+		require.Equal(t, []string{"Accept-Encoding"}, header(resp, "Vary"))
+
+		resp = get(r, "/app.js")
+		require.Equal(t, "plain js", body(t, resp))
+
+		resp = get(r, "/style.css", "accept-encoding", "gzip")
+		require.Equal(t, "css", body(t, resp))
+		require.Empty(t, header(resp, "Content-Encoding"))
+	})
+
+	t.Run("cache control", func(t *testing.T) {
+		r := New().StaticFS("/", fsys, StaticConfig{
+			CacheControl: map[string]string{
+				".js": "max-age=31536000, immutable",
+				"":    "no-cache",
+			},
+		}).Initialize()
+
+		resp := get(r, "/app.js")
+		require.Equal(t, []string{"max-age=31536000, immutable"}, header(resp, "Cache-Control"))
+		resp = get(r, "/style.css")
+		require.Equal(t, []string{"no-cache"}, header(resp, "Cache-Control"))
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		r := New().StaticFS("/", fsys, StaticConfig{Fallback: "/index.html"}).Initialize()
+		resp := get(r, "/users/42")
+		require.Equal(t, status.OK, resp.Reveal().Code)
+		require.Equal(t, "root index", body(t, resp))
+		require.Equal(t, "css", body(t, get(r, "/style.css")))
+
+		r = New().StaticFS("/", fsys, StaticConfig{Fallback: "missing.html"}).Initialize()
+		require.Equal(t, status.NotFound, get(r, "/users/42").Reveal().Code)
+	})
+
+	t.Run("os directory", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(root, "hello.txt"), []byte("Hello, world!"), 0o644))
+		r := New().Static("/", root).Initialize()
+
+		resp := get(r, "/hello.txt")
+		require.Equal(t, status.OK, resp.Reveal().Code)
+		// files of the OS are sent via sendfile, if possible
+		require.IsType(t, new(os.File), resp.Reveal().Attachment.Content())
+		require.Equal(t, "Hello, world!", body(t, resp))
+		require.Equal(t, status.NotFound, get(r, "/../hello.txt/x").Reveal().Code)
+	})
+}
@@ -0,0 +1,238 @@
+// Package sse implements Server-Sent Events on top of streaming responses.
+package sse
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/http/mime"
+)
+
+// DefaultHeartbeat is the default interval between heartbeat comments
+const DefaultHeartbeat = 15 * time.Second
+
+var (
+	// ErrClosed is returned when writing to a stream, whose handler already returned
+	ErrClosed = errors.New("event stream is closed")
+	// ErrInvalidField is returned if the event's name or id contains a line break, which
+	// would break the framing
+	ErrInvalidField = errors.New("event name and id must not contain line breaks")
+)
+
+// Event is a single message of the event stream. Empty fields are omitted.
+type Event struct {
+	// ID sets the event id, which is sent back by the client in the Last-Event-ID
+	// header on reconnection
+	ID string
+	// Event is the event name. Clients dispatch unnamed events as "message"
+	Event string
+	// Data is the payload. It may contain multiple lines
+	Data string
+	// Retry tells the client how long to wait before reconnecting
+	Retry time.Duration
+}
+
+// Streamer responds with an event stream. Zero value is ready to use.
+type Streamer struct {
+	// Heartbeat is the interval between comments sent in order to keep the connection
+	// alive and to detect disconnected clients. Defaults to DefaultHeartbeat, negative
+	// value disables heartbeats.
+	Heartbeat time.Duration
+}
+
+// Respond responds with an event stream with default settings.
+func Respond(request *http.Request, handler func(stream *Stream) error) *http.Response {
+	return Streamer{}.Respond(request, handler)
+}
+
+// Respond returns a response, which streams the events sent by the handler. The stream
+// ends when the handler returns.
+func (s Streamer) Respond(request *http.Request, handler func(stream *Stream) error) *http.Response {
+	heartbeat := s.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = DefaultHeartbeat
+	}
+
+	return request.Respond().
+		ContentType(mime.EventStream).
+		Header("Cache-Control", "no-cache").
+		// disables buffering in reverse proxies like nginx
+		Header("X-Accel-Buffering", "no").
+		Stream(func(w http.StreamWriter) error {
+			stream := newStream(w, request.Headers.Value("last-event-id"))
+			stream.wg.Add(1)
+			go stream.watch()
+
+			if heartbeat > 0 {
+				stream.wg.Add(1)
+				go stream.heartbeat(heartbeat)
+			}
+
+			err := handler(stream)
+			stream.close()
+
+			return err
+		})
+}
+
+// Stream sends events to the client. It's safe for concurrent use.
+type Stream struct {
+	w           http.StreamWriter
+	lastEventID string
+	mu          sync.Mutex
+	buff        []byte
+	err         error
+	gone        chan struct{}
+	stop        chan struct{}
+	once        sync.Once
+	wg          sync.WaitGroup
+}
+
+func newStream(w http.StreamWriter, lastEventID string) *Stream {
+	return &Stream{
+		w:           w,
+		lastEventID: lastEventID,
+		gone:        make(chan struct{}),
+		stop:        make(chan struct{}),
+	}
+}
+
+// LastEventID returns the id of the last event received by the client before reconnecting,
+// as reported by the Last-Event-ID header. Empty string means it's a new connection.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done returns a channel, which is closed as soon as the client is gone, the server begins
+// shutting down or the stream is closed. The handler is expected to return then.
+func (s *Stream) Done() <-chan struct{} {
+	return s.gone
+}
+
+// Send writes the event and flushes it immediately.
+func (s *Stream) Send(event Event) error {
+	if strings.ContainsAny(event.ID, "\r\n") || strings.ContainsAny(event.Event, "\r\n") {
+		return ErrInvalidField
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(event.Event) > 0 {
+		s.buff = append(append(append(s.buff, "event: "...), event.Event...), '\n')
+	}
+
+	if len(event.ID) > 0 {
+		s.buff = append(append(append(s.buff, "id: "...), event.ID...), '\n')
+	}
+
+	if event.Retry > 0 {
+		s.buff = strconv.AppendInt(append(s.buff, "retry: "...), event.Retry.Milliseconds(), 10)
+		s.buff = append(s.buff, '\n')
+	}
+
+	if len(event.Data) > 0 {
+		for _, line := range splitLines(event.Data) {
+			s.buff = append(append(append(s.buff, "data: "...), line...), '\n')
+		}
+	}
+
+	return s.flush()
+}
+
+// Comment writes a comment, which is ignored by the client. Line breaks are allowed.
+func (s *Stream) Comment(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, line := range splitLines(text) {
+		s.buff = append(append(append(s.buff, ':'), line...), '\n')
+	}
+
+	return s.flush()
+}
+
+// flush terminates the message and sends it. Must be called while holding the lock.
+func (s *Stream) flush() error {
+	data := append(s.buff, '\n')
+	s.buff = data[:0]
+
+	if s.err != nil {
+		return s.err
+	}
+
+	_, err := s.w.Write(data)
+	if err == nil {
+		err = s.w.Flush()
+	}
+
+	if err != nil {
+		s.fail(err)
+	}
+
+	return err
+}
+
+// fail marks the stream as unusable. Must be called while holding the lock.
+func (s *Stream) fail(err error) {
+	s.err = err
+	s.leave()
+}
+
+// leave closes the channel returned by Done
+func (s *Stream) leave() {
+	s.once.Do(func() {
+		close(s.gone)
+	})
+}
+
+// watch closes the stream as soon as the connection tells it must finish
+func (s *Stream) watch() {
+	defer s.wg.Done()
+
+	select {
+	case <-s.w.Done():
+		s.leave()
+	case <-s.stop:
+	}
+}
+
+func (s *Stream) heartbeat(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if s.Comment("") != nil {
+				return
+			}
+		}
+	}
+}
+
+// close stops the heartbeat and forbids further writes, as the response is finished
+func (s *Stream) close() {
+	close(s.stop)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	if s.err == nil {
+		s.fail(ErrClosed)
+	}
+	s.mu.Unlock()
+}
+
+// splitLines splits the text by any of the line terminators allowed by the specification
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.Split(strings.ReplaceAll(text, "\r", "\n"), "\n")
+}
@@ -0,0 +1,167 @@
+package sse
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/indigo-web/indigo/config"
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/internal/construct"
+	"github.com/indigo-web/indigo/transport/dummy"
+	"github.com/stretchr/testify/require"
+)
+
+type streamWriter struct {
+	mu      sync.Mutex
+	buff    bytes.Buffer
+	flushed []string
+	err     error
+	done    chan struct{}
+}
+
+func (s *streamWriter) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	return s.buff.Write(b)
+}
+
+func (s *streamWriter) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	s.flushed = append(s.flushed, s.buff.String())
+	s.buff.Reset()
+	return nil
+}
+
+func (s *streamWriter) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *streamWriter) Flushed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.flushed...)
+}
+
+func (s *streamWriter) Fail(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+func stream(t *testing.T, streamer Streamer, handler func(*Stream) error) *http.Response {
+	request := construct.Request(config.Default(), dummy.NewNopClient(), nil)
+	request.Headers.Add("Last-Event-ID", "42")
+	resp := streamer.Respond(request, handler)
+	fields := resp.Reveal()
+	require.Equal(t, "text/event-stream", fields.ContentType)
+	require.NotNil(t, fields.Stream)
+
+	return resp
+}
+
+func TestStream(t *testing.T) {
+	t.Run("framing", func(t *testing.T) {
+		w := new(streamWriter)
+		resp := stream(t, Streamer{Heartbeat: -1}, func(s *Stream) error {
+			require.Equal(t, "42", s.LastEventID())
+			require.NoError(t, s.Send(Event{Data: "hello"}))
+			require.NoError(t, s.Send(Event{
+				ID:    "43",
+				Event: "update",
+				Data:  "first\nsecond\r\n\r\nlast",
+				Retry: 3 * time.Second,
+			}))
+			require.NoError(t, s.Comment("ping"))
+			require.Equal(t, ErrInvalidField, s.Send(Event{Event: "a\nb"}))
+			require.Equal(t, ErrInvalidField, s.Send(Event{ID: "a\rb"}))
+			return nil
+		})
+
+		require.NoError(t, resp.Reveal().Stream(w))
+		require.Equal(t, []string{
+			"data: hello\n\n",
+			"event: update\nid: 43\nretry: 3000\ndata: first\ndata: second\ndata: \ndata: last\n\n",
+			":ping\n\n",
+		}, w.Flushed())
+	})
+
+	t.Run("heartbeat", func(t *testing.T) {
+		w := new(streamWriter)
+		resp := stream(t, Streamer{Heartbeat: time.Millisecond}, func(s *Stream) error {
+			require.Eventually(t, func() bool {
+				return len(w.Flushed()) >= 2
+			}, 5*time.Second, time.Millisecond)
+			return nil
+		})
+
+		require.NoError(t, resp.Reveal().Stream(w))
+		flushed := w.Flushed()
+		require.Equal(t, ":\n\n", flushed[0])
+		// no heartbeats must be sent after the handler returned
+		time.Sleep(10 * time.Millisecond)
+		require.Equal(t, flushed, w.Flushed())
+	})
+
+	t.Run("client gone", func(t *testing.T) {
+		w := new(streamWriter)
+		resp := stream(t, Streamer{Heartbeat: time.Millisecond}, func(s *Stream) error {
+			w.Fail(io.ErrClosedPipe)
+
+			select {
+			case <-s.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("the stream wasn't closed")
+			}
+
+			require.Equal(t, io.ErrClosedPipe, s.Send(Event{Data: "hello"}))
+			return io.ErrClosedPipe
+		})
+
+		require.Equal(t, io.ErrClosedPipe, resp.Reveal().Stream(w))
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		w := &streamWriter{done: make(chan struct{})}
+		resp := stream(t, Streamer{Heartbeat: -1}, func(s *Stream) error {
+			close(w.done)
+
+			select {
+			case <-s.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("the stream wasn't closed")
+			}
+
+			return nil
+		})
+
+		require.NoError(t, resp.Reveal().Stream(w))
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		w := new(streamWriter)
+		var leaked *Stream
+		resp := stream(t, Streamer{}, func(s *Stream) error {
+			leaked = s
+			return nil
+		})
+
+		require.NoError(t, resp.Reveal().Stream(w))
+		require.Equal(t, ErrClosed, leaked.Send(Event{Data: "hello"}))
+		<-leaked.Done()
+	})
+}
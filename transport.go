@@ -13,26 +13,153 @@ import (
 	"github.com/indigo-web/indigo/http/serve"
 	"github.com/indigo-web/indigo/router"
 	"github.com/indigo-web/indigo/transport"
+	"github.com/indigo-web/indigo/transport/certs"
 	"golang.org/x/crypto/acme/autocert"
 	"math/big"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 )
 
 type Transport struct {
 	addr          string // must be left intact. Used by App entity only
 	inner         transport.Transport
-	spawnCallback func(cfg *config.Config, r router.Router) func(net.Conn)
+	spawnCallback func(cfg *config.Config, r router.Router, drain *transport.Drain, hs handshakeHook) func(net.Conn)
+}
+
+// WithProxyProtocol makes the transport expect the PROXY protocol (v1 or v2) header on
+// connections from the trusted networks. They're passed either in CIDR notation or as
+// plain IP addresses. No passed networks means everyone is trusted. Connections from
+// untrusted peers are served as usual, without the header expected. The header is waited
+// for no longer than config.NET.ProxyHeaderTimeout.
+//
+// The original source address is then available via Request.Remote, while the whole
+// header is in Request.Env.Proxy. Panics if a network is malformed or the transport
+// doesn't support the protocol.
+func (t Transport) WithProxyProtocol(trusted ...string) Transport {
+	inner, ok := t.inner.(interface {
+		EnableProxyProtocol(trusted []*net.IPNet)
+	})
+	if !ok {
+		panic("the transport doesn't support PROXY protocol")
+	}
+
+	networks := make([]*net.IPNet, 0, len(trusted))
+	for _, network := range trusted {
+		networks = append(networks, parseNetwork(network))
+	}
+
+	inner.EnableProxyProtocol(networks)
+
+	return t
+}
+
+// WithReusePort makes the transport bind n listeners on the same address with the
+// SO_REUSEPORT socket option, each having its own accept loop. The kernel then distributes
+// incoming connections between them, which reduces the contention on high connection rates.
+// Supported on Linux and BSD-like systems only. Panics if the transport doesn't support it.
+// Unix domain socket transports fail to bind if n is greater than 1.
+func (t Transport) WithReusePort(n int) Transport {
+	inner, ok := t.inner.(interface {
+		EnableReusePort(n int)
+	})
+	if !ok {
+		panic("the transport doesn't support SO_REUSEPORT")
+	}
+
+	inner.EnableReusePort(n)
+
+	return t
+}
+
+// WithClientAuth enables mutual TLS. Client certificates are verified against the pool
+// of certificate authorities, which can be loaded via CertPool. Usually the auth is either
+// tls.RequireAndVerifyClientCert or tls.VerifyClientCertIfGiven. The verified chain is
+// then available via Request.Env.TLS.VerifiedChains. Panics if the transport isn't TLS.
+func (t Transport) WithClientAuth(auth tls.ClientAuthType, cas *x509.CertPool) Transport {
+	inner, ok := t.inner.(interface {
+		SetClientAuth(auth tls.ClientAuthType, cas *x509.CertPool)
+	})
+	if !ok {
+		panic("the transport doesn't support client certificates")
+	}
+
+	inner.SetClientAuth(auth, cas)
+
+	return t
+}
+
+// WithoutHTTP2 stops offering HTTP/2 via ALPN, so all the clients are served over HTTP/1.1.
+// Panics if the transport isn't TLS.
+func (t Transport) WithoutHTTP2() Transport {
+	inner, ok := t.inner.(interface {
+		DisableHTTP2()
+	})
+	if !ok {
+		panic("the transport doesn't support HTTP/2")
+	}
+
+	inner.DisableHTTP2()
+
+	return t
 }
 
 func TCP() Transport {
 	return Transport{
 		inner: transport.NewTCP(),
-		spawnCallback: func(cfg *config.Config, r router.Router) func(net.Conn) {
+		spawnCallback: func(cfg *config.Config, r router.Router, drain *transport.Drain, hs handshakeHook) func(net.Conn) {
+			return func(conn net.Conn) {
+				serve.HTTP1(cfg, conn, crypt.Plain, r, drain)
+			}
+		},
+	}
+}
+
+// FromListener returns a transport, serving an already bound listener instead of binding
+// its own one. The address passed to App.Listen is then used only for reporting via the
+// OnBind callback, so usually l.Addr().String() is passed. The listener is closed as soon
+// as the application stops.
+//
+// Listeners wrapped via tls.NewListener are supported as well: the handshake is completed
+// before serving the connection. HTTP/2 is served, if it was negotiated via the
+// tls.Config.NextProtos of the listener.
+func FromListener(l net.Listener) Transport {
+	return Transport{
+		inner: transport.NewTCPFromListener(l),
+		spawnCallback: func(cfg *config.Config, r router.Router, drain *transport.Drain, hs handshakeHook) func(net.Conn) {
+			return func(conn net.Conn) {
+				tlsConn, ok := conn.(*tls.Conn)
+				if !ok {
+					serve.HTTP1(cfg, conn, crypt.Plain, r, drain)
+					return
+				}
+
+				if enc, ok := handshake(cfg, tlsConn, hs); ok {
+					serveTLS(cfg, tlsConn, enc, r, drain)
+				}
+			}
+		},
+	}
+}
+
+// Unix returns a transport, listening on a Unix domain socket. The address passed to
+// App.Listen is used as a path to the socket file. Permissions and ownership of the
+// file are left untouched, use UnixWithPerm to specify them.
+func Unix() Transport {
+	return UnixWithPerm(0, -1, -1)
+}
+
+// UnixWithPerm returns a transport, listening on a Unix domain socket. The socket file
+// is created with the passed mode and owned by the passed uid and gid. Zero mode leaves
+// the mode as is, as well as -1 does for uid and gid.
+func UnixWithPerm(mode os.FileMode, uid, gid int) Transport {
+	return Transport{
+		inner: transport.NewUnix(mode, uid, gid),
+		spawnCallback: func(cfg *config.Config, r router.Router, drain *transport.Drain, hs handshakeHook) func(net.Conn) {
 			return func(conn net.Conn) {
-				serve.HTTP1(cfg, conn, crypt.Plain, r)
+				serve.HTTP1(cfg, conn, crypt.Plain, r, drain)
 			}
 		},
 	}
@@ -46,6 +173,12 @@ func TLS(certs ...tls.Certificate) Transport {
 	return newTLSTransport(&tls.Config{Certificates: certs})
 }
 
+// TLSWithManager returns a TLS transport, which takes certificates from the manager. This
+// allows selecting them by SNI and reloading on the fly, without restarting the listener.
+func TLSWithManager(m *certs.Manager) Transport {
+	return newTLSTransport(&tls.Config{GetCertificate: m.GetCertificate})
+}
+
 // Autocert tries to automatically issue a certificate for the given domains.
 // If operation succeeds, those will be (hopefully) saved into the default cache
 // directory, which depends on the OS. If you want to specify the cache directory,
@@ -98,18 +231,100 @@ func Cert(cert, key string) tls.Certificate {
 	return c
 }
 
+// CertPool loads PEM-encoded certificate authorities from the files, e.g. to verify client
+// certificates against them. Panics if an error happened.
+func CertPool(files ...string) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			panic(fmt.Errorf("could not load certificate authority: %s", err))
+		}
+
+		if !pool.AppendCertsFromPEM(data) {
+			panic(fmt.Errorf("could not load certificate authority: %s: no certificates found", file))
+		}
+	}
+
+	return pool
+}
+
+// newTLSTransport returns a TLS transport, offering both HTTP/2 and HTTP/1.1 via ALPN. The
+// config is cloned, and the protocols are appended to the NextProtos, if they're missing
 func newTLSTransport(cfg *tls.Config) Transport {
+	cfg = cfg.Clone()
+	for _, proto := range []string{"h2", "http/1.1"} {
+		if !slices.Contains(cfg.NextProtos, proto) {
+			cfg.NextProtos = append(cfg.NextProtos, proto)
+		}
+	}
+
 	return Transport{
 		inner: transport.NewTLS(cfg),
-		spawnCallback: func(cfg *config.Config, r router.Router) func(net.Conn) {
+		spawnCallback: func(cfg *config.Config, r router.Router, drain *transport.Drain, hs handshakeHook) func(net.Conn) {
 			return func(conn net.Conn) {
-				ver := conn.(*tls.Conn).ConnectionState().Version
-				serve.HTTP1(cfg, conn, mapTLS(ver), r)
+				tlsConn := conn.(*tls.Conn)
+				if enc, ok := handshake(cfg, tlsConn, hs); ok {
+					serveTLS(cfg, tlsConn, enc, r, drain)
+				}
 			}
 		},
 	}
 }
 
+// serveTLS serves the connection with the protocol, negotiated via ALPN
+func serveTLS(
+	cfg *config.Config, conn *tls.Conn, enc crypt.Encryption, r router.Router, drain *transport.Drain,
+) {
+	if conn.ConnectionState().NegotiatedProtocol == "h2" {
+		serve.HTTP2(cfg, conn, enc, r, drain)
+		return
+	}
+
+	serve.HTTP1(cfg, conn, enc, r, drain)
+}
+
+// handshakeHook is notified about failed TLS handshakes. Nil is a no-op
+type handshakeHook func(remote net.Addr, err error)
+
+// handshake completes the TLS handshake, so the connection state is known before serving.
+// As it precedes the request, it's limited by the header read timeout.
+func handshake(cfg *config.Config, conn *tls.Conn, hook handshakeHook) (enc crypt.Encryption, ok bool) {
+	if timeout := cfg.NET.HeaderReadTimeout; timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	if err := conn.Handshake(); err != nil {
+		if hook != nil {
+			hook(conn.RemoteAddr(), err)
+		}
+
+		return crypt.Unknown, false
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	return mapTLS(conn.ConnectionState().Version), true
+}
+
+func parseNetwork(network string) *net.IPNet {
+	if _, ipnet, err := net.ParseCIDR(network); err == nil {
+		return ipnet
+	}
+
+	ip := net.ParseIP(network)
+	if ip == nil {
+		panic(fmt.Errorf("malformed trusted network: %s", network))
+	}
+
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
 func mapTLS(ver uint16) crypt.Encryption {
 	switch ver {
 	case tls.VersionTLS10:
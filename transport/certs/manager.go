@@ -0,0 +1,371 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoCertificate = errors.New("no certificate available")
+
+// Manager selects certificates by the SNI, sent by the client, and reloads them as soon as
+// their files change, without restarting the listeners. It's meant to be used as the
+// tls.Config.GetCertificate callback.
+//
+// Reload errors never interrupt serving: the previously loaded certificate is kept and
+// the error is passed to the OnError callback instead.
+type Manager struct {
+	// reloadMu serializes reloads, while mu guards the index used during handshakes
+	reloadMu sync.Mutex
+	dir      string
+	entries  []*entry
+	onError  func(err error)
+
+	mu       sync.RWMutex
+	byName   map[string]*tls.Certificate
+	fallback *tls.Certificate
+}
+
+type entry struct {
+	certFile, keyFile string
+	names             []string
+	// stats of the files, as they were loaded
+	stats [2]os.FileInfo
+	cert  *tls.Certificate
+	// scanned entries are found in the directory, so they're dropped as soon as they
+	// disappear from it
+	scanned bool
+}
+
+// Pair locates the certificate and its private key.
+type Pair struct {
+	Cert, Key string
+}
+
+// New returns an empty manager. Certificates are added via Manager.Add.
+func New() *Manager {
+	return &Manager{
+		byName:  make(map[string]*tls.Certificate),
+		onError: func(error) {},
+	}
+}
+
+// Dir returns a manager, serving all the certificates from the directory. Every <name>.crt
+// file must be accompanied by the <name>.key file. New pairs, appeared in the directory,
+// are picked up on reload, while removed ones stop being served.
+func Dir(dir string) (*Manager, error) {
+	m := New()
+	m.dir = dir
+
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	if err := m.scan(); err != nil {
+		return nil, err
+	}
+
+	if err := m.loadAll(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Map returns a manager, serving the certificates for the names they're mapped to. Names
+// are matched just like the ones passed to Manager.Add. The pair of the alphabetically
+// first name is used for clients, which didn't send the SNI or sent an unknown one.
+func Map(pairs map[string]Pair) (*Manager, error) {
+	names := make([]string, 0, len(pairs))
+	for name := range pairs {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	m := New()
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	// names sharing the pair share the entry as well, so it's loaded just once
+	entries := make(map[Pair]*entry, len(pairs))
+	for _, name := range names {
+		pair := pairs[name]
+		e, found := entries[pair]
+		if !found {
+			e = &entry{certFile: pair.Cert, keyFile: pair.Key}
+			entries[pair] = e
+			m.entries = append(m.entries, e)
+		}
+
+		e.names = append(e.names, name)
+	}
+
+	if err := m.loadAll(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Add loads the certificate/key pair, which is then served for the passed names. If no
+// names are passed, the DNS names from the certificate itself are used, falling back
+// to its common name. Wildcard names like *.example.com are supported. The first added
+// certificate is used for clients, which didn't send the SNI or sent an unknown one.
+func (m *Manager) Add(certFile, keyFile string, names ...string) error {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	e := &entry{
+		certFile: certFile,
+		keyFile:  keyFile,
+		names:    names,
+	}
+	if err := e.load(); err != nil {
+		return err
+	}
+
+	m.entries = append(m.entries, e)
+	m.index()
+
+	return nil
+}
+
+// OnError sets the callback, which is called every time a reload fails.
+func (m *Manager) OnError(cb func(err error)) *Manager {
+	m.reloadMu.Lock()
+	m.onError = cb
+	m.reloadMu.Unlock()
+
+	return m
+}
+
+// Reload reloads all the certificates, whose files were modified since the last load.
+// If the manager serves a directory, it's scanned for new and removed pairs as well.
+func (m *Manager) Reload() {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	if len(m.dir) > 0 {
+		if err := m.scan(); err != nil {
+			m.onError(err)
+		}
+	}
+
+	for _, e := range m.entries {
+		if err := e.load(); err != nil {
+			m.onError(err)
+		}
+	}
+
+	m.index()
+}
+
+// Watch reloads the certificates every interval until the context is done. Blocks,
+// so it's usually run in a separate goroutine.
+func (m *Manager) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.Reload()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ReloadOnSignal reloads the certificates every time one of the signals (usually SIGHUP)
+// is received, until the context is done. Blocks, so it's usually run in a separate
+// goroutine.
+func (m *Manager) ReloadOnSignal(ctx context.Context, signals ...os.Signal) {
+	sigch := make(chan os.Signal, 1)
+	signal.Notify(sigch, signals...)
+	defer signal.Stop(sigch)
+
+	for {
+		select {
+		case <-sigch:
+			m.Reload()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// GetCertificate returns the certificate for the SNI. The exact name match is preferred
+// over the wildcard one.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if cert, found := m.byName[name]; found {
+		return cert, nil
+	}
+
+	if dot := strings.IndexByte(name, '.'); dot > 0 {
+		if cert, found := m.byName["*"+name[dot:]]; found {
+			return cert, nil
+		}
+	}
+
+	if m.fallback == nil {
+		return nil, ErrNoCertificate
+	}
+
+	return m.fallback, nil
+}
+
+// scan adds pairs from the directory, which aren't known yet, and drops the ones, which
+// aren't present anymore.
+func (m *Manager) scan() error {
+	certs, err := filepath.Glob(filepath.Join(m.dir, "*.crt"))
+	if err != nil {
+		return err
+	}
+
+	present := make(map[string]struct{}, len(certs))
+	for _, certFile := range certs {
+		present[certFile] = struct{}{}
+	}
+
+	known := make(map[string]struct{}, len(m.entries))
+	entries := m.entries[:0]
+	for _, e := range m.entries {
+		if _, found := present[e.certFile]; e.scanned && !found {
+			continue
+		}
+
+		known[e.certFile] = struct{}{}
+		entries = append(entries, e)
+	}
+
+	m.entries = entries
+
+	for _, certFile := range certs {
+		if _, found := known[certFile]; found {
+			continue
+		}
+
+		m.entries = append(m.entries, &entry{
+			certFile: certFile,
+			keyFile:  strings.TrimSuffix(certFile, ".crt") + ".key",
+			scanned:  true,
+		})
+	}
+
+	return nil
+}
+
+// loadAll loads all the entries, failing on the first error.
+func (m *Manager) loadAll() error {
+	for _, e := range m.entries {
+		if err := e.load(); err != nil {
+			return err
+		}
+	}
+
+	m.index()
+
+	return nil
+}
+
+// index rebuilds the names index. In case of conflicts, the certificate added first wins.
+func (m *Manager) index() {
+	byName := make(map[string]*tls.Certificate)
+	var fallback *tls.Certificate
+
+	for _, e := range m.entries {
+		if e.cert == nil {
+			// has never been loaded successfully
+			continue
+		}
+
+		if fallback == nil {
+			fallback = e.cert
+		}
+
+		for _, name := range e.serves() {
+			name = strings.ToLower(name)
+			if _, found := byName[name]; !found {
+				byName[name] = e.cert
+			}
+		}
+	}
+
+	m.mu.Lock()
+	m.byName, m.fallback = byName, fallback
+	m.mu.Unlock()
+}
+
+// load loads the pair, if any of its files was modified since the last time.
+func (e *entry) load() error {
+	stats, err := statFiles(e.certFile, e.keyFile)
+	if err != nil {
+		return err
+	}
+
+	if e.cert != nil && sameFile(e.stats[0], stats[0]) && sameFile(e.stats[1], stats[1]) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(e.certFile, e.keyFile)
+	if err != nil {
+		return fmt.Errorf("%s: %w", e.certFile, err)
+	}
+
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("%s: %w", e.certFile, err)
+		}
+	}
+
+	e.cert, e.stats = &cert, stats
+
+	return nil
+}
+
+// serves returns names, for which the certificate must be served.
+func (e *entry) serves() []string {
+	if len(e.names) > 0 {
+		return e.names
+	}
+
+	if len(e.cert.Leaf.DNSNames) > 0 {
+		return e.cert.Leaf.DNSNames
+	}
+
+	if cn := e.cert.Leaf.Subject.CommonName; len(cn) > 0 {
+		return []string{cn}
+	}
+
+	return nil
+}
+
+func statFiles(certFile, keyFile string) (stats [2]os.FileInfo, err error) {
+	if stats[0], err = os.Stat(certFile); err != nil {
+		return stats, err
+	}
+
+	stats[1], err = os.Stat(keyFile)
+
+	return stats, err
+}
+
+// sameFile reports whether the file is left intact. Any difference in the modification time
+// is considered a change, as replacements, e.g. via rename, may keep an older one.
+func sameFile(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
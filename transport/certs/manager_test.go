@@ -0,0 +1,177 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// issue writes a self-signed certificate for the names into <dir>/<file>.crt and .key
+func issue(t *testing.T, dir, file string, names ...string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, file+".crt")
+	keyFile = filepath.Join(dir, file+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certFile, keyFile
+}
+
+// touch pushes the modification time forward, so the change is noticed even on
+// filesystems with coarse timestamps
+func touch(t *testing.T, files ...string) {
+	future := time.Now().Add(time.Minute)
+	for _, file := range files {
+		require.NoError(t, os.Chtimes(file, future, future))
+	}
+}
+
+func serverName(t *testing.T, m *Manager, sni string) string {
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: sni})
+	require.NoError(t, err)
+
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestManager(t *testing.T) {
+	t.Run("sni", func(t *testing.T) {
+		dir := t.TempDir()
+		m := New()
+		require.NoError(t, m.Add(issue(t, dir, "a", "a.example.com")))
+		require.NoError(t, m.Add(issue(t, dir, "wildcard", "*.example.com")))
+		certFile, keyFile := issue(t, dir, "b", "b.example.com")
+		require.NoError(t, m.Add(certFile, keyFile, "b.example.com", "alias.org"))
+
+		require.Equal(t, "a.example.com", serverName(t, m, "a.example.com"))
+		require.Equal(t, "a.example.com", serverName(t, m, "A.Example.COM."))
+		require.Equal(t, "b.example.com", serverName(t, m, "alias.org"))
+		require.Equal(t, "*.example.com", serverName(t, m, "c.example.com"))
+		require.Equal(t, "a.example.com", serverName(t, m, ""))
+		require.Equal(t, "a.example.com", serverName(t, m, "unknown.org"))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, err := New().GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+		require.ErrorIs(t, err, ErrNoCertificate)
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		dir := t.TempDir()
+		m, err := Dir(dir)
+		require.NoError(t, err)
+
+		issue(t, dir, "site", "old.example.com")
+		m.Reload()
+		require.Equal(t, "old.example.com", serverName(t, m, ""))
+
+		certFile, keyFile := issue(t, dir, "site", "new.example.com")
+		touch(t, certFile, keyFile)
+		m.Reload()
+		require.Equal(t, "new.example.com", serverName(t, m, ""))
+	})
+
+	t.Run("replaced with older", func(t *testing.T) {
+		dir := t.TempDir()
+		issue(t, dir, "site", "old.example.com")
+		m, err := Dir(dir)
+		require.NoError(t, err)
+		require.Equal(t, "old.example.com", serverName(t, m, ""))
+
+		// the files are replaced atomically, keeping the older modification time
+		staging := t.TempDir()
+		certFile, keyFile := issue(t, staging, "site", "new.example.com")
+		past := time.Now().Add(-24 * time.Hour)
+		for _, file := range []string{certFile, keyFile} {
+			require.NoError(t, os.Chtimes(file, past, past))
+			require.NoError(t, os.Rename(file, filepath.Join(dir, filepath.Base(file))))
+		}
+
+		m.Reload()
+		require.Equal(t, "new.example.com", serverName(t, m, ""))
+	})
+
+	t.Run("reload error", func(t *testing.T) {
+		dir := t.TempDir()
+		certFile, keyFile := issue(t, dir, "site", "example.com")
+		m, err := Dir(dir)
+		require.NoError(t, err)
+
+		var reloadErr error
+		m.OnError(func(err error) {
+			reloadErr = err
+		})
+
+		require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0600))
+		touch(t, certFile, keyFile)
+		m.Reload()
+		require.Error(t, reloadErr)
+		// the previous certificate is still served
+		require.Equal(t, "example.com", serverName(t, m, "example.com"))
+	})
+
+	t.Run("removed from dir", func(t *testing.T) {
+		dir := t.TempDir()
+		certFile, keyFile := issue(t, dir, "a", "a.example.com")
+		issue(t, dir, "b", "b.example.com")
+		m, err := Dir(dir)
+		require.NoError(t, err)
+		require.Equal(t, "a.example.com", serverName(t, m, "a.example.com"))
+
+		require.NoError(t, os.Remove(certFile))
+		require.NoError(t, os.Remove(keyFile))
+		m.Reload()
+		require.Equal(t, "b.example.com", serverName(t, m, "a.example.com"))
+	})
+
+	t.Run("map", func(t *testing.T) {
+		dir := t.TempDir()
+		certFile, keyFile := issue(t, dir, "a", "a.example.com")
+		a := Pair{Cert: certFile, Key: keyFile}
+		certFile, keyFile = issue(t, dir, "b", "b.example.com")
+		m, err := Map(map[string]Pair{
+			"a.example.com": a,
+			"alias.org":     a,
+			"*.example.com": {Cert: certFile, Key: keyFile},
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, "a.example.com", serverName(t, m, "a.example.com"))
+		require.Equal(t, "a.example.com", serverName(t, m, "alias.org"))
+		require.Equal(t, "b.example.com", serverName(t, m, "c.example.com"))
+		// the alphabetically first name is the fallback
+		require.Equal(t, "b.example.com", serverName(t, m, "unknown.org"))
+
+		certFile, keyFile = issue(t, dir, "a", "renewed.example.com")
+		touch(t, certFile, keyFile)
+		m.Reload()
+		require.Equal(t, "renewed.example.com", serverName(t, m, "alias.org"))
+
+		_, err = Map(map[string]Pair{"example.com": {Cert: "missing.crt", Key: "missing.key"}})
+		require.Error(t, err)
+	})
+}
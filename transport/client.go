@@ -1,31 +1,42 @@
 package transport
 
 import (
+	"io"
 	"net"
 	"time"
 )
 
+// readFromChunk limits how many bytes are transmitted within a single write deadline,
+// when the data is handed off to the connection via ReadFrom
+const readFromChunk = 1024 * 1024
+
 type Client interface {
 	Read() ([]byte, error)
 	Unread([]byte)
 	Write([]byte) error
+	Writev(head, body []byte) error
 	Conn() net.Conn
 	Remote() net.Addr
 	Close() error
 }
 
 type client struct {
-	conn    net.Conn
-	buff    []byte
-	pending []byte
-	timeout time.Duration
+	conn         net.Conn
+	buff         []byte
+	pending      []byte
+	iovec        net.Buffers
+	merged       []byte
+	writeTimeout time.Duration
 }
 
-func NewClient(conn net.Conn, timeout time.Duration, buff []byte) Client {
+// NewClient returns a new client. Write timeout is applied to every write operation
+// separately, zero disables it. Read deadlines aren't managed by the client, so they
+// must be set directly on the connection.
+func NewClient(conn net.Conn, writeTimeout time.Duration, buff []byte) Client {
 	return &client{
-		buff:    buff,
-		conn:    conn,
-		timeout: timeout,
+		buff:         buff,
+		conn:         conn,
+		writeTimeout: writeTimeout,
 	}
 }
 
@@ -40,10 +51,6 @@ func (c *client) Read() ([]byte, error) {
 		return pending, nil
 	}
 
-	if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
-		return nil, err
-	}
-
 	n, err := c.conn.Read(c.buff)
 
 	return c.buff[:n], err
@@ -67,16 +74,114 @@ func (c *client) Conn() net.Conn {
 
 // Write writes data into the underlying connection
 func (c *client) Write(b []byte) error {
+	if c.writeTimeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return err
+		}
+	}
+
 	_, err := c.conn.Write(b)
 	return err
 }
 
-// Remote returns the remote address of the connection
+// Writev writes both buffers into the underlying connection. Connections supporting
+// vectored I/O, i.e. *net.TCPConn and *net.UnixConn, transmit them with a single writev(2),
+// so they don't need to be merged beforehand
+func (c *client) Writev(head, body []byte) error {
+	if c.writeTimeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return err
+		}
+	}
+
+	switch c.conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		// net.Buffers is consumed while being written, therefore a copy is passed
+		c.iovec = append(c.iovec[:0], head, body)
+		iovec := c.iovec
+		_, err := iovec.WriteTo(c.conn)
+		// don't keep the buffers reachable after they're written
+		clear(c.iovec)
+
+		return err
+	default:
+		// others, e.g. *tls.Conn, would otherwise be written once per buffer, producing
+		// a separate TLS record for the headers
+		c.merged = append(append(c.merged[:0], head...), body...)
+		_, err := c.conn.Write(c.merged)
+
+		return err
+	}
+}
+
+// ReadFrom implements io.ReaderFrom. If the connection implements it as well, as
+// *net.TCPConn does, the data is handed off to it, so files are transmitted via sendfile(2)
+// or splice(2) without being copied into the user space. The write timeout is applied to
+// every megabyte separately, so big files are limited by the transmission speed rather
+// than their size.
+func (c *client) ReadFrom(r io.Reader) (n int64, err error) {
+	// nested io.LimitedReader prevents the connection from recognizing the file, so
+	// the limit is rather respected here
+	remain := int64(-1)
+	if lr, ok := r.(*io.LimitedReader); ok {
+		r, remain = lr.R, lr.N
+	}
+
+	for remain != 0 {
+		if c.writeTimeout > 0 {
+			if err = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+				return n, err
+			}
+		}
+
+		chunk := int64(readFromChunk)
+		if remain > 0 {
+			chunk = min(chunk, remain)
+		}
+
+		var written int64
+		if rf, ok := c.conn.(io.ReaderFrom); ok {
+			written, err = rf.ReadFrom(&io.LimitedReader{R: r, N: chunk})
+		} else {
+			written, err = io.Copy(c.conn, &io.LimitedReader{R: r, N: chunk})
+		}
+
+		n += written
+		if remain > 0 {
+			remain -= written
+		}
+
+		if err != nil || written < chunk {
+			// either failed or the reader is exhausted
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// Remote returns the remote address of the connection. Peers of Unix domain sockets
+// are mostly unnamed and have no IP address, so they are represented by a *net.UnixAddr
+// with empty name
 func (c *client) Remote() net.Addr {
-	return c.conn.RemoteAddr()
+	addr := c.conn.RemoteAddr()
+	if local, ok := c.conn.LocalAddr().(*net.UnixAddr); ok && local != nil && isNilAddr(addr) {
+		return &net.UnixAddr{Net: local.Net}
+	}
+
+	return addr
 }
 
 // Close closes the connection
 func (c *client) Close() error {
 	return c.conn.Close()
 }
+
+func isNilAddr(addr net.Addr) bool {
+	if addr == nil {
+		return true
+	}
+
+	unix, ok := addr.(*net.UnixAddr)
+	return ok && unix == nil
+}
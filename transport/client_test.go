@@ -0,0 +1,49 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Writev(t *testing.T) {
+	t.Run("merged", func(t *testing.T) {
+		conn, peer := net.Pipe()
+		defer peer.Close()
+		client := NewClient(conn, 0, nil)
+		errch := make(chan error, 1)
+		go func() {
+			errch <- client.Writev([]byte("head"), []byte("body"))
+			_ = conn.Close()
+		}()
+
+		// a single read from the pipe never spans multiple writes
+		buff := make([]byte, 16)
+		n, err := peer.Read(buff)
+		require.NoError(t, err)
+		require.Equal(t, "headbody", string(buff[:n]))
+		require.NoError(t, <-errch)
+	})
+
+	t.Run("vectored", func(t *testing.T) {
+		server, err := net.Listen("tcp", "localhost:0")
+		require.NoError(t, err)
+		defer server.Close()
+
+		conn, err := net.Dial("tcp", server.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		peer, err := server.Accept()
+		require.NoError(t, err)
+		defer peer.Close()
+
+		client := NewClient(conn, 0, nil)
+		require.NoError(t, client.Writev([]byte("head"), []byte("body")))
+		buff := make([]byte, 8)
+		_, err = io.ReadFull(peer, buff)
+		require.NoError(t, err)
+		require.Equal(t, "headbody", string(buff))
+	})
+}
@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Drain notifies the connections about the beginning of the graceful shutdown. Connections,
+// waiting for the next request, are registered as idle, so they can be interrupted right
+// away instead of waiting until the idle timeout exceeds.
+type Drain struct {
+	flag atomic.Bool
+	once sync.Once
+	done chan struct{}
+	mu   sync.Mutex
+	idle map[net.Conn]struct{}
+}
+
+func NewDrain() *Drain {
+	return &Drain{
+		done: make(chan struct{}),
+		idle: make(map[net.Conn]struct{}),
+	}
+}
+
+// Start begins the draining. Idle connections are interrupted by exceeding their read
+// deadline. Subsequent calls are no-op.
+func (d *Drain) Start() {
+	d.once.Do(func() {
+		d.mu.Lock()
+		d.flag.Store(true)
+		close(d.done)
+
+		for conn := range d.idle {
+			_ = conn.SetReadDeadline(time.Now())
+		}
+		d.mu.Unlock()
+	})
+}
+
+// Draining tells whether the draining has begun.
+func (d *Drain) Draining() bool {
+	return d.flag.Load()
+}
+
+// Done returns a channel, which is closed as soon as the draining begins.
+func (d *Drain) Done() <-chan struct{} {
+	return d.done
+}
+
+// Idle registers the connection as waiting for the next request. False is returned, if
+// the draining has already begun, so the connection must be closed instead.
+func (d *Drain) Idle(conn net.Conn) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.flag.Load() {
+		return false
+	}
+
+	d.idle[conn] = struct{}{}
+
+	return true
+}
+
+// Busy unregisters the connection as idle. Afterward, it's up to the connection to reset
+// the read deadline, as it might've been exceeded by Start in the meantime.
+func (d *Drain) Busy(conn net.Conn) {
+	d.mu.Lock()
+	delete(d.idle, conn)
+	d.mu.Unlock()
+}
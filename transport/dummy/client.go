@@ -58,6 +58,10 @@ func (*CircularClient) Write([]byte) error {
 	return nil
 }
 
+func (*CircularClient) Writev(_, _ []byte) error {
+	return nil
+}
+
 func (c *CircularClient) Conn() net.Conn {
 	return NewNopConn()
 }
@@ -92,3 +96,8 @@ func (s *SinkholeWriter) Write(b []byte) error {
 	s.Data = append(s.Data, b...)
 	return nil
 }
+
+func (s *SinkholeWriter) Writev(head, body []byte) error {
+	s.Data = append(append(s.Data, head...), body...)
+	return nil
+}
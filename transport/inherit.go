@@ -0,0 +1,198 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listenFDsStart is the first inherited file descriptor, as defined by the systemd socket
+// activation protocol.
+const listenFDsStart = 3
+
+// readyFDEnv holds the file descriptor, which the child process writes into as soon as it's
+// bound all the transports. It's passed right after the listeners.
+const readyFDEnv = "INDIGO_READY_FD"
+
+// handoffTimeout limits how long to wait for the child process to get ready.
+const handoffTimeout = 30 * time.Second
+
+var errNotReady = errors.New("the child process exited without getting ready")
+
+var inherited struct {
+	once  sync.Once
+	mu    sync.Mutex
+	ls    []net.Listener
+	ready *os.File
+}
+
+// loadInherited picks up listeners, passed via the LISTEN_FDS environment variable either
+// by systemd or by a parent process during the restart. The variables are unset
+// afterward, so they won't be passed to our own children.
+func loadInherited() {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+		_ = os.Unsetenv(readyFDEnv)
+	}()
+
+	if pid := os.Getenv("LISTEN_PID"); len(pid) > 0 && pid != strconv.Itoa(os.Getpid()) {
+		// the variables are meant for someone else
+		return
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		l, err := inheritListener(fd)
+		if err != nil {
+			// not a listener, so it isn't ours
+			continue
+		}
+
+		inherited.ls = append(inherited.ls, l)
+	}
+
+	if fd, err := strconv.Atoi(os.Getenv(readyFDEnv)); err == nil && fd >= listenFDsStart+n {
+		inherited.ready = os.NewFile(uintptr(fd), "ready")
+	}
+}
+
+// NotifyReady must be called as soon as all the transports are bound. The inherited listeners,
+// which weren't claimed by any of them, are closed, and the parent process is notified, if
+// it awaits it.
+func NotifyReady() {
+	inherited.once.Do(loadInherited)
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+
+	closeAll(inherited.ls)
+	inherited.ls = nil
+
+	if inherited.ready != nil {
+		_, _ = inherited.ready.Write([]byte{1})
+		_ = inherited.ready.Close()
+		inherited.ready = nil
+	}
+}
+
+// takeInherited returns and forgets all the inherited listeners, bound to the address.
+func takeInherited(network, addr string) (ls []net.Listener) {
+	inherited.once.Do(loadInherited)
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+
+	rest := inherited.ls[:0]
+	for _, l := range inherited.ls {
+		if boundTo(l, network, addr) {
+			ls = append(ls, l)
+		} else {
+			rest = append(rest, l)
+		}
+	}
+
+	inherited.ls = rest
+
+	return ls
+}
+
+func boundTo(l net.Listener, network, addr string) bool {
+	switch laddr := l.Addr().(type) {
+	case *net.TCPAddr:
+		if network != "tcp" {
+			return false
+		}
+
+		want, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil || want.Port != laddr.Port {
+			return false
+		}
+
+		if len(want.IP) == 0 || want.IP.IsUnspecified() {
+			return laddr.IP.IsUnspecified()
+		}
+
+		return want.IP.Equal(laddr.IP)
+	case *net.UnixAddr:
+		return network == "unix" && laddr.Name == addr
+	default:
+		return false
+	}
+}
+
+// childEnv returns the environment of the child process, receiving the files via the
+// LISTEN_FDS environment variable, followed by the readiness one.
+func childEnv(files int) []string {
+	env := make([]string, 0, len(os.Environ())+2)
+	for _, v := range os.Environ() {
+		if !isListenEnv(v) {
+			env = append(env, v)
+		}
+	}
+
+	// LISTEN_PID is omitted, as the child's pid isn't known in advance
+	return append(env,
+		"LISTEN_FDS="+strconv.Itoa(files),
+		readyFDEnv+"="+strconv.Itoa(listenFDsStart+files),
+	)
+}
+
+// awaitReady blocks until the child process reports it's ready. An error is returned, if
+// it exits before or the context is done.
+func awaitReady(ctx context.Context, ready *os.File) error {
+	ctx, cancel := context.WithTimeout(ctx, handoffTimeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			return errNotReady
+		}
+
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func isListenEnv(v string) bool {
+	return strings.HasPrefix(v, "LISTEN_PID=") ||
+		strings.HasPrefix(v, "LISTEN_FDS=") ||
+		strings.HasPrefix(v, "LISTEN_FDNAMES=") ||
+		strings.HasPrefix(v, readyFDEnv+"=")
+}
+
+var errNotInheritable = errors.New("the listener can't be passed to a child process")
+
+// listenerFile returns a duplicate of the listener's file descriptor.
+func listenerFile(l net.Listener) (*os.File, error) {
+	if ul, ok := l.(*net.UnixListener); ok {
+		// otherwise the socket file is removed as soon as we stop, leaving the child
+		// without it
+		ul.SetUnlinkOnClose(false)
+	}
+
+	filer, ok := l.(interface {
+		File() (*os.File, error)
+	})
+	if !ok {
+		return nil, errNotInheritable
+	}
+
+	return filer.File()
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package transport
+
+import (
+	"net"
+	"os"
+)
+
+func spawn([]*os.File) (*os.Process, *os.File, error) {
+	return nil, nil, errNotInheritable
+}
+
+func inheritListener(int) (net.Listener, error) {
+	return nil, errNotInheritable
+}
@@ -0,0 +1,100 @@
+//go:build unix
+
+package transport
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// spawn starts the current executable with the same arguments, passing it the files
+// via the LISTEN_FDS environment variable. The returned file is closed as soon as the child
+// exits, or receives a byte once it gets ready.
+//
+// The files aren't passed via os/exec, as it switches them into the blocking mode. As it's
+// shared by all the duplicates of the descriptor, accepting on our own listeners would've
+// blocked as well, so they couldn't be closed anymore.
+func spawn(files []*os.File) (*os.Process, *os.File, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ready, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the parent's copy of the writing end must be closed, otherwise reading never
+	// ends even if the child exits
+	defer w.Close()
+
+	fds := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+	for _, f := range append(files[:len(files):len(files)], w) {
+		fd, err := rawFD(f)
+		if err != nil {
+			_ = ready.Close()
+			return nil, nil, err
+		}
+
+		fds = append(fds, fd)
+	}
+
+	pid, err := syscall.ForkExec(exe, append([]string{exe}, os.Args[1:]...), &syscall.ProcAttr{
+		Env:   childEnv(len(files)),
+		Files: fds,
+	})
+	if err != nil {
+		_ = ready.Close()
+		return nil, nil, err
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		_ = ready.Close()
+		return nil, nil, err
+	}
+
+	return process, ready, nil
+}
+
+// rawFD returns the file descriptor of the file, leaving its mode intact.
+func rawFD(f *os.File) (fd uintptr, err error) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	err = conn.Control(func(raw uintptr) {
+		fd = raw
+	})
+
+	return fd, err
+}
+
+// inheritListener turns the inherited file descriptor into a listener. It's duplicated
+// first, so if it turns out not to be a listener, the descriptor is left intact.
+func inheritListener(fd int) (net.Listener, error) {
+	syscall.ForkLock.RLock()
+	dup, err := syscall.Dup(fd)
+	if err == nil {
+		syscall.CloseOnExec(dup)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(dup), "listener")
+	l, err := net.FileListener(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// the listener holds a duplicate of its own, so the inherited one isn't needed anymore
+	_ = syscall.Close(fd)
+
+	return l, nil
+}
@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"github.com/indigo-web/indigo/config"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// rejectTimeout limits how long may writing the rejection response last
+const rejectTimeout = time.Second
+
+var serviceUnavailable = []byte(
+	"HTTP/1.1 503 Service Unavailable\r\n" +
+		"Connection: close\r\n" +
+		"Content-Length: 0\r\n\r\n",
+)
+
+// limiter restricts the number of concurrent connections, both in total and per remote
+// IP address. Zero limit disables the respective restriction.
+type limiter struct {
+	maxTotal, maxPerIP int
+	mu                 sync.Mutex
+	total              int
+	perIP              map[netip.Addr]int
+}
+
+func newLimiter(cfg config.NET) *limiter {
+	return &limiter{
+		maxTotal: cfg.MaxConnections,
+		maxPerIP: cfg.MaxConnectionsPerIP,
+		perIP:    make(map[netip.Addr]int),
+	}
+}
+
+// Acquire reserves a slot for a new connection. Returns false if the total limit
+// is already reached.
+func (l *limiter) Acquire() bool {
+	if l.maxTotal == 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.total >= l.maxTotal {
+		return false
+	}
+
+	l.total++
+	return true
+}
+
+func (l *limiter) Release() {
+	if l.maxTotal == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	l.total--
+	l.mu.Unlock()
+}
+
+// AcquireIP reserves a slot for a new connection from the remote address. Returns false
+// if the per-IP limit is already reached. Non-IP addresses (e.g. of Unix sockets) are
+// never limited.
+func (l *limiter) AcquireIP(addr net.Addr) (ip netip.Addr, ok bool) {
+	if l.maxPerIP == 0 {
+		return ip, true
+	}
+
+	tcpAddr, isTCP := addr.(*net.TCPAddr)
+	if !isTCP {
+		return ip, true
+	}
+
+	ip = tcpAddr.AddrPort().Addr().Unmap()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.perIP[ip] >= l.maxPerIP {
+		return netip.Addr{}, false
+	}
+
+	l.perIP[ip]++
+	return ip, true
+}
+
+// ReleaseIP frees the slot, reserved by AcquireIP.
+func (l *limiter) ReleaseIP(ip netip.Addr) {
+	if !ip.IsValid() {
+		return
+	}
+
+	l.mu.Lock()
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+	l.mu.Unlock()
+}
+
+// reject closes the connection, which exceeded the limits, according to the policy.
+func reject(conn net.Conn, policy config.LimitPolicy) {
+	if policy == config.LimitRespond {
+		_ = conn.SetDeadline(time.Now().Add(rejectTimeout))
+		_, _ = conn.Write(serviceUnavailable)
+	}
+
+	_ = conn.Close()
+}
@@ -0,0 +1,182 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// Conn is a connection, which reads the PROXY protocol header lazily, on the first read
+// or address lookup. If the remote peer isn't trusted, the header isn't expected and
+// the connection is left intact.
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	trusted []*net.IPNet
+	timeout time.Duration
+	once    sync.Once
+	header  *Header
+	err     error
+	// deadline is the read deadline set by the user. It must be restored after the header
+	// is parsed, as parsing may be triggered by the read, for which the deadline is set
+	deadline time.Time
+}
+
+// NewConn returns a connection, expecting the header from the trusted networks no longer
+// than the timeout. Zero timeout disables it.
+func NewConn(conn net.Conn, trusted []*net.IPNet, timeout time.Duration) *Conn {
+	return &Conn{
+		Conn:    conn,
+		trusted: trusted,
+		timeout: timeout,
+	}
+}
+
+// Header returns the parsed header. It's nil if the peer isn't trusted.
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(c.parse)
+	return c.header, c.err
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+
+	if c.reader == nil {
+		return c.Conn.Read(b)
+	}
+
+	if c.reader.Buffered() == 0 {
+		// the data read in addition to the header is exhausted, so the buffered reader
+		// isn't needed anymore
+		c.reader = nil
+		return c.Conn.Read(b)
+	}
+
+	return c.reader.Read(b)
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// RemoteAddr returns the original source address, if provided by the proxy.
+func (c *Conn) RemoteAddr() net.Addr {
+	if header, err := c.Header(); err == nil && header != nil && header.Source != nil {
+		return header.Source
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the original destination address, if provided by the proxy.
+func (c *Conn) LocalAddr() net.Addr {
+	if header, err := c.Header(); err == nil && header != nil && header.Destination != nil {
+		return header.Destination
+	}
+
+	return c.Conn.LocalAddr()
+}
+
+func (c *Conn) parse() {
+	if !isTrusted(c.trusted, c.Conn.RemoteAddr()) {
+		return
+	}
+
+	deadline := c.deadline
+	if c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+
+	if !c.deadline.IsZero() && c.deadline.Before(deadline) {
+		deadline = c.deadline
+	}
+
+	if c.err = c.Conn.SetReadDeadline(deadline); c.err != nil {
+		return
+	}
+
+	c.reader = bufio.NewReaderSize(c.Conn, 256)
+	c.header, c.err = Parse(c.reader)
+	if c.err != nil {
+		return
+	}
+
+	c.err = c.Conn.SetReadDeadline(c.deadline)
+}
+
+// isTrusted reports whether the address belongs to any of the trusted networks. Empty
+// list trusts everyone. Peers without IP address (e.g. Unix domain sockets) are always
+// trusted, as they are local anyway.
+func isTrusted(trusted []*net.IPNet, addr net.Addr) bool {
+	if len(trusted) == 0 {
+		return true
+	}
+
+	var ip net.IP
+
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return true
+	}
+
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HeaderOf returns the PROXY protocol header of the connection, or nil if there's none.
+// Connections wrapping others, like *tls.Conn, are unwrapped.
+func HeaderOf(conn net.Conn) *Header {
+	for {
+		switch c := conn.(type) {
+		case *Conn:
+			header, _ := c.Header()
+			return header
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return nil
+		}
+	}
+}
+
+// Listener wraps accepted connections into Conn.
+type Listener struct {
+	net.Listener
+	trusted []*net.IPNet
+	// HeaderTimeout is passed to the accepted connections. Must be set before accepting.
+	HeaderTimeout time.Duration
+}
+
+func NewListener(l net.Listener, trusted []*net.IPNet) *Listener {
+	return &Listener{
+		Listener: l,
+		trusted:  trusted,
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConn(conn, l.trusted, l.HeaderTimeout), nil
+}
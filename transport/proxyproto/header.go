@@ -0,0 +1,130 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+type Command uint8
+
+const (
+	// Local means the connection was established by the proxy itself, e.g. for health
+	// checks. The addresses of the connection must be used as is then.
+	Local Command = iota
+	// Proxy means the connection was established on behalf of another node, which is
+	// described by the header.
+	Proxy
+)
+
+// TLV types as defined by the PROXY protocol specification, section 2.2.
+const (
+	TypeALPN      uint8 = 0x01
+	TypeAuthority uint8 = 0x02
+	TypeCRC32C    uint8 = 0x03
+	TypeNoop      uint8 = 0x04
+	TypeUniqueID  uint8 = 0x05
+	TypeSSL       uint8 = 0x20
+	TypeNetNS     uint8 = 0x30
+
+	subtypeSSLVersion uint8 = 0x21
+	subtypeSSLCN      uint8 = 0x22
+	subtypeSSLCipher  uint8 = 0x23
+	subtypeSSLSigAlg  uint8 = 0x24
+	subtypeSSLKeyAlg  uint8 = 0x25
+)
+
+// Client flags of the SSL TLV.
+const (
+	ClientSSL      uint8 = 0x01
+	ClientCertConn uint8 = 0x02
+	ClientCertSess uint8 = 0x04
+)
+
+// Header is the parsed PROXY protocol header.
+type Header struct {
+	// Version is either 1 for the text format or 2 for the binary one.
+	Version uint8
+	Command Command
+	// Source and Destination are the original addresses of the connection. They are nil
+	// if the proxy hasn't provided them (the command is Local, or the protocol is unknown).
+	Source, Destination net.Addr
+	// TLVs are type-length-value vectors, available only in version 2.
+	TLVs []TLV
+}
+
+// TLV is a type-length-value vector, carrying an additional information about
+// the connection.
+type TLV struct {
+	Type  uint8
+	Value []byte
+}
+
+// Lookup returns the value of the first TLV with the matching type.
+func (h *Header) Lookup(typ uint8) (value []byte, found bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// ALPN returns the application protocol negotiated between the client and the proxy.
+func (h *Header) ALPN() string {
+	value, _ := h.Lookup(TypeALPN)
+	return string(value)
+}
+
+// Authority returns the host name, the client has requested via SNI.
+func (h *Header) Authority() string {
+	value, _ := h.Lookup(TypeAuthority)
+	return string(value)
+}
+
+// SSL describes the TLS connection between the client and the proxy.
+type SSL struct {
+	// Client is a bit field of ClientSSL, ClientCertConn and ClientCertSess flags.
+	Client uint8
+	// Verify is zero if the client presented a certificate and it was successfully verified.
+	Verify  uint32
+	Version string
+	// CN is the Common Name field of the client certificate's Distinguished Name.
+	CN     string
+	Cipher string
+	SigAlg string
+	KeyAlg string
+}
+
+// SSL returns the information about the TLS connection between the client and the proxy.
+// If the proxy hasn't provided it or the TLV is malformed, false is returned.
+func (h *Header) SSL() (ssl SSL, ok bool) {
+	value, found := h.Lookup(TypeSSL)
+	if !found || len(value) < 5 {
+		return ssl, false
+	}
+
+	ssl.Client = value[0]
+	ssl.Verify = binary.BigEndian.Uint32(value[1:5])
+	subs, err := parseTLVs(value[5:])
+	if err != nil {
+		return ssl, false
+	}
+
+	for _, sub := range subs {
+		switch sub.Type {
+		case subtypeSSLVersion:
+			ssl.Version = string(sub.Value)
+		case subtypeSSLCN:
+			ssl.CN = string(sub.Value)
+		case subtypeSSLCipher:
+			ssl.Cipher = string(sub.Value)
+		case subtypeSSLSigAlg:
+			ssl.SigAlg = string(sub.Value)
+		case subtypeSSLKeyAlg:
+			ssl.KeyAlg = string(sub.Value)
+		}
+	}
+
+	return ssl, true
+}
@@ -0,0 +1,274 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoHeader      = errors.New("PROXY protocol header is missing")
+	ErrBadHeader     = errors.New("malformed PROXY protocol header")
+	ErrUnsupported   = errors.New("unsupported PROXY protocol version")
+	ErrTooLongHeader = errors.New("PROXY protocol header is too long")
+)
+
+const (
+	v1Prefix = "PROXY "
+	// v1MaxLength is the maximal length of the v1 header, including the CRLF
+	v1MaxLength = 107
+	v2Signature = "\r\n\r\n\x00\r\nQUIT\n"
+	// v2HeaderLength is the length of the fixed part of the v2 header
+	v2HeaderLength = len(v2Signature) + 4
+)
+
+// Parse reads the PROXY protocol header of either version. Nothing beyond the header
+// is consumed from the reader.
+func Parse(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch first[0] {
+	case v1Prefix[0]:
+		return parseV1(r)
+	case v2Signature[0]:
+		return parseV2(r)
+	default:
+		return nil, ErrNoHeader
+	}
+}
+
+func parseV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > v1MaxLength {
+			return nil, ErrTooLongHeader
+		}
+
+		switch err {
+		case nil:
+		case bufio.ErrBufferFull:
+			continue
+		default:
+			return nil, err
+		}
+
+		break
+	}
+
+	if !bytes.HasPrefix(line, []byte(v1Prefix)) || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrNoHeader
+	}
+
+	fields := strings.Split(string(line[len(v1Prefix):len(line)-2]), " ")
+	header := &Header{
+		Version: 1,
+		Command: Proxy,
+	}
+
+	switch fields[0] {
+	case "UNKNOWN":
+		// the rest of the line must be ignored by the receiver
+		header.Command = Local
+		return header, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, ErrBadHeader
+	}
+
+	if len(fields) != 5 {
+		return nil, ErrBadHeader
+	}
+
+	src, err := parseV1Addr(fields[0], fields[1], fields[3])
+	if err != nil {
+		return nil, err
+	}
+
+	dst, err := parseV1Addr(fields[0], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+
+	header.Source, header.Destination = src, dst
+
+	return header, nil
+}
+
+func parseV1Addr(family, ip, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || (family == "TCP4") != (addr.To4() != nil) {
+		return nil, ErrBadHeader
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, ErrBadHeader
+	}
+
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+const (
+	famUnspec = 0x0
+	famInet   = 0x1
+	famInet6  = 0x2
+	famUnix   = 0x3
+
+	transStream = 0x1
+	transDgram  = 0x2
+
+	inetAddrsLength  = 4 + 4 + 2 + 2
+	inet6AddrsLength = 16 + 16 + 2 + 2
+	unixAddrLength   = 108
+)
+
+func parseV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, v2HeaderLength)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+
+	if string(fixed[:len(v2Signature)]) != v2Signature {
+		return nil, ErrNoHeader
+	}
+
+	verCmd, famTrans := fixed[12], fixed[13]
+	if verCmd>>4 != 2 {
+		return nil, ErrUnsupported
+	}
+
+	header := &Header{
+		Version: 2,
+		Command: Command(verCmd & 0xf),
+	}
+	if header.Command != Local && header.Command != Proxy {
+		return nil, ErrBadHeader
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	if header.Command == Local {
+		// the addresses must be ignored, however TLVs may be still useful
+		header.TLVs, _ = parseTLVs(skipAddrs(famTrans>>4, payload))
+		return header, nil
+	}
+
+	family, transport := famTrans>>4, famTrans&0xf
+	if transport != transStream && transport != transDgram && family != famUnspec {
+		return nil, ErrBadHeader
+	}
+
+	switch family {
+	case famUnspec:
+	case famInet:
+		if len(payload) < inetAddrsLength {
+			return nil, ErrBadHeader
+		}
+
+		header.Source, header.Destination = inetAddrs(transport, payload, 4)
+	case famInet6:
+		if len(payload) < inet6AddrsLength {
+			return nil, ErrBadHeader
+		}
+
+		header.Source, header.Destination = inetAddrs(transport, payload, 16)
+	case famUnix:
+		if len(payload) < 2*unixAddrLength {
+			return nil, ErrBadHeader
+		}
+
+		network := "unix"
+		if transport == transDgram {
+			network = "unixgram"
+		}
+
+		header.Source = &net.UnixAddr{Name: cstring(payload[:unixAddrLength]), Net: network}
+		header.Destination = &net.UnixAddr{Name: cstring(payload[unixAddrLength : 2*unixAddrLength]), Net: network}
+	default:
+		return nil, ErrBadHeader
+	}
+
+	tlvs, err := parseTLVs(skipAddrs(family, payload))
+	if err != nil {
+		return nil, err
+	}
+
+	header.TLVs = tlvs
+
+	return header, nil
+}
+
+func inetAddrs(transport byte, payload []byte, ipLen int) (src, dst net.Addr) {
+	srcIP := net.IP(payload[:ipLen])
+	dstIP := net.IP(payload[ipLen : 2*ipLen])
+	srcPort := int(binary.BigEndian.Uint16(payload[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(payload[2*ipLen+2:]))
+
+	if transport == transDgram {
+		return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+	}
+
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+}
+
+func skipAddrs(family byte, payload []byte) []byte {
+	var length int
+
+	switch family {
+	case famInet:
+		length = inetAddrsLength
+	case famInet6:
+		length = inet6AddrsLength
+	case famUnix:
+		length = 2 * unixAddrLength
+	}
+
+	if length > len(payload) {
+		return nil
+	}
+
+	return payload[length:]
+}
+
+func parseTLVs(data []byte) (tlvs []TLV, err error) {
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, ErrBadHeader
+		}
+
+		length := int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) < 3+length {
+			return nil, ErrBadHeader
+		}
+
+		tlvs = append(tlvs, TLV{
+			Type:  data[0],
+			Value: data[3 : 3+length],
+		})
+		data = data[3+length:]
+	}
+
+	return tlvs, nil
+}
+
+func cstring(b []byte) string {
+	if end := bytes.IndexByte(b, 0); end != -1 {
+		b = b[:end]
+	}
+
+	return string(b)
+}
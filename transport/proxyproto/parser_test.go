@@ -0,0 +1,236 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func parse(t *testing.T, data string) (*Header, string, error) {
+	r := bufio.NewReader(strings.NewReader(data))
+	header, err := Parse(r)
+	rest, readErr := io.ReadAll(r)
+	require.NoError(t, readErr)
+
+	return header, string(rest), err
+}
+
+func v2(cmd, famTrans byte, payload []byte) string {
+	fixed := []byte(v2Signature + "\x00\x00\x00\x00")
+	fixed[12], fixed[13] = 0x20|cmd, famTrans
+	binary.BigEndian.PutUint16(fixed[14:], uint16(len(payload)))
+
+	return string(fixed) + string(payload)
+}
+
+func tlv(typ byte, value []byte) []byte {
+	return append([]byte{typ, byte(len(value) >> 8), byte(len(value))}, value...)
+}
+
+func TestV1(t *testing.T) {
+	t.Run("tcp4", func(t *testing.T) {
+		header, rest, err := parse(t, "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET / HTTP/1.1\r\n")
+		require.NoError(t, err)
+		require.Equal(t, uint8(1), header.Version)
+		require.Equal(t, Proxy, header.Command)
+		require.Equal(t, "192.168.0.1:56324", header.Source.String())
+		require.Equal(t, "192.168.0.11:443", header.Destination.String())
+		require.Equal(t, "GET / HTTP/1.1\r\n", rest)
+	})
+
+	t.Run("tcp6", func(t *testing.T) {
+		header, _, err := parse(t, "PROXY TCP6 ::1 2001:db8::1 1 2\r\n")
+		require.NoError(t, err)
+		require.Equal(t, "[::1]:1", header.Source.String())
+		require.Equal(t, "[2001:db8::1]:2", header.Destination.String())
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		header, rest, err := parse(t, "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\nhello")
+		require.NoError(t, err)
+		require.Equal(t, Local, header.Command)
+		require.Nil(t, header.Source)
+		require.Equal(t, "hello", rest)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		for _, data := range []string{
+			"PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n",
+			"PROXY TCP4 ::1 ::1 1 2\r\n",
+			"PROXY TCP4 192.168.0.1 192.168.0.11 56324 65536\r\n",
+			"PROXY TCP4 192.168.0.1 192.168.0.11 056324 443\r\n",
+			"PROXY UDP4 192.168.0.1 192.168.0.11 56324 443\r\n",
+			"PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\n",
+		} {
+			_, _, err := parse(t, data)
+			require.Error(t, err, data)
+		}
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		_, _, err := parse(t, "PROXY TCP4 "+strings.Repeat("1", v1MaxLength)+"\r\n")
+		require.EqualError(t, err, ErrTooLongHeader.Error())
+	})
+}
+
+func TestV2(t *testing.T) {
+	t.Run("inet", func(t *testing.T) {
+		payload := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x1f, 0x90, 0x01, 0xbb}
+		header, rest, err := parse(t, v2(0x1, 0x11, payload)+"GET")
+		require.NoError(t, err)
+		require.Equal(t, uint8(2), header.Version)
+		require.Equal(t, Proxy, header.Command)
+		require.Equal(t, "10.0.0.1:8080", header.Source.String())
+		require.Equal(t, "10.0.0.2:443", header.Destination.String())
+		require.Empty(t, header.TLVs)
+		require.Equal(t, "GET", rest)
+	})
+
+	t.Run("inet6", func(t *testing.T) {
+		payload := make([]byte, inet6AddrsLength)
+		copy(payload, net.ParseIP("2001:db8::1"))
+		copy(payload[16:], net.ParseIP("2001:db8::2"))
+		binary.BigEndian.PutUint16(payload[32:], 1)
+		binary.BigEndian.PutUint16(payload[34:], 2)
+		header, _, err := parse(t, v2(0x1, 0x21, payload))
+		require.NoError(t, err)
+		require.Equal(t, "[2001:db8::1]:1", header.Source.String())
+		require.Equal(t, "[2001:db8::2]:2", header.Destination.String())
+	})
+
+	t.Run("unix", func(t *testing.T) {
+		payload := make([]byte, 2*unixAddrLength)
+		copy(payload, "/var/run/src.sock")
+		copy(payload[unixAddrLength:], "/var/run/dst.sock")
+		header, _, err := parse(t, v2(0x1, 0x31, payload))
+		require.NoError(t, err)
+		require.Equal(t, "/var/run/src.sock", header.Source.String())
+		require.Equal(t, "/var/run/dst.sock", header.Destination.String())
+	})
+
+	t.Run("local", func(t *testing.T) {
+		header, rest, err := parse(t, v2(0x0, 0x00, nil)+"GET")
+		require.NoError(t, err)
+		require.Equal(t, Local, header.Command)
+		require.Nil(t, header.Source)
+		require.Equal(t, "GET", rest)
+	})
+
+	t.Run("tlvs", func(t *testing.T) {
+		ssl := []byte{ClientSSL | ClientCertConn, 0, 0, 0, 0}
+		ssl = append(ssl, tlv(subtypeSSLVersion, []byte("TLSv1.3"))...)
+		ssl = append(ssl, tlv(subtypeSSLCN, []byte("service.internal"))...)
+		ssl = append(ssl, tlv(subtypeSSLCipher, []byte("TLS_AES_128_GCM_SHA256"))...)
+
+		payload := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0, 1, 0, 2}
+		payload = append(payload, tlv(TypeALPN, []byte("h2"))...)
+		payload = append(payload, tlv(TypeAuthority, []byte("example.com"))...)
+		payload = append(payload, tlv(TypeSSL, ssl)...)
+
+		header, _, err := parse(t, v2(0x1, 0x11, payload))
+		require.NoError(t, err)
+		require.Len(t, header.TLVs, 3)
+		require.Equal(t, "h2", header.ALPN())
+		require.Equal(t, "example.com", header.Authority())
+
+		info, ok := header.SSL()
+		require.True(t, ok)
+		require.Equal(t, ClientSSL|ClientCertConn, info.Client)
+		require.Zero(t, info.Verify)
+		require.Equal(t, "TLSv1.3", info.Version)
+		require.Equal(t, "service.internal", info.CN)
+		require.Equal(t, "TLS_AES_128_GCM_SHA256", info.Cipher)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		for _, data := range []string{
+			v2(0x1, 0x11, []byte{10, 0, 0, 1}),
+			v2(0x1, 0x11, []byte{10, 0, 0, 1, 10, 0, 0, 2, 0, 1, 0, 2, TypeALPN, 0, 5, 'h'}),
+			v2(0x2, 0x11, make([]byte, inetAddrsLength)),
+			v2(0x1, 0x41, make([]byte, inetAddrsLength)),
+			v2(0x1, 0x11, make([]byte, inetAddrsLength))[:20],
+		} {
+			_, _, err := parse(t, data)
+			require.Error(t, err)
+		}
+
+		bad := []byte(v2(0x1, 0x11, make([]byte, inetAddrsLength)))
+		bad[12] = 0x11
+		_, _, err := parse(t, string(bad))
+		require.EqualError(t, err, ErrUnsupported.Error())
+	})
+}
+
+func TestNoHeader(t *testing.T) {
+	_, _, err := parse(t, "GET / HTTP/1.1\r\n\r\n")
+	require.EqualError(t, err, ErrNoHeader.Error())
+}
+
+func TestConn(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("127.0.0.0/8")
+	_, untrusted, _ := net.ParseCIDR("10.0.0.0/8")
+
+	t.Run("trusted", func(t *testing.T) {
+		client, server := pipe(t)
+		conn := NewConn(server, []*net.IPNet{trusted}, time.Second)
+		go func() {
+			_, _ = client.Write([]byte("PROXY TCP4 1.1.1.1 2.2.2.2 1 2\r\nhello"))
+		}()
+
+		require.Equal(t, "1.1.1.1:1", conn.RemoteAddr().String())
+		require.Equal(t, "2.2.2.2:2", conn.LocalAddr().String())
+		buff := make([]byte, 5)
+		_, err := io.ReadFull(conn, buff)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(buff))
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		client, server := pipe(t)
+		conn := NewConn(server, []*net.IPNet{untrusted}, time.Second)
+		go func() {
+			_, _ = client.Write([]byte("hello"))
+		}()
+
+		header, err := conn.Header()
+		require.NoError(t, err)
+		require.Nil(t, header)
+		require.Equal(t, server.RemoteAddr(), conn.RemoteAddr())
+		buff := make([]byte, 5)
+		_, err = io.ReadFull(conn, buff)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(buff))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		_, server := pipe(t)
+		conn := NewConn(server, []*net.IPNet{trusted}, 50*time.Millisecond)
+		_, err := conn.Header()
+		var netErr net.Error
+		require.ErrorAs(t, err, &netErr)
+		require.True(t, netErr.Timeout())
+	})
+}
+
+func pipe(t *testing.T) (client, server net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	client, err = net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	server, err = l.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+
+	return client, server
+}
@@ -0,0 +1,7 @@
+//go:build linux && (386 || amd64 || arm)
+
+package transport
+
+// soReusePort is missing in the syscall package on these architectures, even though
+// it's supported by the kernel since 3.9
+const soReusePort = 0xf
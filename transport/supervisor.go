@@ -1,17 +1,43 @@
 package transport
 
 import (
+	"context"
 	"github.com/indigo-web/indigo/config"
 	"net"
+	"os"
+	"sync"
+	"time"
 )
 
 type Supervisor struct {
-	ts     []boundTransport
-	stopch chan struct{}
+	ts    []boundTransport
+	drain *Drain
+	state *supervisorState
+}
+
+// supervisorState is shared between the calls of Run and Shutdown, which may happen in any
+// order and any number of times
+type supervisorState struct {
+	mu       sync.Mutex
+	started  bool
+	timeout  time.Duration
+	stopOnce sync.Once
+	// stopch is closed by the first Shutdown call, after stopCtx is set
+	stopch  chan struct{}
+	stopCtx context.Context
+	// donech is closed as soon as Run returns, after err is set
+	donech chan struct{}
+	err    error
 }
 
 func NewSupervisor() Supervisor {
-	return Supervisor{stopch: make(chan struct{})}
+	return Supervisor{
+		drain: NewDrain(),
+		state: &supervisorState{
+			stopch: make(chan struct{}),
+			donech: make(chan struct{}),
+		},
+	}
 }
 
 func (s *Supervisor) Add(addr string, transport Transport, cb func(net.Conn)) error {
@@ -29,11 +55,37 @@ func (s *Supervisor) Add(addr string, transport Transport, cb func(net.Conn)) er
 	return nil
 }
 
-func (s *Supervisor) Run(cfg config.NET) error {
+// Run starts all the transports and blocks until they are stopped. As soon as the context
+// is done, graceful shutdown is started, limited by config.NET.ShutdownTimeout. If Shutdown
+// was already called, the transports are closed and Run returns immediately.
+func (s *Supervisor) Run(ctx context.Context, cfg config.NET) error {
+	state := s.state
+	state.mu.Lock()
+	select {
+	case <-state.stopch:
+		state.mu.Unlock()
+		s.close()
+		return nil
+	default:
+	}
+
+	state.started = true
+	state.timeout = cfg.ShutdownTimeout
+	state.mu.Unlock()
+	defer close(state.donech)
+
 	if len(s.ts) == 0 {
 		return nil
 	}
 
+	// the connection limits are process-wide, so all the transports share them
+	limits := newLimiter(cfg)
+	for _, t := range s.ts {
+		if l, ok := t.t.(interface{ setLimiter(*limiter) }); ok {
+			l.setLimiter(limits)
+		}
+	}
+
 	errch := make(chan error)
 
 	for _, t := range s.ts {
@@ -44,36 +96,156 @@ func (s *Supervisor) Run(cfg config.NET) error {
 
 	select {
 	case err := <-errch:
-		s.stop()
-		drain(errch, len(s.ts)-1)
+		deadline, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		_ = s.shutdown(deadline, errch, len(s.ts)-1)
 
 		return err
-	case <-s.stopch:
-		s.stop()
-		drain(errch, len(s.ts))
-		s.stopch <- struct{}{}
+	case <-state.stopch:
+		state.err = s.shutdown(state.stopCtx, errch, len(s.ts))
+
+		return nil
+	case <-ctx.Done():
+		deadline, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		_ = s.shutdown(deadline, errch, len(s.ts))
 
 		return nil
 	}
 }
 
+// Drain returns the notifier of the graceful shutdown. Connections are expected to register
+// themselves as idle between requests and close after the current one, as soon as it begins.
+func (s *Supervisor) Drain() *Drain {
+	return s.drain
+}
+
+// Rejected returns the total number of connections, rejected by all the transports
+// due to exceeded limits.
+func (s *Supervisor) Rejected() (n uint64) {
+	for _, t := range s.ts {
+		n += t.t.Rejected()
+	}
+
+	return n
+}
+
+// Handoff starts a new instance of the current executable with the same arguments, passing
+// it the listeners via the LISTEN_FDS environment variable. The child picks them up on
+// binding the same addresses, so both processes accept connections from the same sockets
+// until the current one is stopped. Handoff returns as soon as the child is bound. If it
+// exits before, or doesn't get ready in time or until the context is done, it's killed and
+// an error is returned.
+func (s *Supervisor) Handoff(ctx context.Context) error {
+	var files []*os.File
+	defer func() {
+		closeFiles(files)
+	}()
+
+	for _, t := range s.ts {
+		filer, ok := t.t.(interface {
+			Files() ([]*os.File, error)
+		})
+		if !ok {
+			return errNotInheritable
+		}
+
+		fs, err := filer.Files()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, fs...)
+	}
+
+	child, ready, err := spawn(files)
+	if err != nil {
+		return err
+	}
+
+	defer ready.Close()
+
+	if err = awaitReady(ctx, ready); err != nil {
+		_ = child.Kill()
+		_, _ = child.Wait()
+		return err
+	}
+
+	return child.Release()
+}
+
+// Stop gracefully stops all the transports, waiting for every connection to be closed
+// no longer than config.NET.ShutdownTimeout.
 func (s *Supervisor) Stop() {
-	s.stopch <- struct{}{}
-	<-s.stopch
+	s.state.mu.Lock()
+	timeout := s.state.timeout
+	s.state.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_ = s.Shutdown(ctx)
+}
+
+// Shutdown stops accepting new connections and waits until all the alive connections
+// are closed. If the context is done before it happens, all the remaining connections
+// are forcibly closed and the context's error is returned. It's safe to call it multiple
+// times, before Run or after it has already returned.
+func (s *Supervisor) Shutdown(ctx context.Context) error {
+	state := s.state
+	state.stopOnce.Do(func() {
+		state.stopCtx = ctx
+		close(state.stopch)
+	})
+
+	state.mu.Lock()
+	started := state.started
+	state.mu.Unlock()
+	if !started {
+		// Run closes the transports as soon as it's called
+		return nil
+	}
+
+	<-state.donech
+
+	return state.err
 }
 
-func (s *Supervisor) stop() {
-	// TODO: add timer. If transports don't stop before the timeout, they must be
-	// TODO: brutally killed then. Also, probably should add some emergency stop
-	// TODO: signal, which will behave similarly
+func (s *Supervisor) shutdown(ctx context.Context, errch <-chan error, listeners int) (err error) {
+	s.drain.Start()
+
 	for _, t := range s.ts {
 		t.t.Stop()
 	}
 
-	for _, t := range s.ts {
-		t.t.Wait()
-		t.t.Close()
+	done := make(chan struct{})
+	go func() {
+		drain(errch, listeners)
+
+		for _, t := range s.ts {
+			t.t.Wait()
+		}
+
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+
+		for _, t := range s.ts {
+			// closing the listeners unblocks the pending accepts, so we don't have to wait
+			// until they interrupt by themselves
+			t.t.Close()
+			t.t.Kill()
+		}
+
+		<-done
 	}
+
+	s.close()
+
+	return err
 }
 
 func (s *Supervisor) close() {
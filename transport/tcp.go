@@ -1,8 +1,9 @@
 package transport
 
 import (
+	"context"
 	"github.com/indigo-web/indigo/config"
-	"github.com/indigo-web/indigo/internal/timer"
+	"github.com/indigo-web/indigo/transport/proxyproto"
 	"net"
 	"os"
 	"sync"
@@ -10,79 +11,327 @@ import (
 	"time"
 )
 
-type listener interface {
-	net.Listener
-	SetDeadline(t time.Time) error
-}
-
 type TCP struct {
-	l    listener
-	wg   *sync.WaitGroup
-	stop *atomic.Bool
+	ls []net.Listener
+	// raw listeners are kept unwrapped, so their file descriptors can be passed
+	// to a child process
+	raw       []net.Listener
+	preset    []net.Listener
+	wg        *sync.WaitGroup
+	stop      *atomic.Bool
+	conns     *connections
+	proxy     *proxyProtocol
+	rejected  *atomic.Uint64
+	reusePort int
+	// limits are shared by all the transports of the supervisor. If unset, the transport
+	// limits its own connections only
+	limits *limiter
 }
 
 func NewTCP() *TCP {
-	tcp := newTCP(nil)
+	tcp := newTCP()
 	return &tcp
 }
 
-func newTCP(l listener) TCP {
+// NewTCPFromListener returns a TCP transport, serving an already bound listener. The
+// address passed on binding is ignored.
+func NewTCPFromListener(l net.Listener) *TCP {
+	tcp := newTCP()
+	tcp.preset = []net.Listener{l}
+	return &tcp
+}
+
+// NewTCPFromFile returns a TCP transport, serving the listener represented by the file.
+// The file is duplicated, so it's up to the caller to close it.
+func NewTCPFromFile(f *os.File) (*TCP, error) {
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTCPFromListener(l), nil
+}
+
+func newTCP() TCP {
 	return TCP{
-		l:    l,
-		wg:   new(sync.WaitGroup),
-		stop: new(atomic.Bool),
+		wg:       new(sync.WaitGroup),
+		stop:     new(atomic.Bool),
+		conns:    newConnections(),
+		rejected: new(atomic.Uint64),
 	}
 }
 
-func bindTCP(addr string) (*net.TCPListener, error) {
-	tcpaddr, err := net.ResolveTCPAddr("tcp", addr)
+// bindTCP binds n listeners on the same address. If n is greater than 1, SO_REUSEPORT
+// is set on them, so the kernel distributes incoming connections between them.
+func bindTCP(addr string, n int) ([]net.Listener, error) {
+	var lc net.ListenConfig
+	if n > 1 {
+		lc.Control = reusePort
+	}
+
+	ls := make([]net.Listener, 0, max(n, 1))
+	for range cap(ls) {
+		l, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			closeAll(ls)
+			return nil, err
+		}
+
+		ls = append(ls, l)
+	}
+
+	return ls, nil
+}
+
+func (t *TCP) Bind(addr string) error {
+	ls, err := t.acquire("tcp", addr, func() ([]net.Listener, error) {
+		return bindTCP(addr, t.reusePort)
+	})
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	for _, l := range ls {
+		t.ls = append(t.ls, t.proxy.Wrap(l))
 	}
 
-	return net.ListenTCP("tcp", tcpaddr)
+	return nil
 }
 
-func (t *TCP) Bind(addr string) (err error) {
-	t.l, err = bindTCP(addr)
-	return err
+// acquire returns listeners to be served. Explicitly passed ones are preferred, then
+// inherited from the parent process and bound to the same address. If there are none,
+// new ones are bound.
+func (t *TCP) acquire(network, addr string, bind func() ([]net.Listener, error)) (ls []net.Listener, err error) {
+	if ls = t.preset; len(ls) == 0 {
+		if ls = takeInherited(network, addr); len(ls) == 0 {
+			if ls, err = bind(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	t.raw = ls
+
+	return ls, nil
 }
 
-func (t *TCP) Listen(cfg config.NET, cb func(conn net.Conn)) error {
-	for !t.stop.Load() {
-		err := t.l.SetDeadline(timer.Now().Add(cfg.AcceptLoopInterruptPeriod))
+// Files returns duplicates of the listeners' file descriptors, so they can be passed
+// to a child process. The listeners themselves are left intact.
+func (t *TCP) Files() ([]*os.File, error) {
+	files := make([]*os.File, 0, len(t.raw))
+	for _, l := range t.raw {
+		f, err := listenerFile(l)
 		if err != nil {
-			return err
+			closeFiles(files)
+			return nil, err
+		}
+
+		files = append(files, f)
+	}
+
+	return files, nil
+}
+
+// EnableProxyProtocol makes the transport expect the PROXY protocol header on every
+// connection from the trusted networks. Empty list trusts everyone. Must be called
+// before binding.
+func (t *TCP) EnableProxyProtocol(trusted []*net.IPNet) {
+	t.proxy = &proxyProtocol{trusted: trusted}
+}
+
+// EnableReusePort makes the transport bind n listeners on the same address with the
+// SO_REUSEPORT option, each served by its own accept loop. Binding fails on platforms,
+// where the option isn't supported. Must be called before binding.
+func (t *TCP) EnableReusePort(n int) {
+	t.reusePort = n
+}
+
+func (t *TCP) setLimiter(l *limiter) {
+	t.limits = l
+}
+
+func (t *TCP) Listen(cfg config.NET, cb func(conn net.Conn)) error {
+	t.proxy.SetTimeout(cfg.ProxyHeaderTimeout)
+	limits := t.limits
+	if limits == nil {
+		limits = newLimiter(cfg)
+	}
+
+	errch := make(chan error, len(t.ls))
+
+	for _, l := range t.ls {
+		go func(l net.Listener) {
+			errch <- t.accept(l, cfg, limits, cb)
+		}(l)
+	}
+
+	var err error
+	for range t.ls {
+		if e := <-errch; e != nil && err == nil {
+			err = e
+			// bring the rest of the accept loops down as well
+			t.Stop()
 		}
+	}
+
+	return err
+}
 
-		conn, err := t.l.Accept()
+func (t *TCP) accept(l net.Listener, cfg config.NET, limits *limiter, cb func(conn net.Conn)) error {
+	for {
+		conn, err := l.Accept()
 		if err != nil {
-			if err.(*net.OpError).Err.Error() == os.ErrDeadlineExceeded.Error() {
-				continue
+			if t.stop.Load() {
+				// the listener was closed by Stop
+				return nil
 			}
 
 			return err
 		}
 
-		go func(conn net.Conn) {
-			t.wg.Add(1)
-			cb(conn)
+		if t.stop.Load() {
+			// the transport is stopped and mustn't accept new connections anymore.
 			_ = conn.Close()
+			return nil
+		}
+
+		if !limits.Acquire() {
+			t.rejected.Add(1)
+			if cfg.LimitPolicy == config.LimitDrop {
+				_ = conn.Close()
+			} else {
+				// writing the response may block for a while, so don't hold the accept loop
+				t.wg.Add(1)
+				t.conns.Add(conn)
+
+				go func(conn net.Conn) {
+					reject(conn, cfg.LimitPolicy)
+					t.conns.Remove(conn)
+					t.wg.Done()
+				}(conn)
+			}
+
+			continue
+		}
+
+		t.wg.Add(1)
+		t.conns.Add(conn)
+
+		go func(conn net.Conn) {
+			// the remote address is resolved here, as it may require reading the PROXY
+			// protocol header first
+			if ip, ok := limits.AcquireIP(conn.RemoteAddr()); ok {
+				cb(conn)
+				limits.ReleaseIP(ip)
+				_ = conn.Close()
+			} else {
+				t.rejected.Add(1)
+				reject(conn, cfg.LimitPolicy)
+			}
+
+			limits.Release()
+			t.conns.Remove(conn)
 			t.wg.Done()
 		}(conn)
 	}
+}
 
-	return nil
+// Rejected returns the number of connections, rejected due to exceeded limits.
+func (t *TCP) Rejected() uint64 {
+	return t.rejected.Load()
 }
 
+// Stop stops accepting new connections by closing the listeners, which also interrupts
+// the pending accepts.
 func (t *TCP) Stop() {
 	t.stop.Store(true)
+	closeAll(t.ls)
 }
 
 func (t *TCP) Close() {
-	_ = t.l.Close()
+	closeAll(t.ls)
 }
 
 func (t *TCP) Wait() {
 	t.wg.Wait()
 }
+
+// Kill forcibly closes all the connections, that are still alive.
+func (t *TCP) Kill() {
+	t.conns.CloseAll()
+}
+
+type proxyProtocol struct {
+	trusted []*net.IPNet
+	ls      []*proxyproto.Listener
+}
+
+// Wrap returns the listener, which parses the PROXY protocol header on accepted
+// connections. If the protocol isn't enabled, the listener is returned as is.
+func (p *proxyProtocol) Wrap(l net.Listener) net.Listener {
+	if p == nil {
+		return l
+	}
+
+	pl := proxyproto.NewListener(l, p.trusted)
+	p.ls = append(p.ls, pl)
+
+	return pl
+}
+
+// SetTimeout sets the header timeout of all the wrapped listeners. As they're wrapped on
+// binding, the timeout is known only as they're about to be served.
+func (p *proxyProtocol) SetTimeout(timeout time.Duration) {
+	if p == nil {
+		return
+	}
+
+	for _, l := range p.ls {
+		l.HeaderTimeout = timeout
+	}
+}
+
+func closeAll(ls []net.Listener) {
+	for _, l := range ls {
+		_ = l.Close()
+	}
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
+
+// connections keeps track of all the currently alive connections, so they can be
+// forcibly closed at once.
+type connections struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func newConnections() *connections {
+	return &connections{
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+func (c *connections) Add(conn net.Conn) {
+	c.mu.Lock()
+	c.conns[conn] = struct{}{}
+	c.mu.Unlock()
+}
+
+func (c *connections) Remove(conn net.Conn) {
+	c.mu.Lock()
+	delete(c.conns, conn)
+	c.mu.Unlock()
+}
+
+func (c *connections) CloseAll() {
+	c.mu.Lock()
+	for conn := range c.conns {
+		_ = conn.Close()
+	}
+	c.mu.Unlock()
+}
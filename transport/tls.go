@@ -2,7 +2,10 @@ package transport
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"net"
+	"os"
+	"slices"
 )
 
 type TLS struct {
@@ -11,26 +14,58 @@ type TLS struct {
 }
 
 func NewTLS(cfg *tls.Config) *TLS {
-	return &TLS{cfg: cfg}
+	return &TLS{
+		cfg: cfg,
+		TCP: newTCP(),
+	}
 }
 
-func (t *TLS) Bind(addr string) error {
-	tcp, err := bindTCP(addr)
+// NewTLSFromListener returns a TLS transport, serving an already bound listener. The
+// listener must not perform the TLS handshake by itself. The address passed on binding
+// is ignored.
+func NewTLSFromListener(cfg *tls.Config, l net.Listener) *TLS {
+	t := NewTLS(cfg)
+	t.preset = []net.Listener{l}
+	return t
+}
+
+// NewTLSFromFile returns a TLS transport, serving the listener represented by the file.
+// The file is duplicated, so it's up to the caller to close it.
+func NewTLSFromFile(cfg *tls.Config, f *os.File) (*TLS, error) {
+	l, err := net.FileListener(f)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	l := tls.NewListener(tcp, t.cfg)
-	t.TCP = newTCP(tlsAdapter{tcp, l})
+	return NewTLSFromListener(cfg, l), nil
+}
 
-	return nil
+// SetClientAuth configures the verification of client certificates against the pool of
+// certificate authorities. Must be called before binding.
+func (t *TLS) SetClientAuth(auth tls.ClientAuthType, cas *x509.CertPool) {
+	t.cfg.ClientAuth = auth
+	t.cfg.ClientCAs = cas
 }
 
-type tlsAdapter struct {
-	*net.TCPListener
-	tls net.Listener
+// DisableHTTP2 stops offering HTTP/2 via ALPN. Must be called before binding.
+func (t *TLS) DisableHTTP2() {
+	t.cfg.NextProtos = slices.DeleteFunc(t.cfg.NextProtos, func(proto string) bool {
+		return proto == "h2"
+	})
 }
 
-func (t tlsAdapter) Accept() (net.Conn, error) {
-	return t.tls.Accept()
+func (t *TLS) Bind(addr string) error {
+	ls, err := t.acquire("tcp", addr, func() ([]net.Listener, error) {
+		return bindTCP(addr, t.reusePort)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, l := range ls {
+		// PROXY protocol header precedes the TLS handshake, so it must be parsed first
+		t.ls = append(t.ls, tls.NewListener(t.proxy.Wrap(l), t.cfg))
+	}
+
+	return nil
 }
@@ -8,7 +8,14 @@ import (
 type Transport interface {
 	Bind(addr string) error
 	Listen(cfg config.NET, cb func(conn net.Conn)) error
+	// Stop notifies the transport to stop accepting new connections.
 	Stop()
+	// Close closes the listener.
 	Close()
+	// Wait blocks until all the connections are closed.
 	Wait()
+	// Kill forcibly closes all the connections, that are still alive.
+	Kill()
+	// Rejected returns the number of connections, rejected due to exceeded limits.
+	Rejected() uint64
 }
@@ -0,0 +1,106 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+var errReusePortUnix = errors.New("SO_REUSEPORT isn't supported on Unix domain sockets")
+
+// Unix is a transport, listening on a Unix domain socket. A stale socket file, left by
+// a previous process, is removed on binding. If the socket is still in use by someone,
+// binding fails instead.
+type Unix struct {
+	perm     os.FileMode
+	uid, gid int
+	TCP
+}
+
+// NewUnix returns a new Unix transport. Zero perm leaves the socket file mode as is,
+// the same does -1 for uid and gid respectively.
+func NewUnix(perm os.FileMode, uid, gid int) *Unix {
+	return &Unix{
+		perm: perm,
+		uid:  uid,
+		gid:  gid,
+		TCP:  newTCP(),
+	}
+}
+
+func (u *Unix) Bind(path string) error {
+	if u.reusePort > 1 {
+		return errReusePortUnix
+	}
+
+	ls, err := u.acquire("unix", path, func() ([]net.Listener, error) {
+		l, err := u.bind(path)
+		return []net.Listener{l}, err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, l := range ls {
+		u.ls = append(u.ls, u.proxy.Wrap(l))
+	}
+
+	return nil
+}
+
+func (u *Unix) bind(path string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = u.applyPerms(path); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (u *Unix) applyPerms(path string) error {
+	if u.perm != 0 {
+		if err := os.Chmod(path, u.perm); err != nil {
+			return err
+		}
+	}
+
+	if u.uid != -1 || u.gid != -1 {
+		return os.Chown(path, u.uid, u.gid)
+	}
+
+	return nil
+}
+
+// removeStaleSocket removes the socket file if it exists, but nobody listens on it.
+func removeStaleSocket(path string) error {
+	stat, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if stat.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s: file exists and isn't a socket", path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s: socket is already in use", path)
+	}
+
+	return os.Remove(path)
+}
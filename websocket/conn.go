@@ -0,0 +1,563 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/indigo-web/indigo/transport"
+)
+
+type MessageType uint8
+
+const (
+	Text   MessageType = 1
+	Binary MessageType = 2
+)
+
+type opcode uint8
+
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xa
+)
+
+func (o opcode) isControl() bool {
+	return o&0x8 != 0
+}
+
+type CloseCode uint16
+
+const (
+	CloseNormal             CloseCode = 1000
+	CloseGoingAway          CloseCode = 1001
+	CloseProtocolError      CloseCode = 1002
+	CloseUnsupportedData    CloseCode = 1003
+	CloseNoStatus           CloseCode = 1005
+	CloseAbnormal           CloseCode = 1006
+	CloseInvalidPayload     CloseCode = 1007
+	ClosePolicyViolation    CloseCode = 1008
+	CloseTooLarge           CloseCode = 1009
+	CloseMandatoryExtension CloseCode = 1010
+	CloseInternalError      CloseCode = 1011
+)
+
+// maxControlPayload is the maximal payload size of control frames
+const maxControlPayload = 125
+
+const (
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	rsvBits = 0x70
+	maskBit = 0x80
+)
+
+var (
+	ErrClosed          = errors.New("websocket connection is closed")
+	ErrWriterActive    = errors.New("websocket: the previous message writer isn't closed")
+	errMessageTooLarge = &CloseError{Code: CloseTooLarge, Reason: "message is too large"}
+	errInvalidUTF8     = &CloseError{Code: CloseInvalidPayload, Reason: "invalid UTF-8"}
+)
+
+// CloseError is returned, when the connection is closed by a close frame. It's also used
+// to report protocol violations of the peer, as they fail the connection.
+type CloseError struct {
+	Code   CloseCode
+	Reason string
+}
+
+func (c *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", c.Code, c.Reason)
+}
+
+func protocolError(reason string) *CloseError {
+	return &CloseError{Code: CloseProtocolError, Reason: reason}
+}
+
+// Conn is a WebSocket connection. Reading isn't safe for concurrent use, however writing
+// is, so messages may be written from another goroutine while reading.
+type Conn struct {
+	client      transport.Client
+	subprotocol string
+	compress    bool
+	maxMessage  int
+	maxFrame    int
+	onPing      func(data []byte)
+	onPong      func(data []byte)
+
+	// reading state
+	header  [14]byte
+	message []byte
+	control []byte
+	readErr error
+	inflate deflater
+
+	wmu       sync.Mutex
+	wbuff     []byte
+	deflate   deflater
+	closeSent bool
+	writing   bool
+}
+
+func newConn(client transport.Client, maxMessage, maxFrame int) *Conn {
+	return &Conn{
+		client:     client,
+		maxMessage: maxMessage,
+		maxFrame:   maxFrame,
+		onPing:     func([]byte) {},
+		onPong:     func([]byte) {},
+	}
+}
+
+// Subprotocol returns the negotiated subprotocol, or an empty string if there's none.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Compressed tells whether the permessage-deflate extension was negotiated.
+func (c *Conn) Compressed() bool {
+	return c.compress
+}
+
+// OnPing sets the callback, which is called every time a ping is received. The pong
+// is sent automatically. The data must not be retained.
+func (c *Conn) OnPing(cb func(data []byte)) *Conn {
+	c.onPing = cb
+	return c
+}
+
+// OnPong sets the callback, which is called every time a pong is received. The data
+// must not be retained.
+func (c *Conn) OnPong(cb func(data []byte)) *Conn {
+	c.onPong = cb
+	return c
+}
+
+// Remote returns the remote address of the connection.
+func (c *Conn) Remote() net.Addr {
+	return c.client.Remote()
+}
+
+// SetReadDeadline sets the deadline for reading messages. Zero value disables it.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.client.Conn().SetReadDeadline(t)
+}
+
+// ReadMessage reads the next data message. Control frames, received in between, are
+// processed automatically. The returned data is valid until the next call. If the peer
+// closed the connection, *CloseError is returned, and the close is replied.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+
+	typ, data, err := c.readMessage()
+	if err != nil {
+		c.readErr = err
+		var closeErr *CloseError
+		if errors.As(err, &closeErr) {
+			// either reply the peer's close or fail the connection because of its violation
+			_ = c.writeClose(closeErr.Code, closeErr.Reason)
+		}
+
+		return 0, nil, err
+	}
+
+	return typ, data, nil
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	c.message = c.message[:0]
+	var (
+		typ        MessageType
+		compressed bool
+		started    bool
+	)
+
+	for {
+		fin, rsv1, op, length, mask, err := c.readHeader()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if op.isControl() {
+			if err = c.handleControl(op, length, mask); err != nil {
+				return 0, nil, err
+			}
+
+			continue
+		}
+
+		switch {
+		case !started && op == opContinuation:
+			return 0, nil, protocolError("unexpected continuation frame")
+		case started && op != opContinuation:
+			return 0, nil, protocolError("expected continuation frame")
+		case rsv1 && (started || !c.compress):
+			return 0, nil, protocolError("unexpected RSV1 bit")
+		case op != opContinuation && op != opText && op != opBinary:
+			return 0, nil, protocolError("unknown opcode")
+		}
+
+		if !started {
+			typ, compressed, started = MessageType(op), rsv1, true
+		}
+
+		if length > uint64(c.maxMessage-len(c.message)) {
+			return 0, nil, errMessageTooLarge
+		}
+
+		if c.message, err = c.readPayload(c.message, int(length), mask); err != nil {
+			return 0, nil, err
+		}
+
+		if fin {
+			break
+		}
+	}
+
+	if compressed {
+		decompressed, err := c.inflate.decompress(c.control[:0], c.message, c.maxMessage)
+		if err != nil {
+			if err != errMessageTooLarge {
+				err = &CloseError{Code: CloseInvalidPayload, Reason: "malformed compressed message"}
+			}
+
+			return 0, nil, err
+		}
+
+		// swap the buffers, so both are reused
+		c.message, c.control = decompressed, c.message
+	}
+
+	if typ == Text && !utf8.Valid(c.message) {
+		return 0, nil, errInvalidUTF8
+	}
+
+	return typ, c.message, nil
+}
+
+func (c *Conn) readHeader() (fin, rsv1 bool, op opcode, length uint64, mask [4]byte, err error) {
+	header, err := c.readFull(c.header[:2])
+	if err != nil {
+		return
+	}
+
+	fin, rsv1, op = header[0]&finBit != 0, header[0]&rsv1Bit != 0, opcode(header[0]&0xf)
+	if header[0]&rsvBits&^rsv1Bit != 0 {
+		err = protocolError("unexpected RSV bits")
+		return
+	}
+
+	if header[1]&maskBit == 0 {
+		err = protocolError("frames from the client must be masked")
+		return
+	}
+
+	length = uint64(header[1] &^ maskBit)
+	switch length {
+	case 126:
+		if header, err = c.readFull(c.header[2:4]); err != nil {
+			return
+		}
+
+		length = uint64(binary.BigEndian.Uint16(header))
+	case 127:
+		if header, err = c.readFull(c.header[2:10]); err != nil {
+			return
+		}
+
+		length = binary.BigEndian.Uint64(header)
+	}
+
+	if op.isControl() && (!fin || length > maxControlPayload) {
+		err = protocolError("malformed control frame")
+		return
+	}
+
+	if length > uint64(c.maxFrame) {
+		err = errMessageTooLarge
+		return
+	}
+
+	header, err = c.readFull(c.header[10:14])
+	copy(mask[:], header)
+
+	return
+}
+
+func (c *Conn) handleControl(op opcode, length uint64, mask [4]byte) (err error) {
+	c.control, err = c.readPayload(c.control[:0], int(length), mask)
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case opPing:
+		if err = c.writeFrame(true, false, opPong, c.control); err != nil {
+			return err
+		}
+
+		c.onPing(c.control)
+	case opPong:
+		c.onPong(c.control)
+	case opClose:
+		return parseClose(c.control)
+	default:
+		return protocolError("unknown opcode")
+	}
+
+	return nil
+}
+
+func parseClose(payload []byte) *CloseError {
+	switch {
+	case len(payload) == 0:
+		return &CloseError{Code: CloseNoStatus}
+	case len(payload) == 1:
+		return protocolError("malformed close frame")
+	}
+
+	code := CloseCode(binary.BigEndian.Uint16(payload))
+	if !isValidCloseCode(code) {
+		return protocolError("invalid close code")
+	}
+
+	reason := payload[2:]
+	if !utf8.Valid(reason) {
+		return errInvalidUTF8
+	}
+
+	return &CloseError{Code: code, Reason: string(reason)}
+}
+
+func isValidCloseCode(code CloseCode) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011, code >= 3000 && code <= 4999:
+		return true
+	default:
+		return false
+	}
+}
+
+// readFull fills the buffer from the client. Extra data is unread, so it isn't lost.
+func (c *Conn) readFull(buff []byte) ([]byte, error) {
+	for n := 0; n < len(buff); {
+		data, err := c.client.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		copied := copy(buff[n:], data)
+		c.client.Unread(data[copied:])
+		n += copied
+	}
+
+	return buff, nil
+}
+
+// readPayload appends the unmasked payload of the given length to the buffer.
+func (c *Conn) readPayload(buff []byte, length int, mask [4]byte) ([]byte, error) {
+	offset := len(buff)
+	buff = grow(buff, length)
+	if _, err := c.readFull(buff[offset:]); err != nil {
+		return nil, err
+	}
+
+	for i := range buff[offset:] {
+		buff[offset+i] ^= mask[i&3]
+	}
+
+	return buff, nil
+}
+
+func grow(buff []byte, n int) []byte {
+	if cap(buff)-len(buff) < n {
+		grown := make([]byte, len(buff), len(buff)+n)
+		copy(grown, buff)
+		buff = grown
+	}
+
+	return buff[:len(buff)+n]
+}
+
+// WriteMessage writes the data as a single message. If the compression was negotiated,
+// the message is compressed.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.writing {
+		return ErrWriterActive
+	}
+
+	if !c.compress {
+		return c.writeFrameLocked(true, false, opcode(typ), data)
+	}
+
+	compressed, err := c.deflate.compress(data)
+	if err != nil {
+		return err
+	}
+
+	return c.writeFrameLocked(true, true, opcode(typ), compressed)
+}
+
+// Writer returns a writer of a fragmented message. Every Write call sends a separate frame,
+// while the message is completed by Close. Fragmented messages aren't compressed. No other
+// messages may be written until the writer is closed, so ErrWriterActive is returned
+// in the meantime.
+func (c *Conn) Writer(typ MessageType) (io.WriteCloser, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.writing {
+		return nil, ErrWriterActive
+	}
+
+	c.writing = true
+
+	return &messageWriter{conn: c, op: opcode(typ)}, nil
+}
+
+// Ping sends a ping with the data, which must not exceed 125 bytes.
+func (c *Conn) Ping(data []byte) error {
+	return c.writeControl(opPing, data)
+}
+
+// Pong sends an unsolicited pong with the data, which must not exceed 125 bytes.
+func (c *Conn) Pong(data []byte) error {
+	return c.writeControl(opPong, data)
+}
+
+// Close sends the close frame with the code and reason and closes the connection.
+func (c *Conn) Close(code CloseCode, reason string) error {
+	err := c.writeClose(code, reason)
+	if closeErr := c.client.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func (c *Conn) writeClose(code CloseCode, reason string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closeSent {
+		return nil
+	}
+
+	var payload []byte
+	switch code {
+	case CloseNoStatus, CloseAbnormal:
+		// these codes must not be sent, as they designate the absence of the code
+	default:
+		payload = binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(reason)), uint16(code))
+		payload = append(payload, reason...)
+		if len(payload) > maxControlPayload {
+			payload = payload[:maxControlPayload]
+		}
+	}
+
+	err := c.writeFrameLocked(true, false, opClose, payload)
+	c.closeSent = true
+
+	return err
+}
+
+func (c *Conn) writeControl(op opcode, data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("websocket: control frame payload is too large")
+	}
+
+	return c.writeFrame(true, false, op, data)
+}
+
+func (c *Conn) writeFrame(fin, rsv1 bool, op opcode, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	return c.writeFrameLocked(fin, rsv1, op, payload)
+}
+
+// writeFrameLocked writes the frame. Server frames are never masked.
+func (c *Conn) writeFrameLocked(fin, rsv1 bool, op opcode, payload []byte) error {
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	b0 := byte(op)
+	if fin {
+		b0 |= finBit
+	}
+	if rsv1 {
+		b0 |= rsv1Bit
+	}
+
+	buff := append(c.wbuff[:0], b0)
+	switch length := len(payload); {
+	case length < 126:
+		buff = append(buff, byte(length))
+	case length <= 0xffff:
+		buff = binary.BigEndian.AppendUint16(append(buff, 126), uint16(length))
+	default:
+		buff = binary.BigEndian.AppendUint64(append(buff, 127), uint64(length))
+	}
+
+	buff = append(buff, payload...)
+	c.wbuff = buff
+
+	return c.client.Write(buff)
+}
+
+type messageWriter struct {
+	conn    *Conn
+	op      opcode
+	started bool
+	closed  bool
+}
+
+func (w *messageWriter) Write(b []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	if err := w.conn.writeFrame(false, false, w.opcode(), b); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+	c := w.conn
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.writing = false
+	return c.writeFrameLocked(true, false, w.opcode(), nil)
+}
+
+func (w *messageWriter) opcode() opcode {
+	if w.started {
+		return opContinuation
+	}
+
+	w.started = true
+	return w.op
+}
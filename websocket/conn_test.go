@@ -0,0 +1,260 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/indigo-web/indigo/transport"
+	"github.com/klauspost/compress/flate"
+	"github.com/stretchr/testify/require"
+)
+
+// frame renders a masked client frame
+func frame(fin bool, rsv1 bool, op opcode, payload []byte) []byte {
+	b0 := byte(op)
+	if fin {
+		b0 |= finBit
+	}
+	if rsv1 {
+		b0 |= rsv1Bit
+	}
+
+	buff := []byte{b0}
+	switch {
+	case len(payload) < 126:
+		buff = append(buff, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		buff = binary.BigEndian.AppendUint16(append(buff, maskBit|126), uint16(len(payload)))
+	default:
+		buff = binary.BigEndian.AppendUint64(append(buff, maskBit|127), uint64(len(payload)))
+	}
+
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	buff = append(buff, mask[:]...)
+	for i, c := range payload {
+		buff = append(buff, c^mask[i&3])
+	}
+
+	return buff
+}
+
+// readFrame reads a single unmasked server frame
+func readFrame(t *testing.T, r io.Reader) (fin, rsv1 bool, op opcode, payload []byte) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(r, header)
+	require.NoError(t, err)
+	require.Zero(t, header[1]&maskBit, "server frames must not be masked")
+
+	length := uint64(header[1])
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		_, err = io.ReadFull(r, ext)
+		require.NoError(t, err)
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		_, err = io.ReadFull(r, ext)
+		require.NoError(t, err)
+		length = binary.BigEndian.Uint64(ext)
+	}
+
+	payload = make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	require.NoError(t, err)
+
+	return header[0]&finBit != 0, header[0]&rsv1Bit != 0, opcode(header[0] & 0xf), payload
+}
+
+func newTestConn(t *testing.T, maxMessage int) (*Conn, net.Conn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+	require.NoError(t, client.SetDeadline(time.Now().Add(5*time.Second)))
+
+	return newConn(transport.NewClient(server, 0, make([]byte, 4096)), maxMessage, DefaultMaxFrameSize), client
+}
+
+func send(client net.Conn, frames ...[]byte) {
+	go func() {
+		_, _ = client.Write(bytes.Join(frames, nil))
+	}()
+}
+
+func requireClose(t *testing.T, client net.Conn, code CloseCode) {
+	_, _, op, payload := readFrame(t, client)
+	require.Equal(t, opClose, op)
+	require.Equal(t, code, CloseCode(binary.BigEndian.Uint16(payload)))
+}
+
+func TestConn(t *testing.T) {
+	t.Run("echo", func(t *testing.T) {
+		conn, client := newTestConn(t, DefaultMaxMessageSize)
+		send(client, frame(true, false, opText, []byte("Hello, world!")))
+
+		typ, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, Text, typ)
+		require.Equal(t, "Hello, world!", string(data))
+
+		go func() {
+			_ = conn.WriteMessage(Binary, bytes.Repeat([]byte("a"), 70000))
+		}()
+		fin, _, op, payload := readFrame(t, client)
+		require.True(t, fin)
+		require.Equal(t, opBinary, op)
+		require.Len(t, payload, 70000)
+	})
+
+	t.Run("fragmented with ping in between", func(t *testing.T) {
+		conn, client := newTestConn(t, DefaultMaxMessageSize)
+		var pinged []byte
+		conn.OnPing(func(data []byte) {
+			pinged = bytes.Clone(data)
+		})
+
+		send(client,
+			frame(false, false, opText, []byte("Hello, ")),
+			frame(true, false, opPing, []byte("ping")),
+			frame(true, false, opContinuation, []byte("world!")),
+		)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _, op, payload := readFrame(t, client)
+			require.Equal(t, opPong, op)
+			require.Equal(t, "ping", string(payload))
+		}()
+
+		typ, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, Text, typ)
+		require.Equal(t, "Hello, world!", string(data))
+		<-done
+		require.Equal(t, "ping", string(pinged))
+	})
+
+	t.Run("fragmented writer", func(t *testing.T) {
+		conn, client := newTestConn(t, DefaultMaxMessageSize)
+		go func() {
+			w, err := conn.Writer(Text)
+			require.NoError(t, err)
+			// the message must be completed first
+			_, err = conn.Writer(Binary)
+			require.ErrorIs(t, err, ErrWriterActive)
+			require.ErrorIs(t, conn.WriteMessage(Text, []byte("interleaved")), ErrWriterActive)
+
+			_, _ = w.Write([]byte("Hello, "))
+			_, _ = w.Write([]byte("world!"))
+			_ = w.Close()
+		}()
+
+		fin, _, op, payload := readFrame(t, client)
+		require.Equal(t, []any{false, opText, "Hello, "}, []any{fin, op, string(payload)})
+		fin, _, op, payload = readFrame(t, client)
+		require.Equal(t, []any{false, opContinuation, "world!"}, []any{fin, op, string(payload)})
+		fin, _, op, payload = readFrame(t, client)
+		require.Equal(t, []any{true, opContinuation, ""}, []any{fin, op, string(payload)})
+	})
+
+	t.Run("close handshake", func(t *testing.T) {
+		conn, client := newTestConn(t, DefaultMaxMessageSize)
+		payload := binary.BigEndian.AppendUint16(nil, uint16(CloseGoingAway))
+		send(client, frame(true, false, opClose, append(payload, "bye"...)))
+
+		go func() {
+			_, _, err := conn.ReadMessage()
+			var closeErr *CloseError
+			require.True(t, errors.As(err, &closeErr))
+			require.Equal(t, CloseGoingAway, closeErr.Code)
+			require.Equal(t, "bye", closeErr.Reason)
+		}()
+
+		requireClose(t, client, CloseGoingAway)
+	})
+
+	t.Run("invalid UTF-8", func(t *testing.T) {
+		conn, client := newTestConn(t, DefaultMaxMessageSize)
+		send(client, frame(true, false, opText, []byte{0xff, 0xfe}))
+		go func() {
+			_, _, err := conn.ReadMessage()
+			require.Equal(t, errInvalidUTF8, err)
+		}()
+
+		requireClose(t, client, CloseInvalidPayload)
+	})
+
+	t.Run("too large message", func(t *testing.T) {
+		conn, client := newTestConn(t, 10)
+		send(client,
+			frame(false, false, opBinary, []byte("12345678")),
+			frame(true, false, opContinuation, []byte("12345678")),
+		)
+		go func() {
+			_, _, err := conn.ReadMessage()
+			require.Equal(t, errMessageTooLarge, err)
+		}()
+
+		requireClose(t, client, CloseTooLarge)
+	})
+
+	t.Run("unmasked frame", func(t *testing.T) {
+		conn, client := newTestConn(t, DefaultMaxMessageSize)
+		send(client, []byte{finBit | byte(opText), 1, 'a'})
+		go func() {
+			_, _, _ = conn.ReadMessage()
+		}()
+
+		requireClose(t, client, CloseProtocolError)
+	})
+
+	t.Run("permessage-deflate", func(t *testing.T) {
+		conn, client := newTestConn(t, DefaultMaxMessageSize)
+		conn.compress = true
+		message := bytes.Repeat([]byte("compress me "), 100)
+
+		var compressed bytes.Buffer
+		w, err := flate.NewWriter(&compressed, flate.BestCompression)
+		require.NoError(t, err)
+		_, err = w.Write(message)
+		require.NoError(t, err)
+		require.NoError(t, w.Flush())
+		send(client, frame(true, true, opText, bytes.TrimSuffix(compressed.Bytes(), deflateTail[:4])))
+
+		typ, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, Text, typ)
+		require.Equal(t, message, data)
+
+		go func() {
+			_ = conn.WriteMessage(Text, message)
+		}()
+		_, rsv1, _, payload := readFrame(t, client)
+		require.True(t, rsv1)
+		require.Less(t, len(payload), len(message))
+		var inflater deflater
+		decompressed, err := inflater.decompress(nil, payload, len(message))
+		require.NoError(t, err)
+		require.Equal(t, message, decompressed)
+	})
+}
+
+func TestNegotiation(t *testing.T) {
+	require.True(t, acceptableDeflate("permessage-deflate"))
+	require.True(t, acceptableDeflate(" permessage-deflate; client_max_window_bits"))
+	require.True(t, acceptableDeflate("permessage-deflate; server_max_window_bits=15"))
+	require.False(t, acceptableDeflate("permessage-deflate; server_max_window_bits=10"))
+	require.False(t, acceptableDeflate("permessage-deflate; unknown"))
+	require.False(t, acceptableDeflate("x-webkit-deflate-frame"))
+
+	// the example from RFC 6455
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
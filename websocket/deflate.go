@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/indigo-web/indigo/http"
+	"github.com/klauspost/compress/flate"
+)
+
+// deflateResponse accepts the permessage-deflate extension. Context takeover is disabled
+// in both directions, so every message is compressed independently and no compression
+// state must be kept between them.
+const deflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// deflateTail is appended to the compressed message before decompression. The first four
+// bytes are stripped by the sender, as defined by RFC 7692, and the rest is an empty final
+// block, so the decompressor reports the end of the stream.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// offersDeflate tells whether the client offered the permessage-deflate extension with
+// the parameters, which can be accepted.
+func offersDeflate(request *http.Request) bool {
+	for _, value := range request.Headers.Values("sec-websocket-extensions") {
+		for _, offer := range strings.Split(value, ",") {
+			if acceptableDeflate(offer) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func acceptableDeflate(offer string) bool {
+	params := strings.Split(offer, ";")
+	if strings.TrimSpace(params[0]) != "permessage-deflate" {
+		return false
+	}
+
+	for _, param := range params[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch strings.TrimSpace(key) {
+		case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+		case "server_max_window_bits":
+			// the compressor always uses the maximal window
+			if strings.Trim(strings.TrimSpace(value), `"`) != "15" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+type deflater struct {
+	w    *flate.Writer
+	buff bytes.Buffer
+	r    io.ReadCloser
+	src  bytes.Reader
+	tail bytes.Reader
+}
+
+// compress returns the compressed data with the trailing empty block stripped. The
+// returned slice is valid until the next call.
+func (d *deflater) compress(data []byte) ([]byte, error) {
+	d.buff.Reset()
+	if d.w == nil {
+		var err error
+		if d.w, err = flate.NewWriter(&d.buff, flate.DefaultCompression); err != nil {
+			return nil, err
+		}
+	} else {
+		d.w.Reset(&d.buff)
+	}
+
+	if _, err := d.w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := d.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(d.buff.Bytes(), deflateTail[:4]), nil
+}
+
+// decompress appends the decompressed data to dst. If it exceeds the limit,
+// errMessageTooLarge is returned.
+func (d *deflater) decompress(dst, data []byte, limit int) ([]byte, error) {
+	d.src.Reset(data)
+	d.tail.Reset(deflateTail)
+	source := io.MultiReader(&d.src, &d.tail)
+
+	if d.r == nil {
+		d.r = flate.NewReader(source)
+	} else if err := d.r.(flate.Resetter).Reset(source, nil); err != nil {
+		return nil, err
+	}
+
+	for {
+		if len(dst) == cap(dst) {
+			dst = append(dst, 0)[:len(dst)]
+		}
+
+		n, err := d.r.Read(dst[len(dst):cap(dst)])
+		dst = dst[:len(dst)+n]
+		if len(dst) > limit {
+			return nil, errMessageTooLarge
+		}
+
+		switch err {
+		case nil:
+		case io.EOF:
+			return dst, nil
+		default:
+			return nil, err
+		}
+	}
+}
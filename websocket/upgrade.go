@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"strings"
+
+	"github.com/indigo-web/indigo/http"
+	"github.com/indigo-web/indigo/http/method"
+	"github.com/indigo-web/indigo/http/proto"
+	"github.com/indigo-web/indigo/http/status"
+	"github.com/indigo-web/indigo/internal/strutil"
+)
+
+// keyGUID is concatenated with the client's key in order to compute the accept key, as
+// defined by RFC 6455
+const keyGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	DefaultMaxMessageSize = 32 * 1024 * 1024 // 32mb
+	DefaultMaxFrameSize   = 16 * 1024 * 1024 // 16mb
+)
+
+var (
+	ErrBadHandshake       = status.NewError(status.BadRequest, "bad websocket handshake")
+	ErrUnsupportedVersion = status.NewError(status.UpgradeRequired, "unsupported websocket version")
+	ErrBadOrigin          = status.NewError(status.Forbidden, "websocket origin not allowed")
+)
+
+// Upgrader validates the opening handshake and switches the connection to the WebSocket
+// protocol. Zero value is ready to use.
+type Upgrader struct {
+	// Subprotocols are the supported subprotocols. The first one offered by the client,
+	// which is supported, is selected.
+	Subprotocols []string
+	// CheckOrigin returns whether the request's origin is allowed. By default, all the
+	// origins are allowed.
+	CheckOrigin func(request *http.Request) bool
+	// MaxMessageSize limits the size of a single message, after decompression as well.
+	// Defaults to DefaultMaxMessageSize.
+	MaxMessageSize int
+	// MaxFrameSize limits the size of a single frame. Defaults to DefaultMaxFrameSize.
+	MaxFrameSize int
+	// Compression enables the permessage-deflate extension, if the client supports it.
+	Compression bool
+}
+
+// Upgrade switches the connection to the WebSocket protocol with default settings.
+func Upgrade(request *http.Request) (*Conn, error) {
+	return Upgrader{}.Upgrade(request)
+}
+
+// Upgrade validates the opening handshake and writes the 101 Switching Protocols response.
+// If the handshake is malformed, an HTTP error is returned and may be responded with as
+// usual, otherwise the connection is hijacked.
+func (u Upgrader) Upgrade(request *http.Request) (*Conn, error) {
+	if request.Method != method.GET || request.Proto != proto.HTTP11 ||
+		!strutil.HasToken(request.Connection, "upgrade") ||
+		!strutil.HasToken(request.Headers.Value("upgrade"), "websocket") {
+		return nil, ErrBadHandshake
+	}
+
+	if request.Headers.Value("sec-websocket-version") != "13" {
+		return nil, ErrUnsupportedVersion
+	}
+
+	key := request.Headers.Value("sec-websocket-key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, ErrBadHandshake
+	}
+
+	if u.CheckOrigin != nil && !u.CheckOrigin(request) {
+		return nil, ErrBadOrigin
+	}
+
+	resp := request.Respond().
+		Header("Connection", "Upgrade").
+		Header("Upgrade", "websocket").
+		Header("Sec-WebSocket-Accept", acceptKey(key))
+
+	subprotocol := u.selectSubprotocol(request)
+	if len(subprotocol) > 0 {
+		resp.Header("Sec-WebSocket-Protocol", subprotocol)
+	}
+
+	compress := u.Compression && offersDeflate(request)
+	if compress {
+		resp.Header("Sec-WebSocket-Extensions", deflateResponse)
+	}
+
+	client, err := request.SwitchProtocols(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newConn(
+		client, either(u.MaxMessageSize, DefaultMaxMessageSize), either(u.MaxFrameSize, DefaultMaxFrameSize),
+	)
+	conn.subprotocol = subprotocol
+	conn.compress = compress
+
+	return conn, nil
+}
+
+func (u Upgrader) selectSubprotocol(request *http.Request) string {
+	for _, value := range request.Headers.Values("sec-websocket-protocol") {
+		for _, offered := range strings.Split(value, ",") {
+			offered = strings.TrimSpace(offered)
+			for _, supported := range u.Subprotocols {
+				if offered == supported {
+					return supported
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(keyGUID))
+
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func either(custom, defaultVal int) int {
+	if custom > 0 {
+		return custom
+	}
+
+	return defaultVal
+}